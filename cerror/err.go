@@ -18,5 +18,11 @@ func (e CodeError) Error() string {
 }
 
 const (
-	DB_ERROR = 121
+	DB_ERROR     = 121
+	DB_NOT_FOUND = 122
+	HTTP_ERROR   = 131
+	UNAUTHORIZED = 141
+	FORBIDDEN    = 142
+
+	TOO_MANY_REQUESTS = 151
 )
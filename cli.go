@@ -1,5 +1,124 @@
 package codex
 
-func main() {
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/zhin/go-codex/exthttp/apigen"
+)
+
+// Command 项目二进制的子命令，args 不含子命令名
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]*Command{}
+var commandLock = sync.Mutex{}
+
+func RegisterCommand(command *Command) {
+	commandLock.Lock()
+	defer commandLock.Unlock()
+	commands[command.Name] = command
+}
+
+// Execute 执行 args[0] 对应的子命令
+func Execute(args []string) error {
+	commandLock.Lock()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	var command *Command
+	if len(args) > 0 {
+		command = commands[args[0]]
+	}
+	commandLock.Unlock()
+
+	if command == nil {
+		sort.Strings(names)
+		usage := &strings.Builder{}
+		usage.WriteString("usage: codex <command> [options]\n\ncommands:\n")
+		for _, name := range names {
+			fmt.Fprintf(usage, "  %-12s %s\n", name, commands[name].Usage)
+		}
+		if len(args) > 0 && args[0] != "help" && args[0] != "-h" {
+			return fmt.Errorf("unknow command \"%s\"\n\n%s", args[0], usage.String())
+		}
+		fmt.Print(usage.String())
+		return nil
+	}
+	return command.Run(args[1:])
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name:  "gen-client",
+		Usage: "generate typed exthttp client from annotated Go interface or OpenAPI 3 document",
+		Run:   genClient,
+	})
+}
+
+func genClient(args []string) error {
+
+	flags := flag.NewFlagSet("gen-client", flag.ContinueOnError)
+	input := flags.String("input", "", "annotated .go file or OpenAPI 3 .json/.yaml document")
+	pkg := flags.String("package", "", "package of generated code, defaults to the input's package or directory name")
+	name := flags.String("name", "", "interface name (Go input) or client name (OpenAPI input)")
+	baseURL := flags.String("base-url", "", "override base url")
+	output := flags.String("output", "", "output file, defaults to stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" {
+		flags.Usage()
+		return fmt.Errorf("gen-client needs -input")
+	}
+
+	data, err := ioutil.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+
+	var api *apigen.API
+	if strings.EqualFold(filepath.Ext(*input), ".go") {
+		if api, err = apigen.ParseInterface(*input, data, *name); err != nil {
+			return err
+		}
+		if *pkg != "" {
+			api.Package = *pkg
+		}
+	} else {
+		if *pkg == "" {
+			dir := *output
+			if dir == "" {
+				dir = *input
+			}
+			absDir, _ := filepath.Abs(filepath.Dir(dir))
+			*pkg = strings.Replace(filepath.Base(absDir), "-", "_", -1)
+		}
+		if api, err = apigen.ParseOpenAPI(*input, data, *pkg, *name); err != nil {
+			return err
+		}
+	}
+	if *baseURL != "" {
+		api.BaseURL = *baseURL
+	}
+
+	source, err := apigen.Generate(api)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+	return ioutil.WriteFile(*output, source, 0644)
 }
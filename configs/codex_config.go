@@ -13,8 +13,11 @@ import (
 
 var Settings *viper.Viper
 
+// SettingsSource Settings 读取的配置文件或地址，没有读取到配置时为空
+var SettingsSource string
+
 func init() {
-	Settings = LoadViperFromToml("", "go-codex.toml", path.Join("configs", "go-codex.toml"))
+	Settings, SettingsSource = LoadViperFromTomlEX("", "go-codex.toml", path.Join("configs", "go-codex.toml"))
 }
 
 func existsFile(filepath string) bool {
@@ -25,6 +28,12 @@ func existsFile(filepath string) bool {
 }
 
 func LoadViperFromToml(encrptyKey string, configFilePath ...string) *viper.Viper {
+	settings, _ := LoadViperFromTomlEX(encrptyKey, configFilePath...)
+	return settings
+}
+
+// LoadViperFromTomlEX 与 LoadViperFromToml 相同，同时返回读取的路径，文件不存在时为空
+func LoadViperFromTomlEX(encrptyKey string, configFilePath ...string) (*viper.Viper, string) {
 
 	settings := viper.New()
 	settings.SetConfigType("toml")
@@ -44,10 +53,14 @@ func LoadViperFromToml(encrptyKey string, configFilePath ...string) *viper.Viper
 
 		if err == nil {
 			settings.ReadConfig(bytes.NewBuffer(buff))
-			break
+			// 文件不存在时没有读取到配置
+			if buff == nil {
+				configFile = ""
+			}
+			return settings, configFile
 		}
 	}
-	return settings
+	return settings, ""
 }
 
 func LoadToml(configFile string, v interface{}) error {
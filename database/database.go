@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/jinzhu/gorm"
@@ -20,6 +22,36 @@ func SetDBSet(dbKey string, opt DBSetOption) {
 	dbKeyPoool[dbKey] = opt
 }
 
+// Keys 已设置的连接
+func Keys() []string {
+	keys := []string{}
+	for key := range dbKeyPoool {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Ping 打开连接并检查数据库是否可用
+func Ping(dbKey string) error {
+	db, err := getDB(dbKey)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.DB().Ping()
+}
+
+// PingContext 与 Ping 相同，ctx 结束时返回
+func PingContext(ctx context.Context, dbKey string) error {
+	db, err := getDB(dbKey)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.DB().PingContext(ctx)
+}
+
 func getDB(dbKey string) (*gorm.DB, *DbError) {
 
 	if set, found := dbKeyPoool[dbKey]; found {
@@ -29,6 +61,7 @@ func getDB(dbKey string) (*gorm.DB, *DbError) {
 		}
 		db.DB().SetMaxOpenConns(set.MaxOpenConns)
 		db.DB().SetMaxIdleConns(set.MaxIdleConns)
+		db.InstantSet(dbKeySettingName, dbKey)
 		return db, nil
 	}
 	return nil, warpDBError(nil, "DB.OPEN", fmt.Sprintf("找不到数据库相关连接配置（%s）", dbKey))
@@ -71,10 +104,12 @@ type DatabaseRepo struct {
 
 func (r *DatabaseRepo) put() {
 	r.channel <- 0
+	trackInUse(r.dbKey, 1)
 }
 
 func (r *DatabaseRepo) pop() {
 	<-r.channel
+	trackInUse(r.dbKey, -1)
 }
 
 var repos = map[string]*DatabaseRepo{}
@@ -112,7 +147,12 @@ type DbError struct {
 }
 
 func (s *DbError) RecordNotFound() bool {
-	return s.db.RecordNotFound()
+	return s.db != nil && s.db.RecordNotFound()
+}
+
+// Tag 出错的操作，如 DB.OPEN、DB.Create
+func (s *DbError) Tag() string {
+	return s.tag
 }
 
 func (s *DbError) Error() string {
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/zhin/go-codex/metrics"
+)
+
+// dbKeySettingName getDB 打开连接时记录连接名，供指标回调使用
+const dbKeySettingName = "codex:db_key"
+
+const metricsStartSettingName = "codex:metrics_start"
+
+type dbMetrics struct {
+	queries  *metrics.CounterVec
+	duration *metrics.HistogramVec
+	inUse    *metrics.GaugeVec
+}
+
+var currentMetrics *dbMetrics
+var metricsLock = sync.RWMutex{}
+
+// UseMetrics 将 DatabaseRepo 执行的语句写入 registry（为空时使用 metrics.Default()）：
+//
+//	db_queries_total{db,operation,status}
+//	db_query_duration_seconds{db,operation}
+//	db_connections_in_use{db}
+//
+// operation 为 create、update、delete、query、row_query，记录不存在不计为错误
+func UseMetrics(registry *metrics.Registry) {
+	if registry == nil {
+		registry = metrics.Default()
+	}
+	next := &dbMetrics{
+		queries:  registry.Counter("db_queries_total", "Total database statements executed.", "db", "operation", "status"),
+		duration: registry.Histogram("db_query_duration_seconds", "Latency of database statements.", nil, "db", "operation"),
+		inUse:    registry.Gauge("db_connections_in_use", "Database connections held by repos.", "db"),
+	}
+
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	if currentMetrics == nil {
+		registerMetricsCallbacks()
+	}
+	currentMetrics = next
+}
+
+func loadMetrics() *dbMetrics {
+	metricsLock.RLock()
+	defer metricsLock.RUnlock()
+	return currentMetrics
+}
+
+func trackInUse(dbKey string, delta float64) {
+	if m := loadMetrics(); m != nil {
+		m.inUse.With(dbKey).Add(delta)
+	}
+}
+
+// registerMetricsCallbacks 开始计时的回调放在事务开始之前，结束的回调放在提交之后
+func registerMetricsCallbacks() {
+	callback := gorm.DefaultCallback
+	callback.Create().Before("gorm:begin_transaction").Register("codex:metrics_before_create", metricsBefore)
+	callback.Create().After("gorm:commit_or_rollback_transaction").Register("codex:metrics_after_create", metricsAfter("create"))
+	callback.Update().Before("gorm:assign_updating_attributes").Register("codex:metrics_before_update", metricsBefore)
+	callback.Update().After("gorm:commit_or_rollback_transaction").Register("codex:metrics_after_update", metricsAfter("update"))
+	callback.Delete().Before("gorm:begin_transaction").Register("codex:metrics_before_delete", metricsBefore)
+	callback.Delete().After("gorm:commit_or_rollback_transaction").Register("codex:metrics_after_delete", metricsAfter("delete"))
+	callback.Query().Before("gorm:query").Register("codex:metrics_before_query", metricsBefore)
+	callback.Query().After("gorm:after_query").Register("codex:metrics_after_query", metricsAfter("query"))
+	callback.RowQuery().Before("gorm:row_query").Register("codex:metrics_before_row_query", metricsBefore)
+	callback.RowQuery().After("gorm:row_query").Register("codex:metrics_after_row_query", metricsAfter("row_query"))
+}
+
+func metricsBefore(scope *gorm.Scope) {
+	scope.InstanceSet(metricsStartSettingName, time.Now())
+}
+
+func metricsAfter(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		m := loadMetrics()
+		dbKey, found := scope.Get(dbKeySettingName)
+		start, started := scope.InstanceGet(metricsStartSettingName)
+		if m == nil || !found || !started {
+			return
+		}
+		status := "ok"
+		if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			status = "error"
+		}
+		m.queries.With(dbKey.(string), operation, status).Inc()
+		m.duration.With(dbKey.(string), operation).Observe(time.Since(start.(time.Time)).Seconds())
+	}
+}
@@ -0,0 +1,130 @@
+package apigen
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const petSource = `package pet
+
+// @BaseURL https://api.example.com
+// @Auth apikey:query:key
+type PetAPI interface {
+	// @GET /pets/{id}
+	GetPet(id int64) (*Pet, error)
+	// @GET /pets
+	// @Paginate cursor=cursor next=Next
+	ListPets(cursor string, sold bool, limit *int) (*PetList, error)
+	// @PUT /pets/{id}
+	// @Body pet
+	UpdatePet(id int64, pet *Pet) error
+}
+
+type Pet struct {
+	ID   int64  ` + "`json:\"id\"`" + `
+	Name string ` + "`json:\"name\"`" + `
+}
+
+type PetList struct {
+	Items []*Pet ` + "`json:\"items\"`" + `
+	Next  string ` + "`json:\"next\"`" + `
+}
+`
+
+const storeDocument = `{
+	"openapi": "3.0.1",
+	"info": {"title": "store"},
+	"paths": {
+		"/orders/{order_id}": {
+			"get": {
+				"operationId": "getOrder",
+				"parameters": [
+					{"name": "order_id", "in": "path", "required": true, "schema": {"type": "integer"}},
+					{"name": "verbose", "in": "query", "required": true, "schema": {"type": "boolean"}},
+					{"name": "limit", "in": "query", "schema": {"type": "integer"}}
+				],
+				"responses": {
+					"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Order"}}}}
+				}
+			}
+		}
+	},
+	"components": {
+		"schemas": {
+			"Order": {"type": "object", "required": ["id"], "properties": {"id": {"type": "integer"}}}
+		}
+	}
+}`
+
+func TestGenerate(t *testing.T) {
+
+	api, err := ParseInterface("pet.go", petSource, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := Generate(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"var _ PetAPI = (*PetAPIClient)(nil)",
+		`query["key"] = c.APIKey`,
+		`c.do("GET", "/pets/"+c.pathValue(id), query, headers, nil, &out)`,
+		`c.do("PUT", "/pets/"+c.pathValue(id), query, headers, pet, nil)`,
+		"cursor = result.Next",
+		`c.setValue(query, "sold", sold)`,
+		`c.setValue(query, "limit", limit)`,
+	} {
+		if !strings.Contains(string(source), expected) {
+			t.Errorf("generated source needs %s", expected)
+		}
+	}
+
+	typeCheck(t, "pet", map[string]string{"pet.go": petSource, "pet_client.go": string(source)})
+
+	api, err = ParseOpenAPI("store.json", []byte(storeDocument), "store", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err = Generate(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"ID int64 `json:\"id\"`",
+		"func (c *StoreClient) GetOrder(orderID int64, verbose bool, limit *int64) (*Order, error)",
+	} {
+		if !strings.Contains(string(source), expected) {
+			t.Errorf("generated source needs %s", expected)
+		}
+	}
+	typeCheck(t, "store", map[string]string{"store_client.go": string(source)})
+}
+
+// typeCheck 对生成的代码做类型检查，导入的包从源码解析
+func typeCheck(t *testing.T, pkg string, sources map[string]string) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	files := []*ast.File{}
+	for name, source := range sources {
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), source, 0)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err.Error(), source)
+		}
+		files = append(files, file)
+	}
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := config.Check(pkg, fset, files, nil); err != nil {
+		t.Fatalf("generated source does not compile: %s", err.Error())
+	}
+}
@@ -0,0 +1,228 @@
+package apigen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// ParseInterface 从 Go 源码中带注释的接口生成 API，name 为空时使用文件中第一个带 @BaseURL 或方法注解的接口。
+//
+//	// @BaseURL https://api.example.com/v1
+//	// @Auth bearer
+//	// @Error APIError
+//	type PetAPI interface {
+//		// GetPet 查询宠物
+//		// @GET /pets/{id}
+//		GetPet(id int64) (*Pet, error)
+//		// @GET /pets
+//		// @Query status=pet_status
+//		// @Paginate page=page items=Items
+//		ListPets(status string, page int) (*PetList, error)
+//		// @POST /pets
+//		// @Body pet
+//		// @Header requestID=X-Request-Id
+//		CreatePet(requestID string, pet *Pet) (*Pet, error)
+//	}
+//
+// 路径中出现的参数为路径参数，@Body 指定的参数为 JSON 请求内容，其余参数作为查询参数；
+// 查询参数及请求头为 nil 指针时不发送，需要可选的参数可声明为指针
+func ParseInterface(filename string, src interface{}, name string) (*API, error) {
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			iface, ok := typeSpec.Type.(*ast.InterfaceType)
+			if !ok || (name != "" && typeSpec.Name.Name != name) {
+				continue
+			}
+			doc := typeSpec.Doc
+			if doc == nil && len(genDecl.Specs) == 1 {
+				doc = genDecl.Doc
+			}
+			api, err := parseInterface(file.Name.Name, typeSpec.Name.Name, doc, iface)
+			if err != nil {
+				return nil, err
+			}
+			if name != "" || api.BaseURL != "" || len(api.Operations) > 0 {
+				return api, nil
+			}
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("interface \"%s\" not found", name)
+	}
+	return nil, fmt.Errorf("no annotated interface in \"%s\"", filename)
+}
+
+var httpMethods = map[string]bool{
+	"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "HEAD": true, "OPTIONS": true,
+}
+
+// annotations 把注释拆分为 @ 注解和普通文档
+func annotations(group *ast.CommentGroup) ([][2]string, string) {
+	result := [][2]string{}
+	docs := []string{}
+	if group == nil {
+		return result, ""
+	}
+	for _, line := range strings.Split(group.Text(), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "@") {
+			if line != "" {
+				docs = append(docs, line)
+			}
+			continue
+		}
+		fields := strings.SplitN(line[1:], " ", 2)
+		value := ""
+		if len(fields) == 2 {
+			value = strings.TrimSpace(fields[1])
+		}
+		result = append(result, [2]string{fields[0], value})
+	}
+	return result, strings.Join(docs, "\n")
+}
+
+// keyValues 解析 a=b c=d 或 a=b,c=d
+func keyValues(value string) map[string]string {
+	result := map[string]string{}
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' }) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) == 2 {
+			result[parts[0]] = parts[1]
+		} else {
+			result[parts[0]] = parts[0]
+		}
+	}
+	return result
+}
+
+func parseInterface(pkg string, name string, doc *ast.CommentGroup, iface *ast.InterfaceType) (*API, error) {
+
+	api := &API{Package: pkg, Name: name, Interface: name}
+	items, _ := annotations(doc)
+	for _, item := range items {
+		switch item[0] {
+		case "BaseURL":
+			api.BaseURL = item[1]
+		case "Auth":
+			api.Auth = item[1]
+		case "Error":
+			api.ErrorType = item[1]
+		case "Name":
+			api.Name = item[1]
+		}
+	}
+
+	for _, method := range iface.Methods.List {
+		funcType, ok := method.Type.(*ast.FuncType)
+		if !ok || len(method.Names) == 0 {
+			continue
+		}
+		op, err := parseMethod(method.Names[0].Name, method.Doc, funcType)
+		if err != nil {
+			return nil, err
+		}
+		if op != nil {
+			api.Operations = append(api.Operations, op)
+		}
+	}
+	return api, nil
+}
+
+func parseMethod(name string, doc *ast.CommentGroup, funcType *ast.FuncType) (*Operation, error) {
+
+	items, text := annotations(doc)
+	op := &Operation{Name: name, Doc: text}
+	body := ""
+	renames := map[string]string{}
+	headers := map[string]string{}
+	for _, item := range items {
+		switch {
+		case httpMethods[item[0]]:
+			op.Method = item[0]
+			op.Path = item[1]
+		case item[0] == "Body":
+			body = item[1]
+		case item[0] == "Query":
+			for key, value := range keyValues(item[1]) {
+				renames[key] = value
+			}
+		case item[0] == "Header":
+			for key, value := range keyValues(item[1]) {
+				headers[key] = value
+			}
+		case item[0] == "Paginate":
+			values := keyValues(item[1])
+			op.Paginate = &Pagination{Items: values["items"], Next: values["next"], Param: values["page"]}
+			if cursor, found := values["cursor"]; found {
+				op.Paginate.Param = cursor
+			}
+		}
+	}
+	if op.Method == "" {
+		return nil, nil
+	}
+
+	if funcType.Params != nil {
+		for _, field := range funcType.Params.List {
+			goType := types.ExprString(field.Type)
+			if len(field.Names) == 0 {
+				return nil, fmt.Errorf("method \"%s\" params need names", name)
+			}
+			for _, ident := range field.Names {
+				param := &Param{Name: ident.Name, GoType: goType, In: InQuery, Key: ident.Name}
+				if key, found := renames[ident.Name]; found {
+					param.Key = key
+				}
+				switch {
+				case ident.Name == body:
+					param.In = InBody
+				case headers[ident.Name] != "":
+					param.In = InHeader
+					param.Key = headers[ident.Name]
+				case strings.Contains(op.Path, "{"+ident.Name+"}"):
+					param.In = InPath
+				}
+				if keywords[param.Name] {
+					return nil, fmt.Errorf("method \"%s\" param \"%s\" is reserved", name, param.Name)
+				}
+				op.Params = append(op.Params, param)
+			}
+		}
+	}
+
+	results := []ast.Expr{}
+	if funcType.Results != nil {
+		for _, field := range funcType.Results.List {
+			count := len(field.Names)
+			if count == 0 {
+				count = 1
+			}
+			for i := 0; i < count; i++ {
+				results = append(results, field.Type)
+			}
+		}
+	}
+	if len(results) == 0 || len(results) > 2 || types.ExprString(results[len(results)-1]) != "error" {
+		return nil, fmt.Errorf("method \"%s\" must return error or (T, error)", name)
+	}
+	if len(results) == 2 {
+		op.Result = types.ExprString(results[0])
+	}
+	return op, nil
+}
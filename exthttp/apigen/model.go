@@ -0,0 +1,226 @@
+// Package apigen 根据带注释的 Go 接口或 OpenAPI 3 文档生成基于 exthttp.HttpClient 的类型化客户端
+package apigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// API 生成客户端所需的接口描述
+type API struct {
+	Package string
+	// Name 生成 <Name>Client、<Name>Error 等类型
+	Name    string
+	BaseURL string
+	// Auth bearer、basic、apikey:header:<name>、apikey:query:<name>，为空时不认证
+	Auth string
+	// ErrorType 错误响应内容解码到的类型
+	ErrorType string
+	// Interface 从 Go 接口生成时，生成的客户端实现该接口
+	Interface  string
+	Operations []*Operation
+	Types      []*TypeDef
+}
+
+type Operation struct {
+	Name   string
+	Doc    string
+	Method string
+	// Path 使用 {name} 表示路径参数
+	Path string
+	// Params 按方法签名的顺序排列，In 为 body 的参数作为 JSON 请求内容
+	Params []*Param
+	// Result 返回值类型，为空时只返回 error
+	Result   string
+	Paginate *Pagination
+}
+
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+	InBody   = "body"
+)
+
+type Param struct {
+	Name   string
+	GoType string
+	In     string
+	// Key 路径占位符、查询参数名或请求头名
+	Key string
+}
+
+// Pagination Next 为空时按页码翻页（Items 为空时停止），否则按游标翻页（Next 为空时停止）
+type Pagination struct {
+	Param string
+	Items string
+	Next  string
+}
+
+// TypeDef Alias 不为空时生成 type Name Alias，否则生成结构体
+type TypeDef struct {
+	Name   string
+	Doc    string
+	Alias  string
+	Fields []*Field
+}
+
+type Field struct {
+	Name   string
+	GoType string
+	JSON   string
+}
+
+func (a *API) validate() error {
+	if a.Package == "" {
+		return fmt.Errorf("api needs package")
+	}
+	if a.Name == "" {
+		return fmt.Errorf("api needs name")
+	}
+	if a.Auth != "" && a.Auth != "bearer" && a.Auth != "basic" {
+		parts := strings.SplitN(a.Auth, ":", 3)
+		if len(parts) != 3 || parts[0] != "apikey" || (parts[1] != InHeader && parts[1] != InQuery) || parts[2] == "" {
+			return fmt.Errorf("unknow auth \"%s\"", a.Auth)
+		}
+	}
+	names := map[string]bool{}
+	for _, op := range a.Operations {
+		if names[op.Name] {
+			return fmt.Errorf("duplicate operation \"%s\"", op.Name)
+		}
+		names[op.Name] = true
+		bodies := 0
+		for _, param := range op.Params {
+			if param.In == InPath && !strings.Contains(op.Path, "{"+param.Key+"}") {
+				return fmt.Errorf("operation \"%s\" path has no {%s}", op.Name, param.Key)
+			}
+			if param.In == InBody {
+				bodies++
+			}
+		}
+		if bodies > 1 {
+			return fmt.Errorf("operation \"%s\" has more than one body", op.Name)
+		}
+		for _, match := range pathParamRegex.FindAllStringSubmatch(op.Path, -1) {
+			if op.pathParam(match[1]) == nil {
+				return fmt.Errorf("operation \"%s\" has no path param \"%s\"", op.Name, match[1])
+			}
+		}
+		if op.Paginate != nil {
+			if op.Result == "" {
+				return fmt.Errorf("operation \"%s\" pagination needs result", op.Name)
+			}
+			if op.paginateParam() == nil {
+				return fmt.Errorf("operation \"%s\" has no pagination param \"%s\"", op.Name, op.Paginate.Param)
+			}
+		}
+	}
+	return nil
+}
+
+var pathParamRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+func (op *Operation) pathParam(key string) *Param {
+	for _, param := range op.Params {
+		if param.In == InPath && param.Key == key {
+			return param
+		}
+	}
+	return nil
+}
+
+// BodyParam 返回作为请求内容的参数，没有时返回 nil
+func (op *Operation) BodyParam() *Param {
+	for _, param := range op.Params {
+		if param.In == InBody {
+			return param
+		}
+	}
+	return nil
+}
+
+func (op *Operation) paginateParam() *Param {
+	for _, param := range op.Params {
+		if param.Name == op.Paginate.Param {
+			return param
+		}
+	}
+	return nil
+}
+
+// Generate 生成格式化后的 Go 源码
+func Generate(api *API) ([]byte, error) {
+	if err := api.validate(); err != nil {
+		return nil, err
+	}
+	buff := &bytes.Buffer{}
+	if err := clientTemplate.Execute(buff, api); err != nil {
+		return nil, err
+	}
+	source, err := format.Source(buff.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated source error:%s\n%s", err.Error(), buff.String())
+	}
+	return source, nil
+}
+
+var initialisms = map[string]string{
+	"id": "ID", "url": "URL", "uri": "URI", "api": "API", "http": "HTTP", "json": "JSON",
+	"xml": "XML", "ip": "IP", "uuid": "UUID", "sql": "SQL", "html": "HTML",
+}
+
+// goName 把 snake_case、kebab-case 等名称转换为导出的 Go 名称
+func goName(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	result := &strings.Builder{}
+	for _, word := range words {
+		if initialism, found := initialisms[strings.ToLower(word)]; found {
+			result.WriteString(initialism)
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		result.WriteString(string(runes))
+	}
+	if result.Len() == 0 || unicode.IsDigit([]rune(result.String())[0]) {
+		return "X" + result.String()
+	}
+	return result.String()
+}
+
+var keywords = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true, "default": true,
+	"defer": true, "else": true, "fallthrough": true, "for": true, "func": true, "go": true,
+	"goto": true, "if": true, "import": true, "interface": true, "map": true, "package": true,
+	"range": true, "return": true, "select": true, "struct": true, "switch": true, "type": true,
+	"var": true, "fn": true, "query": true, "headers": true, "body": true, "out": true, "err": true,
+	"result": true, "c": true,
+}
+
+// paramName 转换为不与关键字及生成代码中的局部变量冲突的参数名
+func paramName(name string) string {
+	runes := []rune(goName(name))
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	// URLPath 转换为 urlPath，ID 转换为 id
+	if upper > 1 && upper < len(runes) {
+		upper--
+	}
+	for i := 0; i < upper; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	result := string(runes)
+	if keywords[result] {
+		return result + "_"
+	}
+	return result
+}
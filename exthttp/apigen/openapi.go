@@ -0,0 +1,413 @@
+package apigen
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type oaDocument struct {
+	OpenAPI    string                 `json:"openapi"`
+	Info       oaInfo                 `json:"info"`
+	Servers    []oaServer             `json:"servers"`
+	Paths      map[string]*oaPathItem `json:"paths"`
+	Components oaComponents           `json:"components"`
+	Security   []map[string][]string  `json:"security"`
+}
+
+type oaInfo struct {
+	Title string `json:"title"`
+}
+
+type oaServer struct {
+	URL string `json:"url"`
+}
+
+type oaComponents struct {
+	Schemas         map[string]*oaSchema         `json:"schemas"`
+	Parameters      map[string]*oaParameter      `json:"parameters"`
+	SecuritySchemes map[string]*oaSecurityScheme `json:"securitySchemes"`
+}
+
+type oaSecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+	Name   string `json:"name"`
+	In     string `json:"in"`
+}
+
+type oaPathItem struct {
+	Get        *oaOperation   `json:"get"`
+	Put        *oaOperation   `json:"put"`
+	Post       *oaOperation   `json:"post"`
+	Delete     *oaOperation   `json:"delete"`
+	Patch      *oaOperation   `json:"patch"`
+	Head       *oaOperation   `json:"head"`
+	Parameters []*oaParameter `json:"parameters"`
+}
+
+func (p *oaPathItem) operations() [][2]interface{} {
+	return [][2]interface{}{
+		{"GET", p.Get}, {"POST", p.Post}, {"PUT", p.Put}, {"PATCH", p.Patch}, {"DELETE", p.Delete}, {"HEAD", p.Head},
+	}
+}
+
+type oaOperation struct {
+	OperationID string                 `json:"operationId"`
+	Summary     string                 `json:"summary"`
+	Description string                 `json:"description"`
+	Parameters  []*oaParameter         `json:"parameters"`
+	RequestBody *oaRequestBody         `json:"requestBody"`
+	Responses   map[string]*oaResponse `json:"responses"`
+	// Pagination 扩展字段，例如 {"page": "page", "items": "items"} 或 {"cursor": "cursor", "next": "next_cursor"}
+	Pagination map[string]string `json:"x-pagination"`
+}
+
+type oaParameter struct {
+	Ref      string    `json:"$ref"`
+	Name     string    `json:"name"`
+	In       string    `json:"in"`
+	Required bool      `json:"required"`
+	Schema   *oaSchema `json:"schema"`
+}
+
+type oaRequestBody struct {
+	Content map[string]*oaMediaType `json:"content"`
+}
+
+type oaResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]*oaMediaType `json:"content"`
+}
+
+type oaMediaType struct {
+	Schema *oaSchema `json:"schema"`
+}
+
+type oaSchema struct {
+	Ref                  string               `json:"$ref"`
+	Type                 string               `json:"type"`
+	Format               string               `json:"format"`
+	Description          string               `json:"description"`
+	Properties           map[string]*oaSchema `json:"properties"`
+	Required             []string             `json:"required"`
+	Items                *oaSchema            `json:"items"`
+	AdditionalProperties interface{}          `json:"additionalProperties"`
+	AllOf                []*oaSchema          `json:"allOf"`
+}
+
+// ParseOpenAPI 从 OpenAPI 3 文档（JSON 或 YAML）生成 API，name 为空时使用 info.title
+func ParseOpenAPI(filename string, data []byte, pkg string, name string) (*API, error) {
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		// 先转换为 JSON，使 YAML 与 JSON 文档的解析结果一致
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("openapi document error:%s", err.Error())
+		}
+		buff, err := json.Marshal(yamlToJSON(value))
+		if err != nil {
+			return nil, fmt.Errorf("openapi document error:%s", err.Error())
+		}
+		data = buff
+	}
+	doc := &oaDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("openapi document error:%s", err.Error())
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unknow openapi version \"%s\"", doc.OpenAPI)
+	}
+
+	if name == "" {
+		name = goName(doc.Info.Title)
+	}
+	parser := &oaParser{doc: doc, api: &API{Package: pkg, Name: name}, types: map[string]*TypeDef{}}
+	if len(doc.Servers) > 0 {
+		parser.api.BaseURL = doc.Servers[0].URL
+	}
+	if err := parser.parse(); err != nil {
+		return nil, err
+	}
+	return parser.api, nil
+}
+
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := map[string]interface{}{}
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = yamlToJSON(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlToJSON(item)
+		}
+	}
+	return value
+}
+
+type oaParser struct {
+	doc   *oaDocument
+	api   *API
+	types map[string]*TypeDef
+}
+
+func (p *oaParser) parse() error {
+
+	schemaNames := make([]string, 0, len(p.doc.Components.Schemas))
+	for schemaName := range p.doc.Components.Schemas {
+		schemaNames = append(schemaNames, schemaName)
+	}
+	sort.Strings(schemaNames)
+	for _, schemaName := range schemaNames {
+		p.define(goName(schemaName), p.doc.Components.Schemas[schemaName])
+	}
+
+	p.api.Auth = p.auth(p.doc.Security)
+
+	paths := make([]string, 0, len(p.doc.Paths))
+	for path := range p.doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := p.doc.Paths[path]
+		for _, pair := range item.operations() {
+			operation := pair[1].(*oaOperation)
+			if operation == nil {
+				continue
+			}
+			op, err := p.operation(pair[0].(string), path, item.Parameters, operation)
+			if err != nil {
+				return err
+			}
+			p.api.Operations = append(p.api.Operations, op)
+		}
+	}
+	return nil
+}
+
+// auth 使用第一个安全要求，oauth2 及 openIdConnect 按 bearer 处理
+func (p *oaParser) auth(security []map[string][]string) string {
+	for _, requirement := range security {
+		for schemeName := range requirement {
+			scheme := p.doc.Components.SecuritySchemes[schemeName]
+			if scheme == nil {
+				continue
+			}
+			switch scheme.Type {
+			case "http":
+				if strings.EqualFold(scheme.Scheme, "basic") {
+					return "basic"
+				}
+				return "bearer"
+			case "oauth2", "openIdConnect":
+				return "bearer"
+			case "apiKey":
+				if scheme.In == InQuery {
+					return "apikey:query:" + scheme.Name
+				}
+				return "apikey:header:" + scheme.Name
+			}
+		}
+	}
+	return ""
+}
+
+func (p *oaParser) operation(method string, path string, common []*oaParameter, operation *oaOperation) (*Operation, error) {
+
+	name := operation.OperationID
+	if name == "" {
+		name = strings.ToLower(method) + " " + path
+	}
+	op := &Operation{
+		Name:   goName(name),
+		Doc:    strings.TrimSpace(operation.Summary + "\n" + operation.Description),
+		Method: method,
+		Path:   path,
+	}
+
+	pageParam := operation.Pagination["page"]
+	if cursor := operation.Pagination["cursor"]; cursor != "" {
+		pageParam = cursor
+	}
+	for _, parameter := range append(append([]*oaParameter{}, common...), operation.Parameters...) {
+		if parameter.Ref != "" {
+			parameter = p.doc.Components.Parameters[parameter.Ref[strings.LastIndex(parameter.Ref, "/")+1:]]
+			if parameter == nil {
+				return nil, fmt.Errorf("operation \"%s\" parameter ref not found", op.Name)
+			}
+		}
+		if parameter.In == "cookie" {
+			continue
+		}
+		goType := "string"
+		if parameter.Schema != nil {
+			goType = p.goType(op.Name+goName(parameter.Name), parameter.Schema, true)
+		}
+		// 非必需的查询参数及请求头使用指针，nil 时不发送；翻页参数由 Pages 方法赋值，保持值类型
+		if !parameter.Required && parameter.In != "path" && parameter.Name != pageParam && scalarTypes[goType] {
+			goType = "*" + goType
+		}
+		op.Params = append(op.Params, &Param{Name: paramName(parameter.Name), GoType: goType, In: parameter.In, Key: parameter.Name})
+	}
+
+	if operation.RequestBody != nil {
+		if schema := jsonSchema(operation.RequestBody.Content); schema != nil {
+			op.Params = append(op.Params, &Param{Name: "request", GoType: p.goType(op.Name+"Request", schema, false), In: InBody})
+		}
+	}
+
+	codes := make([]string, 0, len(operation.Responses))
+	for code := range operation.Responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		schema := jsonSchema(operation.Responses[code].Content)
+		if schema == nil {
+			continue
+		}
+		if strings.HasPrefix(code, "2") && op.Result == "" {
+			op.Result = p.goType(op.Name+"Response", schema, false)
+		} else if (code == "default" || strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5")) && p.api.ErrorType == "" {
+			p.api.ErrorType = strings.TrimPrefix(p.goType(op.Name+"Error", schema, false), "*")
+		}
+	}
+
+	if operation.Pagination != nil {
+		op.Paginate = &Pagination{Param: operation.Pagination["page"], Items: goName(operation.Pagination["items"])}
+		if cursor, found := operation.Pagination["cursor"]; found {
+			op.Paginate.Param = cursor
+			op.Paginate.Next = goName(operation.Pagination["next"])
+		}
+		op.Paginate.Param = paramName(op.Paginate.Param)
+	}
+	return op, nil
+}
+
+var scalarTypes = map[string]bool{"string": true, "int32": true, "int64": true, "float32": true, "float64": true, "bool": true}
+
+func jsonSchema(content map[string]*oaMediaType) *oaSchema {
+	for mediaType, media := range content {
+		if (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && media.Schema != nil {
+			return media.Schema
+		}
+	}
+	return nil
+}
+
+func refName(ref string) string {
+	return goName(ref[strings.LastIndex(ref, "/")+1:])
+}
+
+// goType 返回 schema 对应的 Go 类型，匿名对象生成名为 hint 的类型；value 为 true 时对象不使用指针
+func (p *oaParser) goType(hint string, schema *oaSchema, value bool) string {
+
+	pointer := "*"
+	if value {
+		pointer = ""
+	}
+	if schema.Ref != "" {
+		return pointer + refName(schema.Ref)
+	}
+	if len(schema.AllOf) > 0 || len(schema.Properties) > 0 {
+		p.define(hint, schema)
+		return pointer + hint
+	}
+	switch schema.Type {
+	case "string":
+		return "string"
+	case "integer":
+		if schema.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		if schema.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		if schema.Items == nil {
+			return "[]interface{}"
+		}
+		return "[]" + p.goType(hint+"Item", schema.Items, true)
+	case "object":
+		if additional, ok := schema.AdditionalProperties.(map[string]interface{}); ok && len(additional) > 0 {
+			if buff, err := json.Marshal(additional); err == nil {
+				itemSchema := &oaSchema{}
+				if json.Unmarshal(buff, itemSchema) == nil {
+					return "map[string]" + p.goType(hint+"Value", itemSchema, true)
+				}
+			}
+		}
+		return "map[string]interface{}"
+	}
+	return "interface{}"
+}
+
+func (p *oaParser) define(name string, schema *oaSchema) {
+	if _, found := p.types[name]; found {
+		return
+	}
+	typeDef := &TypeDef{Name: name, Doc: schema.Description}
+	p.types[name] = typeDef
+	p.api.Types = append(p.api.Types, typeDef)
+
+	if schema.Ref != "" || (len(schema.AllOf) == 0 && len(schema.Properties) == 0 && schema.Type != "object") {
+		typeDef.Alias = p.goType(name+"Item", schema, true)
+		return
+	}
+
+	// allOf 中的各部分合并为一个结构体
+	parts := append([]*oaSchema{schema}, schema.AllOf...)
+	seen := map[string]bool{}
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part.Ref != "" {
+			if refSchema := p.doc.Components.Schemas[part.Ref[strings.LastIndex(part.Ref, "/")+1:]]; refSchema != nil {
+				parts = append(parts, refSchema)
+				parts = append(parts, refSchema.AllOf...)
+			}
+			continue
+		}
+		required := map[string]bool{}
+		for _, key := range part.Required {
+			required[key] = true
+		}
+		keys := make([]string, 0, len(part.Properties))
+		for key := range part.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			tag := key
+			if !required[key] {
+				tag += ",omitempty"
+			}
+			typeDef.Fields = append(typeDef.Fields, &Field{
+				Name:   goName(key),
+				GoType: p.goType(name+goName(key), part.Properties[key], false),
+				JSON:   tag,
+			})
+		}
+	}
+	if len(typeDef.Fields) == 0 && len(schema.AllOf) == 0 {
+		typeDef.Alias = "map[string]interface{}"
+	}
+}
@@ -0,0 +1,297 @@
+package apigen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var clientTemplate = template.Must(template.New("client").Funcs(template.FuncMap{
+	"authIn":     authIn,
+	"authKey":    authKey,
+	"comment":    comment,
+	"pathExpr":   pathExpr,
+	"paramList":  paramList,
+	"argList":    argList,
+	"quote":      strconv.Quote,
+	"isPointer":  func(t string) bool { return strings.HasPrefix(t, "*") },
+	"hasPrefix":  strings.HasPrefix,
+	"resultList": resultList,
+}).Parse(`// Code generated by codex gen-client. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- if eq .Auth "basic"}}
+	"encoding/base64"
+{{- end}}
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/zhin/go-codex/exthttp"
+)
+{{range .Types}}
+{{with comment .Name .Doc}}{{.}}
+{{end -}}
+{{if .Alias -}}
+type {{.Name}} {{.Alias}}
+{{- else -}}
+type {{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.GoType}} ` + "`json:\"{{.JSON}}\"`" + `
+{{- end}}
+}
+{{- end}}
+{{end}}
+// {{.Name}}Client {{.Name}} 接口客户端
+type {{.Name}}Client struct {
+	BaseURL string
+	Client  *exthttp.HttpClient
+{{- if eq .Auth "bearer"}}
+	Token string
+{{- else if eq .Auth "basic"}}
+	Username string
+	Password string
+{{- else if hasPrefix .Auth "apikey:"}}
+	APIKey string
+{{- end}}
+	// Headers 每个请求都附加的请求头
+	Headers map[string]string
+}
+{{if .Interface}}
+var _ {{.Interface}} = (*{{.Name}}Client)(nil)
+{{end}}
+// New{{.Name}}Client client 为 nil 时使用 exthttp.DefaultClient
+func New{{.Name}}Client(client *exthttp.HttpClient) *{{.Name}}Client {
+	if client == nil {
+		client = exthttp.DefaultClient
+	}
+	return &{{.Name}}Client{BaseURL: {{quote .BaseURL}}, Client: client}
+}
+
+// {{.Name}}Error 接口返回非 2xx 状态码
+type {{.Name}}Error struct {
+	Status int
+{{- if .ErrorType}}
+	// Body 错误响应内容，解码失败时为零值
+	Body {{.ErrorType}}
+{{- end}}
+	Raw []byte
+}
+
+func (e *{{.Name}}Error) Error() string {
+	return fmt.Sprintf("{{.Name}} api error status:%d response:%s", e.Status, string(e.Raw))
+}
+
+func (c *{{.Name}}Client) do(method string, path string, query map[string]string, headers map[string]string, body interface{}, out interface{}) error {
+
+	options := &exthttp.RequestOptions{
+		Headers:      map[string]string{"Accept": "application/json"},
+		ContentType:  exthttp.JSONEncoded,
+		AcceptStatus: []int{200, 201, 202, 204},
+	}
+	for key, value := range c.Headers {
+		options.Headers[key] = value
+	}
+	for key, value := range headers {
+		options.Headers[key] = value
+	}
+{{- if eq .Auth "bearer"}}
+	if c.Token != "" {
+		options.Headers["Authorization"] = "Bearer " + c.Token
+	}
+{{- else if eq .Auth "basic"}}
+	if c.Username != "" {
+		options.Headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+	}
+{{- else if eq (authIn .Auth) "header"}}
+	if c.APIKey != "" {
+		options.Headers[{{quote (authKey .Auth)}}] = c.APIKey
+	}
+{{- else if eq (authIn .Auth) "query"}}
+	if c.APIKey != "" {
+		query[{{quote (authKey .Auth)}}] = c.APIKey
+	}
+{{- end}}
+
+	var buff []byte
+	if body != nil {
+		var err error
+		if buff, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("{{.Name}} request body error:%s", err.Error())
+		}
+	}
+	resp, err := c.Client.RawRequestEX(method, strings.TrimRight(c.BaseURL, "/")+path, query, buff, options)
+	if err != nil {
+		if respErr, ok := err.(*exthttp.HttpResponseError); ok {
+			apiErr := &{{.Name}}Error{Status: respErr.Status, Raw: respErr.ResponseData}
+{{- if .ErrorType}}
+			json.Unmarshal(respErr.ResponseData, &apiErr.Body)
+{{- end}}
+			return apiErr
+		}
+		return err
+	}
+	if out == nil || len(resp.Body) == 0 {
+		return nil
+	}
+	return resp.JSON(out)
+}
+
+// setValue nil 指针及 nil 切片不发送，false、0 等零值照常发送
+func (c *{{.Name}}Client) setValue(values map[string]string, key string, value interface{}) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice) && v.IsNil()) {
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		items := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, fmt.Sprint(v.Index(i).Interface()))
+		}
+		values[key] = strings.Join(items, ",")
+		return
+	}
+	values[key] = fmt.Sprint(v.Interface())
+}
+
+func (c *{{.Name}}Client) pathValue(value interface{}) string {
+	return url.PathEscape(fmt.Sprint(value))
+}
+{{$client := .Name}}
+{{- range .Operations}}
+{{with comment .Name .Doc}}{{.}}
+{{end -}}
+func (c *{{$client}}Client) {{.Name}}({{paramList .}}) {{resultList .}} {
+	query := map[string]string{}
+	headers := map[string]string{}
+{{- range .Params}}
+{{- if eq .In "query"}}
+	c.setValue(query, {{quote .Key}}, {{.Name}})
+{{- else if eq .In "header"}}
+	c.setValue(headers, {{quote .Key}}, {{.Name}})
+{{- end}}
+{{- end}}
+{{- $body := "nil"}}{{with .BodyParam}}{{$body = .Name}}{{end}}
+{{- if .Result}}
+	var out {{.Result}}
+	if err := c.do({{quote .Method}}, {{pathExpr .}}, query, headers, {{$body}}, &out); err != nil {
+		return out, err
+	}
+	return out, nil
+{{- else}}
+	return c.do({{quote .Method}}, {{pathExpr .}}, query, headers, {{$body}}, nil)
+{{- end}}
+}
+{{- if .Paginate}}
+
+// {{.Name}}Pages 从 {{.Paginate.Param}} 开始依次请求每一页，fn 返回错误时停止
+func (c *{{$client}}Client) {{.Name}}Pages({{paramList .}}, fn func({{.Result}}) error) error {
+	for {
+		result, err := c.{{.Name}}({{argList .}})
+		if err != nil {
+			return err
+		}
+{{- if isPointer .Result}}
+		if result == nil {
+			return nil
+		}
+{{- end}}
+		if err := fn(result); err != nil {
+			return err
+		}
+{{- if .Paginate.Next}}
+		if result.{{.Paginate.Next}} == "" {
+			return nil
+		}
+		{{.Paginate.Param}} = result.{{.Paginate.Next}}
+{{- else}}
+		if len(result.{{.Paginate.Items}}) == 0 {
+			return nil
+		}
+		{{.Paginate.Param}}++
+{{- end}}
+	}
+}
+{{- end}}
+{{end}}
+`))
+
+func authIn(auth string) string {
+	parts := strings.SplitN(auth, ":", 3)
+	if len(parts) == 3 {
+		return parts[1]
+	}
+	return ""
+}
+
+func authKey(auth string) string {
+	parts := strings.SplitN(auth, ":", 3)
+	if len(parts) == 3 {
+		return parts[2]
+	}
+	return ""
+}
+
+func comment(name string, doc string) string {
+	lines := strings.Split(strings.TrimSpace(doc), "\n")
+	if lines[0] == "" {
+		return ""
+	}
+	if !strings.HasPrefix(lines[0], name+" ") {
+		lines[0] = name + " " + lines[0]
+	}
+	return "// " + strings.Join(lines, "\n// ")
+}
+
+// pathExpr 把 /pets/{id} 转换为 "/pets/" + c.pathValue(id)
+func pathExpr(op *Operation) string {
+	parts := []string{}
+	rest := op.Path
+	for {
+		start := strings.Index(rest, "{")
+		end := strings.Index(rest, "}")
+		if start < 0 || end < start {
+			break
+		}
+		if rest[:start] != "" {
+			parts = append(parts, strconv.Quote(rest[:start]))
+		}
+		parts = append(parts, fmt.Sprintf("c.pathValue(%s)", op.pathParam(rest[start+1:end]).Name))
+		rest = rest[end+1:]
+	}
+	if rest != "" || len(parts) == 0 {
+		parts = append(parts, strconv.Quote(rest))
+	}
+	return strings.Join(parts, " + ")
+}
+
+func paramList(op *Operation) string {
+	items := []string{}
+	for _, param := range op.Params {
+		items = append(items, param.Name+" "+param.GoType)
+	}
+	return strings.Join(items, ", ")
+}
+
+func argList(op *Operation) string {
+	items := []string{}
+	for _, param := range op.Params {
+		items = append(items, param.Name)
+	}
+	return strings.Join(items, ", ")
+}
+
+func resultList(op *Operation) string {
+	if op.Result == "" {
+		return "error"
+	}
+	return fmt.Sprintf("(%s, error)", op.Result)
+}
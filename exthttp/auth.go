@@ -0,0 +1,649 @@
+package exthttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/zhin/go-codex/rds"
+)
+
+// AuthProvider 在请求发送前设置认证信息，req 已是副本，可直接修改
+type AuthProvider interface {
+	Apply(req *http.Request) error
+}
+
+// AuthRefresher 收到 401 时刷新凭证，req 为被拒绝的请求，返回 true 表示已刷新，请求会重试一次
+type AuthRefresher interface {
+	Refresh(req *http.Request) (bool, error)
+}
+
+type AuthProviderFunc func(req *http.Request) error
+
+func (f AuthProviderFunc) Apply(req *http.Request) error {
+	return f(req)
+}
+
+func BearerAuth(token string) AuthProvider {
+	return AuthProviderFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+func BasicAuth(username string, password string) AuthProvider {
+	return AuthProviderFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// APIKeyAuth in 为 header 或 query
+func APIKeyAuth(in string, name string, value string) AuthProvider {
+	return AuthProviderFunc(func(req *http.Request) error {
+		switch in {
+		case "header":
+			req.Header.Set(name, value)
+		case "query":
+			query := req.URL.Query()
+			query.Set(name, value)
+			req.URL.RawQuery = query.Encode()
+		default:
+			return fmt.Errorf("unknow api key location \"%s\"", in)
+		}
+		return nil
+	})
+}
+
+// Token OAuth2 访问令牌
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// valid 距离过期时间不足 skew 时视为无效，提前刷新
+func (t *Token) valid(skew time.Duration) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Add(skew).Before(t.Expiry))
+}
+
+// TokenStore 令牌缓存，Get 未找到时返回 nil, nil
+type TokenStore interface {
+	Get(key string) (*Token, error)
+	Set(key string, token *Token) error
+	Delete(key string) error
+}
+
+type MemoryTokenStore struct {
+	lock   sync.Mutex
+	tokens map[string]*Token
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]*Token{}}
+}
+
+func (s *MemoryTokenStore) Get(key string) (*Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tokens[key], nil
+}
+
+func (s *MemoryTokenStore) Set(key string, token *Token) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens[key] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+// RedisTokenStore 多个进程共享令牌，Client 为空时使用 rds.Default
+type RedisTokenStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (s *RedisTokenStore) client() (*redis.Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis token store needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (s *RedisTokenStore) key(key string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "codex:http:token:"
+	}
+	sum := sha1.Sum([]byte(key))
+	return prefix + hex.EncodeToString(sum[:])
+}
+
+func (s *RedisTokenStore) Get(key string) (*Token, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	buff, err := client.Get(s.key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	token := &Token{}
+	if err := json.Unmarshal(buff, token); err != nil {
+		return nil, nil
+	}
+	return token, nil
+}
+
+func (s *RedisTokenStore) Set(key string, token *Token) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	buff, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	// 有刷新令牌时不过期，访问令牌过期后仍可用其刷新
+	var expiration time.Duration
+	if token.RefreshToken == "" && !token.Expiry.IsZero() {
+		if expiration = time.Until(token.Expiry); expiration <= 0 {
+			return nil
+		}
+	}
+	return client.Set(s.key(key), buff, expiration).Err()
+}
+
+func (s *RedisTokenStore) Delete(key string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Del(s.key(key)).Err()
+}
+
+const (
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// OAuth2Options 客户端凭证或刷新令牌模式的配置
+type OAuth2Options struct {
+	TokenURL     string   `mapstructure:"token_url"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	Scopes       []string `mapstructure:"scopes"`
+	// RefreshToken 刷新令牌模式的初始刷新令牌，store 中已有令牌时使用 store 中的
+	RefreshToken string `mapstructure:"refresh_token"`
+	// Params 令牌请求附加的参数，例如 audience
+	Params map[string]string `mapstructure:"params"`
+	// ClientAuthInBody 客户端凭证放在请求内容中，默认使用 Basic 认证
+	ClientAuthInBody bool `mapstructure:"client_auth_in_body"`
+	// ExpirySkewSecond 提前刷新的秒数，默认 60
+	ExpirySkewSecond int `mapstructure:"expiry_skew_second"`
+	// CacheKey 默认为 token_url、client_id、scopes 的组合
+	CacheKey string `mapstructure:"cache_key"`
+}
+
+// OAuth2Auth 自动获取、缓存并刷新访问令牌
+type OAuth2Auth struct {
+	grantType string
+	option    OAuth2Options
+	store     TokenStore
+	client    *http.Client
+
+	lock sync.Mutex
+}
+
+// NewClientCredentialsAuth store 为 nil 时缓存在内存中
+func NewClientCredentialsAuth(option OAuth2Options, store TokenStore) *OAuth2Auth {
+	return newOAuth2Auth(GrantClientCredentials, option, store)
+}
+
+// NewRefreshTokenAuth 使用刷新令牌获取访问令牌，服务端返回新的刷新令牌时替换旧的
+func NewRefreshTokenAuth(option OAuth2Options, store TokenStore) *OAuth2Auth {
+	return newOAuth2Auth(GrantRefreshToken, option, store)
+}
+
+func newOAuth2Auth(grantType string, option OAuth2Options, store TokenStore) *OAuth2Auth {
+	if store == nil {
+		store = NewMemoryTokenStore()
+	}
+	if option.ExpirySkewSecond == 0 {
+		option.ExpirySkewSecond = 60
+	}
+	if option.CacheKey == "" {
+		option.CacheKey = strings.Join([]string{grantType, option.TokenURL, option.ClientID, strings.Join(option.Scopes, " ")}, "|")
+	}
+	return &OAuth2Auth{grantType: grantType, option: option, store: store, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// SetHTTPClient 设置请求令牌使用的客户端
+func (a *OAuth2Auth) SetHTTPClient(client *http.Client) {
+	a.client = client
+}
+
+// Token 返回有效的令牌，快过期时刷新
+func (a *OAuth2Auth) Token() (*Token, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	token, err := a.store.Get(a.option.CacheKey)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token store error:%s", err.Error())
+	}
+	if token.valid(time.Duration(a.option.ExpirySkewSecond) * time.Second) {
+		return token, nil
+	}
+	return a.fetch(token)
+}
+
+func (a *OAuth2Auth) Apply(req *http.Request) error {
+	token, err := a.Token()
+	if err != nil {
+		return err
+	}
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return nil
+}
+
+// Refresh 丢弃被拒绝的访问令牌并重新获取，其它请求已刷新过时直接重试
+func (a *OAuth2Auth) Refresh(req *http.Request) (bool, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	token, err := a.store.Get(a.option.CacheKey)
+	if err != nil {
+		return false, fmt.Errorf("oauth2 token store error:%s", err.Error())
+	}
+	if token != nil && !strings.HasSuffix(req.Header.Get("Authorization"), " "+token.AccessToken) {
+		return true, nil
+	}
+	if _, err := a.fetch(token); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string      `json:"access_token"`
+	TokenType    string      `json:"token_type"`
+	RefreshToken string      `json:"refresh_token"`
+	ExpiresIn    json.Number `json:"expires_in"`
+}
+
+func (a *OAuth2Auth) fetch(current *Token) (*Token, error) {
+
+	params := url.Values{}
+	params.Set("grant_type", a.grantType)
+	if len(a.option.Scopes) > 0 {
+		params.Set("scope", strings.Join(a.option.Scopes, " "))
+	}
+	if a.grantType == GrantRefreshToken {
+		refreshToken := a.option.RefreshToken
+		if current != nil && current.RefreshToken != "" {
+			refreshToken = current.RefreshToken
+		}
+		if refreshToken == "" {
+			return nil, fmt.Errorf("oauth2 needs refresh token")
+		}
+		params.Set("refresh_token", refreshToken)
+	}
+	for key, value := range a.option.Params {
+		params.Set(key, value)
+	}
+	if a.option.ClientAuthInBody {
+		params.Set("client_id", a.option.ClientID)
+		params.Set("client_secret", a.option.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.option.TokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token url error:%s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !a.option.ClientAuthInBody {
+		req.SetBasicAuth(url.QueryEscape(a.option.ClientID), url.QueryEscape(a.option.ClientSecret))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token request error:%s", err.Error())
+	}
+	defer resp.Body.Close()
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("oauth2 token response error:%s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HttpResponseError{RequestURL: a.option.TokenURL, Status: resp.StatusCode, ResponseHeader: resp.Header, ResponseData: buff}
+	}
+
+	result := &tokenResponse{}
+	if err := json.Unmarshal(buff, result); err != nil || result.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2 token response error:%s", string(buff))
+	}
+	token := &Token{AccessToken: result.AccessToken, TokenType: result.TokenType, RefreshToken: result.RefreshToken}
+	if token.RefreshToken == "" && current != nil {
+		token.RefreshToken = current.RefreshToken
+	}
+	if seconds, err := result.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	if err := a.store.Set(a.option.CacheKey, token); err != nil {
+		return nil, fmt.Errorf("oauth2 token store error:%s", err.Error())
+	}
+	return token, nil
+}
+
+// readBody 读取请求内容用于签名，并恢复 req.Body
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	buff, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(buff))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buff)), nil
+	}
+	return buff, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// HMACAuth 通用的 HMAC-SHA256 请求签名，签名内容为
+// method\nrequest_uri\ntimestamp\nhex(sha256(body))
+type HMACAuth struct {
+	KeyID  string
+	Secret string
+	// KeyIDHeader 默认 X-Key-Id
+	KeyIDHeader string
+	// TimestampHeader 默认 X-Timestamp，值为 unix 秒
+	TimestampHeader string
+	// SignatureHeader 默认 X-Signature，值为 base64
+	SignatureHeader string
+}
+
+func (a *HMACAuth) Apply(req *http.Request) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	content := strings.Join([]string{req.Method, req.URL.RequestURI(), timestamp, sha256Hex(body)}, "\n")
+	signature := base64.StdEncoding.EncodeToString(hmacSHA256([]byte(a.Secret), content))
+
+	req.Header.Set(defaultString(a.KeyIDHeader, "X-Key-Id"), a.KeyID)
+	req.Header.Set(defaultString(a.TimestampHeader, "X-Timestamp"), timestamp)
+	req.Header.Set(defaultString(a.SignatureHeader, "X-Signature"), signature)
+	return nil
+}
+
+func defaultString(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// SigV4Auth AWS Signature Version 4 签名
+type SigV4Auth struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+	// now 测试时固定签名时间
+	now func() time.Time
+}
+
+func (a *SigV4Auth) Apply(req *http.Request) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		name := strings.ToLower(key)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, a.Region, a.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+a.SecretAccessKey), date)
+	key = hmacSHA256(key, a.Region)
+	key = hmacSHA256(key, a.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// AuthTransport 为每个请求设置认证信息，401 时刷新凭证并重试一次
+type AuthTransport struct {
+	Transport http.RoundTripper
+	Provider  AuthProvider
+}
+
+func (t *AuthTransport) Unwrap() http.RoundTripper {
+	return t.Transport
+}
+
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	authReq, err := t.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Transport.RoundTrip(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	refresher, ok := t.Provider.(AuthRefresher)
+	if !ok || (req.Body != nil && req.Body != http.NoBody && authReq.GetBody == nil) {
+		return resp, nil
+	}
+	refreshed, err := refresher.Refresh(authReq)
+	if err != nil || !refreshed {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retryReq, err := t.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	if authReq.GetBody != nil {
+		if retryReq.Body, err = authReq.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.Transport.RoundTrip(retryReq)
+}
+
+func (t *AuthTransport) authorize(req *http.Request) (*http.Request, error) {
+	authReq := req.WithContext(req.Context())
+	authReq.Header = cloneHeader(req.Header)
+	authURL := *req.URL
+	authReq.URL = &authURL
+	if err := t.Provider.Apply(authReq); err != nil {
+		return nil, fmt.Errorf("auth error:%s", err.Error())
+	}
+	return authReq, nil
+}
+
+// UseAuth 设置客户端的认证方式，传 nil 取消认证
+func (c *HttpClient) UseAuth(provider AuthProvider) {
+	for rt := c.client.Transport; rt != nil; {
+		if authTransport, ok := rt.(*AuthTransport); ok {
+			authTransport.Provider = provider
+			return
+		}
+		wrapped, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapped.Unwrap()
+	}
+	if provider != nil {
+		c.client.Transport = &AuthTransport{Transport: c.client.Transport, Provider: provider}
+	}
+}
+
+// AuthOptions 对应配置文件中的 [http.auth] 或 [http.clients.<name>.auth]，
+// Type 为 bearer、basic、apikey、client_credentials、refresh_token、hmac、sigv4
+type AuthOptions struct {
+	Type     string `mapstructure:"type"`
+	Token    string `mapstructure:"token"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// In/Name/Value API key 的位置（header 或 query）、名称及值
+	In    string `mapstructure:"in"`
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+
+	OAuth2 OAuth2Options `mapstructure:"oauth2"`
+	// TokenStore 令牌缓存 memory 或 redis，默认 memory
+	TokenStore string `mapstructure:"token_store"`
+
+	AccessKey    string `mapstructure:"access_key"`
+	SecretKey    string `mapstructure:"secret_key"`
+	SessionToken string `mapstructure:"session_token"`
+	Region       string `mapstructure:"region"`
+	Service      string `mapstructure:"service"`
+}
+
+// NewAuthProvider 根据配置创建认证方式，Type 为空时返回 nil
+func NewAuthProvider(option AuthOptions) (AuthProvider, error) {
+	var store TokenStore
+	switch option.TokenStore {
+	case "", "memory":
+		store = NewMemoryTokenStore()
+	case "redis":
+		store = &RedisTokenStore{}
+	default:
+		return nil, fmt.Errorf("unknow token store \"%s\"", option.TokenStore)
+	}
+
+	switch option.Type {
+	case "":
+		return nil, nil
+	case "bearer":
+		return BearerAuth(option.Token), nil
+	case "basic":
+		return BasicAuth(option.Username, option.Password), nil
+	case "apikey":
+		return APIKeyAuth(defaultString(option.In, "header"), option.Name, option.Value), nil
+	case GrantClientCredentials:
+		return NewClientCredentialsAuth(option.OAuth2, store), nil
+	case GrantRefreshToken:
+		return NewRefreshTokenAuth(option.OAuth2, store), nil
+	case "hmac":
+		return &HMACAuth{KeyID: option.AccessKey, Secret: option.SecretKey}, nil
+	case "sigv4":
+		return &SigV4Auth{
+			AccessKeyID:     option.AccessKey,
+			SecretAccessKey: option.SecretKey,
+			SessionToken:    option.SessionToken,
+			Region:          option.Region,
+			Service:         option.Service,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknow auth type \"%s\"", option.Type)
+}
@@ -0,0 +1,62 @@
+package exthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestSigV4Auth 使用 AWS SigV4 测试集中的 get-vanilla-query-order-key-case
+func TestSigV4Auth(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/?Param2=value2&Param1=value1", nil)
+	auth := &SigV4Auth{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+	if err := auth.Apply(req); err != nil {
+		t.Fatal(err)
+	}
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"
+	if authorization := req.Header.Get("Authorization"); authorization != expected {
+		t.Errorf("authorization %s", authorization)
+	}
+}
+
+func TestAuthTransport_Refresh(t *testing.T) {
+	tokens := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if user, password, _ := r.BasicAuth(); user != "id" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tokens++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"t` + strconv.Itoa(tokens) + `","token_type":"bearer","expires_in":3600}`))
+		case "/api":
+			// 第一个令牌被服务端吊销
+			if r.Header.Get("Authorization") != "Bearer t2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	client, _ := NewHttpClientEX(ClientOption{})
+	client.UseAuth(NewClientCredentialsAuth(OAuth2Options{TokenURL: server.URL + "/token", ClientID: "id", ClientSecret: "secret"}, nil))
+	buff, err := client.RawRequest(http.MethodPost, server.URL+"/api", nil, []byte("{}"), &RequestOptions{ContentType: JSONEncoded})
+	if err != nil || string(buff) != "ok" || tokens != 2 {
+		t.Errorf("response %s %v tokens %d", buff, err, tokens)
+	}
+}
@@ -0,0 +1,660 @@
+package exthttp
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/zhin/go-codex/rds"
+)
+
+// CacheStatusHeader 响应头中标记缓存命中情况：HIT、MISS、REVALIDATED、STALE
+const CacheStatusHeader = "X-Codex-Cache"
+
+const (
+	CacheHit         = "HIT"
+	CacheMiss        = "MISS"
+	CacheRevalidated = "REVALIDATED"
+	CacheStale       = "STALE"
+)
+
+const defaultCacheMaxBodySize = 10 * 1024 * 1024
+
+// CacheStorage 缓存存储，Get 未找到时返回 nil, nil
+type CacheStorage interface {
+	Get(key string) ([]byte, error)
+	Set(key string, data []byte) error
+	Delete(key string) error
+}
+
+// CacheOptions 对应配置文件中的 [http.cache]，Storage 为 memory、disk 或 redis
+type CacheOptions struct {
+	Storage    string `mapstructure:"storage"`
+	MaxEntries int    `mapstructure:"max_entries"`
+	Dir        string `mapstructure:"dir"`
+	// RedisPrefix 使用 rds.Default 时的键前缀
+	RedisPrefix string `mapstructure:"redis_prefix"`
+	// MaxBodySize 超过该大小的响应不缓存，默认 10MB
+	MaxBodySize int64 `mapstructure:"max_body_size"`
+	// StaleIfError 网络错误或 5xx 时返回过期的缓存（离线模式）
+	StaleIfError bool `mapstructure:"stale_if_error"`
+	// Private 缓存带 Authorization、Cookie 的请求及 Cache-Control: private 的响应，
+	// 缓存键包含这些头的摘要，默认不缓存
+	Private bool `mapstructure:"private"`
+}
+
+// NewCacheStorage 根据 CacheOptions.Storage 创建存储
+func NewCacheStorage(option CacheOptions) (CacheStorage, error) {
+	switch strings.ToLower(option.Storage) {
+	case "", "memory":
+		return NewMemoryCacheStorage(option.MaxEntries), nil
+	case "disk":
+		if option.Dir == "" {
+			return nil, fmt.Errorf("disk cache needs dir")
+		}
+		return &DiskCacheStorage{Dir: option.Dir}, nil
+	case "redis":
+		return &RedisCacheStorage{Prefix: option.RedisPrefix}, nil
+	}
+	return nil, fmt.Errorf("unknow cache storage \"%s\"", option.Storage)
+}
+
+// MemoryCacheStorage 内存 LRU 缓存
+type MemoryCacheStorage struct {
+	maxEntries int
+
+	lock  sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key  string
+	data []byte
+}
+
+// NewMemoryCacheStorage maxEntries 为 0 时默认 1000
+func NewMemoryCacheStorage(maxEntries int) *MemoryCacheStorage {
+	if maxEntries <= 0 {
+		maxEntries = 1000
+	}
+	return &MemoryCacheStorage{maxEntries: maxEntries, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (s *MemoryCacheStorage) Get(key string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, found := s.items[key]; found {
+		s.ll.MoveToFront(element)
+		return element.Value.(*memoryCacheItem).data, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryCacheStorage) Set(key string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, found := s.items[key]; found {
+		s.ll.MoveToFront(element)
+		element.Value.(*memoryCacheItem).data = data
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(&memoryCacheItem{key: key, data: data})
+	for s.ll.Len() > s.maxEntries {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryCacheItem).key)
+	}
+	return nil
+}
+
+func (s *MemoryCacheStorage) Delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, found := s.items[key]; found {
+		s.ll.Remove(element)
+		delete(s.items, key)
+	}
+	return nil
+}
+
+// DiskCacheStorage 每个缓存项保存为 Dir 下的一个文件
+type DiskCacheStorage struct {
+	Dir string
+}
+
+func (s *DiskCacheStorage) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *DiskCacheStorage) Get(key string) ([]byte, error) {
+	buff, err := ioutil.ReadFile(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buff, err
+}
+
+func (s *DiskCacheStorage) Set(key string, data []byte) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免读到写了一半的内容
+	filename := s.filename(key)
+	tmp := fmt.Sprintf("%s.%s.tmp", filename, randString(8))
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func (s *DiskCacheStorage) Delete(key string) error {
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RedisCacheStorage Client 为空时使用 rds.Default
+type RedisCacheStorage struct {
+	Client *redis.Client
+	Prefix string
+	// Expiration 为 0 时不过期，由 redis 的淘汰策略回收
+	Expiration time.Duration
+}
+
+func (s *RedisCacheStorage) client() (*redis.Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis cache storage needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (s *RedisCacheStorage) key(key string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "codex:http:cache:"
+	}
+	sum := sha1.Sum([]byte(key))
+	return prefix + hex.EncodeToString(sum[:])
+}
+
+func (s *RedisCacheStorage) Get(key string) ([]byte, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	buff, err := client.Get(s.key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return buff, err
+}
+
+func (s *RedisCacheStorage) Set(key string, data []byte) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Set(s.key(key), data, s.Expiration).Err()
+}
+
+func (s *RedisCacheStorage) Delete(key string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Del(s.key(key)).Err()
+}
+
+type cacheEntry struct {
+	Status       int               `json:"status"`
+	Header       http.Header       `json:"header"`
+	Body         []byte            `json:"body"`
+	RequestTime  time.Time         `json:"request_time"`
+	ResponseTime time.Time         `json:"response_time"`
+	Vary         map[string]string `json:"vary,omitempty"`
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header["Cache-Control"] {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if index := strings.Index(part, "="); index >= 0 {
+				cc[strings.ToLower(strings.TrimSpace(part[:index]))] = strings.Trim(strings.TrimSpace(part[index+1:]), "\"")
+			} else {
+				cc[strings.ToLower(part)] = ""
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, found := cc[directive]
+	return found
+}
+
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	value, found := cc[directive]
+	if !found {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// age 按 RFC 7234 4.2.3 计算当前年龄
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	apparentAge := e.ResponseTime.Sub(date)
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	if seconds, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil {
+		if ageValue := time.Duration(seconds) * time.Second; ageValue > apparentAge {
+			apparentAge = ageValue
+		}
+	}
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	return apparentAge + responseDelay + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime 按 RFC 7234 4.2.1 计算，没有明确过期信息时使用 Last-Modified 的 10%
+func (e *cacheEntry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if maxAge, found := cc.seconds("max-age"); found {
+		return maxAge
+	}
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+	if expiresValue := e.Header.Get("Expires"); expiresValue != "" {
+		expires, err := http.ParseTime(expiresValue)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && date.After(lastModified) {
+		return date.Sub(lastModified) / 10
+	}
+	return 0
+}
+
+// isFresh 结合请求中的 max-age、min-fresh、max-stale 判断
+func (e *cacheEntry) isFresh(reqCC cacheControl, now time.Time) bool {
+	respCC := parseCacheControl(e.Header)
+	if respCC.has("no-cache") || reqCC.has("no-cache") {
+		return false
+	}
+	lifetime := e.freshnessLifetime()
+	age := e.age(now)
+	if maxAge, found := reqCC.seconds("max-age"); found && maxAge < lifetime {
+		lifetime = maxAge
+	}
+	if minFresh, found := reqCC.seconds("min-fresh"); found {
+		age += minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	if respCC.has("must-revalidate") {
+		return false
+	}
+	if reqCC.has("max-stale") {
+		maxStale, found := reqCC.seconds("max-stale")
+		return !found || age < lifetime+maxStale
+	}
+	return false
+}
+
+func (e *cacheEntry) allowStaleOnError(force bool, now time.Time) bool {
+	respCC := parseCacheControl(e.Header)
+	if respCC.has("must-revalidate") || respCC.has("no-store") {
+		return false
+	}
+	if force {
+		return true
+	}
+	staleIfError, found := respCC.seconds("stale-if-error")
+	return found && e.age(now) < e.freshnessLifetime()+staleIfError
+}
+
+func (e *cacheEntry) matchVary(req *http.Request) bool {
+	for key, value := range e.Vary {
+		if req.Header.Get(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *cacheEntry) toResponse(req *http.Request, status string) *http.Response {
+	header := http.Header{}
+	for key, values := range e.Header {
+		header[key] = append([]string{}, values...)
+	}
+	header.Set(CacheStatusHeader, status)
+	if status == CacheStale {
+		header.Add("Warning", `110 - "Response is Stale"`)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+func isCacheable(req *http.Request, resp *http.Response, private bool) bool {
+	if !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+	reqCC := parseCacheControl(req.Header)
+	respCC := parseCacheControl(resp.Header)
+	if reqCC.has("no-store") || respCC.has("no-store") {
+		return false
+	}
+	if !private && (hasCredentials(req) || respCC.has("private")) {
+		return false
+	}
+	if resp.Header.Get("Vary") == "*" {
+		return false
+	}
+	return respCC.has("max-age") || resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "" ||
+		respCC.has("no-cache")
+}
+
+type cacheRefreshContextKey struct{}
+
+// withCacheRefresh 单次请求跳过缓存，响应仍会写入缓存
+func withCacheRefresh(req *http.Request, refresh bool) *http.Request {
+	if !refresh {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), cacheRefreshContextKey{}, true))
+}
+
+type cacheScopeContextKey struct{}
+
+// withCacheScope 不同会话的请求使用不同的缓存键
+func withCacheScope(req *http.Request, scope string) *http.Request {
+	if scope == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), cacheScopeContextKey{}, scope))
+}
+
+// CacheTransport 遵循 RFC 7234 的私有缓存
+type CacheTransport struct {
+	Transport http.RoundTripper
+	Storage   CacheStorage
+	Option    CacheOptions
+}
+
+func (t *CacheTransport) Unwrap() http.RoundTripper {
+	return t.Transport
+}
+
+// hasCredentials 请求带有用户凭证，响应可能因用户不同而不同
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+func cacheKey(req *http.Request) string {
+	key := req.URL.String()
+	if req.Method == http.MethodHead {
+		key = "HEAD " + key
+	}
+	if scope, _ := req.Context().Value(cacheScopeContextKey{}).(string); scope != "" {
+		key = fmt.Sprintf("session:%s %s", scope, key)
+	}
+	if hasCredentials(req) {
+		sum := sha1.Sum([]byte(req.Header.Get("Authorization") + "\n" + strings.Join(req.Header["Cookie"], "; ")))
+		key = fmt.Sprintf("credential:%s %s", hex.EncodeToString(sum[:]), key)
+	}
+	return key
+}
+
+func (t *CacheTransport) load(key string) *cacheEntry {
+	buff, err := t.Storage.Get(key)
+	if err != nil || buff == nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(buff, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+func (t *CacheTransport) store(key string, entry *cacheEntry) {
+	buff, err := json.Marshal(entry)
+	if err == nil {
+		t.Storage.Set(key, buff)
+	}
+}
+
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Storage == nil {
+		return t.Transport.RoundTrip(req)
+	}
+
+	if hasCredentials(req) && !t.Option.Private {
+		return t.Transport.RoundTrip(req)
+	}
+	key := cacheKey(req)
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := t.Transport.RoundTrip(req)
+		// 不安全的方法成功后使缓存失效
+		if err == nil && resp.StatusCode < 400 && req.Method != http.MethodOptions && req.Method != http.MethodTrace {
+			t.Storage.Delete(key)
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	refresh, _ := req.Context().Value(cacheRefreshContextKey{}).(bool)
+	now := time.Now()
+
+	var entry *cacheEntry
+	if !refresh && !reqCC.has("no-store") {
+		entry = t.load(key)
+		if entry != nil && !entry.matchVary(req) {
+			entry = nil
+		}
+	}
+	if entry != nil && entry.isFresh(reqCC, now) {
+		return entry.toResponse(req, CacheHit), nil
+	}
+	if reqCC.has("only-if-cached") {
+		if entry != nil {
+			return entry.toResponse(req, CacheStale), nil
+		}
+		return &http.Response{
+			Status:     "504 Gateway Timeout",
+			StatusCode: http.StatusGatewayTimeout,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{CacheStatusHeader: []string{CacheMiss}},
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+
+	outReq := req
+	if entry != nil {
+		outReq = req.WithContext(req.Context())
+		outReq.Header = cloneHeader(req.Header)
+		if etag := entry.Header.Get("ETag"); etag != "" && outReq.Header.Get("If-None-Match") == "" {
+			outReq.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" && outReq.Header.Get("If-Modified-Since") == "" {
+			outReq.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	requestTime := time.Now()
+	resp, err := t.Transport.RoundTrip(outReq)
+	if entry != nil && (err != nil || resp.StatusCode >= 500) && entry.allowStaleOnError(t.Option.StaleIfError, now) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return entry.toResponse(req, CacheStale), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		// 304 中的头覆盖缓存中的同名头（RFC 7234 4.3.4）
+		for key, values := range resp.Header {
+			entry.Header[key] = values
+		}
+		entry.RequestTime = requestTime
+		entry.ResponseTime = time.Now()
+		t.store(key, entry)
+		return entry.toResponse(req, CacheRevalidated), nil
+	}
+
+	resp.Header.Set(CacheStatusHeader, CacheMiss)
+	if !isCacheable(req, resp, t.Option.Private) {
+		if resp.StatusCode < 400 {
+			t.Storage.Delete(key)
+		}
+		return resp, nil
+	}
+
+	maxBodySize := t.Option.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultCacheMaxBodySize
+	}
+	if resp.ContentLength > maxBodySize {
+		return resp, nil
+	}
+	newEntry := &cacheEntry{
+		Status:      resp.StatusCode,
+		Header:      cloneHeader(resp.Header),
+		RequestTime: requestTime,
+		Vary:        map[string]string{},
+	}
+	newEntry.Header.Del(CacheStatusHeader)
+	for _, vary := range resp.Header["Vary"] {
+		for _, name := range strings.Split(vary, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+				newEntry.Vary[name] = req.Header.Get(name)
+			}
+		}
+	}
+	resp.Body = &cachingBody{
+		ReadCloser: resp.Body,
+		limit:      maxBodySize,
+		onComplete: func(body []byte) {
+			newEntry.Body = body
+			newEntry.ResponseTime = time.Now()
+			t.store(key, newEntry)
+		},
+	}
+	return resp, nil
+}
+
+func cloneHeader(header http.Header) http.Header {
+	result := http.Header{}
+	for key, values := range header {
+		result[key] = append([]string{}, values...)
+	}
+	return result
+}
+
+// cachingBody 调用方读取完整响应后写入缓存，超过 limit 时放弃缓存
+type cachingBody struct {
+	io.ReadCloser
+	buff       bytes.Buffer
+	limit      int64
+	exceeded   bool
+	done       bool
+	onComplete func([]byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.exceeded {
+		if int64(b.buff.Len()+n) > b.limit {
+			b.exceeded = true
+			b.buff.Reset()
+		} else {
+			b.buff.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.exceeded && !b.done {
+		b.done = true
+		b.onComplete(b.buff.Bytes())
+	}
+	return n, err
+}
+
+// UseCache 开启响应缓存，传 nil 关闭
+func (c *HttpClient) UseCache(storage CacheStorage, option CacheOptions) {
+	for rt := c.client.Transport; rt != nil; {
+		if cacheTransport, ok := rt.(*CacheTransport); ok {
+			cacheTransport.Storage = storage
+			cacheTransport.Option = option
+			return
+		}
+		wrapped, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapped.Unwrap()
+	}
+	if storage != nil {
+		c.client.Transport = &CacheTransport{Transport: c.client.Transport, Storage: storage, Option: option}
+	}
+}
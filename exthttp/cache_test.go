@@ -0,0 +1,124 @@
+package exthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// cacheTestServer 返回请求次数，/etag 支持条件请求，/vary 按 Accept-Language 返回
+func cacheTestServer(hits *int64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt64(hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(r.Header.Get("Accept-Language")))
+			return
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/user":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(r.Header.Get("Authorization")))
+			return
+		}
+		w.Write([]byte(strconv.FormatInt(count, 10)))
+	}))
+}
+
+func cacheGet(t *testing.T, client *HttpClient, url string, headers map[string]string) (string, string) {
+	resp, err := client.RequestEX(http.MethodGet, url, nil, nil, &RequestOptions{Headers: headers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(resp.Body), resp.Header.Get(CacheStatusHeader)
+}
+
+func TestCacheTransport_Freshness(t *testing.T) {
+	hits := int64(0)
+	server := cacheTestServer(&hits)
+	defer server.Close()
+	client := NewHttpClient(ClientOption{Cache: CacheOptions{Storage: "memory"}})
+
+	if body, status := cacheGet(t, client, server.URL+"/fresh", nil); body != "1" || status != CacheMiss {
+		t.Fatalf("unexpected first response %s %s", body, status)
+	}
+	if body, status := cacheGet(t, client, server.URL+"/fresh", nil); body != "1" || status != CacheHit {
+		t.Fatalf("unexpected cached response %s %s", body, status)
+	}
+	if body, _ := cacheGet(t, client, server.URL+"/fresh", map[string]string{"Cache-Control": "no-cache"}); body != "2" {
+		t.Fatalf("no-cache request should reach server, got %s", body)
+	}
+}
+
+func TestCacheTransport_Revalidate(t *testing.T) {
+	hits := int64(0)
+	server := cacheTestServer(&hits)
+	defer server.Close()
+	client := NewHttpClient(ClientOption{Cache: CacheOptions{Storage: "memory"}})
+
+	cacheGet(t, client, server.URL+"/etag", nil)
+	body, status := cacheGet(t, client, server.URL+"/etag", nil)
+	if body != "1" || status != CacheRevalidated || hits != 2 {
+		t.Fatalf("unexpected revalidated response %s %s hits:%d", body, status, hits)
+	}
+}
+
+func TestCacheTransport_Vary(t *testing.T) {
+	hits := int64(0)
+	server := cacheTestServer(&hits)
+	defer server.Close()
+	client := NewHttpClient(ClientOption{Cache: CacheOptions{Storage: "memory"}})
+
+	cacheGet(t, client, server.URL+"/vary", map[string]string{"Accept-Language": "zh"})
+	if body, status := cacheGet(t, client, server.URL+"/vary", map[string]string{"Accept-Language": "en"}); body != "en" || status != CacheMiss {
+		t.Fatalf("different vary header should miss, got %s %s", body, status)
+	}
+	if body, status := cacheGet(t, client, server.URL+"/vary", map[string]string{"Accept-Language": "en"}); body != "en" || status != CacheHit {
+		t.Fatalf("same vary header should hit, got %s %s", body, status)
+	}
+}
+
+func TestCacheTransport_Credentials(t *testing.T) {
+	hits := int64(0)
+	server := cacheTestServer(&hits)
+	defer server.Close()
+
+	// 默认不缓存带凭证的请求及 private 响应
+	client := NewHttpClient(ClientOption{Cache: CacheOptions{Storage: "memory"}})
+	alice := map[string]string{"Authorization": "Bearer alice"}
+	bob := map[string]string{"Authorization": "Bearer bob"}
+	cacheGet(t, client, server.URL+"/user", alice)
+	if body, _ := cacheGet(t, client, server.URL+"/user", bob); body != "Bearer bob" {
+		t.Fatalf("response of another user leaked: %s", body)
+	}
+	if _, status := cacheGet(t, client, server.URL+"/user", alice); status == CacheHit {
+		t.Fatal("credential request should not be cached by default")
+	}
+	cacheGet(t, client, server.URL+"/private", nil)
+	if _, status := cacheGet(t, client, server.URL+"/private", nil); status == CacheHit {
+		t.Fatal("private response should not be cached by default")
+	}
+
+	// Private 按凭证区分缓存
+	client = NewHttpClient(ClientOption{Cache: CacheOptions{Storage: "memory", Private: true}})
+	cacheGet(t, client, server.URL+"/user", alice)
+	if body, status := cacheGet(t, client, server.URL+"/user", bob); body != "Bearer bob" || status != CacheMiss {
+		t.Fatalf("response of another user leaked: %s %s", body, status)
+	}
+	if body, status := cacheGet(t, client, server.URL+"/user", alice); body != "Bearer alice" || status != CacheHit {
+		t.Fatalf("unexpected cached response %s %s", body, status)
+	}
+}
@@ -0,0 +1,529 @@
+package exthttp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCaptureBodySize   = 64 * 1024
+	defaultCaptureBufferSize = 100
+	maskedValue              = "******"
+)
+
+var defaultMaskHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+type CaptureOptions struct {
+	// MaxBodySize 请求/响应内容最多记录的字节数，默认 64KB
+	MaxBodySize int
+	// BufferSize 最多保留的记录条数，超出时丢弃最早的记录，默认 100
+	BufferSize int
+	// MaskHeaders 追加需要打码的头，Authorization 和 Cookie 默认打码
+	MaskHeaders []string
+}
+
+// Capture 记录完整的请求/响应交换过程，可导出为 HAR 1.2
+type Capture struct {
+	option      CaptureOptions
+	maskHeaders map[string]bool
+
+	lock    sync.Mutex
+	entries []*captureEntry
+}
+
+func NewCapture(option CaptureOptions) *Capture {
+	if option.MaxBodySize <= 0 {
+		option.MaxBodySize = defaultCaptureBodySize
+	}
+	if option.BufferSize <= 0 {
+		option.BufferSize = defaultCaptureBufferSize
+	}
+	capture := &Capture{option: option, maskHeaders: map[string]bool{}}
+	for _, key := range append(defaultMaskHeaders, option.MaskHeaders...) {
+		capture.maskHeaders[http.CanonicalHeaderKey(key)] = true
+	}
+	return capture
+}
+
+type captureEntry struct {
+	started     time.Time
+	request     *http.Request
+	requestBody *limitedBuffer
+
+	response     *http.Response
+	responseBody *limitedBuffer
+	err          error
+
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	gotConn                   time.Time
+	wroteRequest              time.Time
+	firstByte                 time.Time
+	finished                  time.Time
+	serverAddr                string
+}
+
+func (c *Capture) add(entry *captureEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = append(c.entries, entry)
+	if over := len(c.entries) - c.option.BufferSize; over > 0 {
+		c.entries = append([]*captureEntry{}, c.entries[over:]...)
+	}
+}
+
+// Reset 清空已记录的内容
+func (c *Capture) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = nil
+}
+
+// HAR 导出当前缓冲区中的记录
+func (c *Capture) HAR() *HAR {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	har := &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "go-codex/exthttp", Version: "1.0"},
+		Entries: make([]HAREntry, 0, len(c.entries)),
+	}}
+	for _, entry := range c.entries {
+		har.Log.Entries = append(har.Log.Entries, c.harEntry(entry))
+	}
+	return har
+}
+
+func (c *Capture) WriteHAR(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c.HAR())
+}
+
+func (c *Capture) SaveHAR(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return c.WriteHAR(file)
+}
+
+// ServeHTTP 以 HAR 格式输出缓冲区内容，可挂载到管理端接口查看
+func (c *Capture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	c.WriteHAR(w)
+}
+
+// CaptureTransport 记录经过的每一次请求（包括重定向）
+type CaptureTransport struct {
+	Transport http.RoundTripper
+	Capture   *Capture
+}
+
+func (t *CaptureTransport) Unwrap() http.RoundTripper {
+	return t.Transport
+}
+
+type captureContextKey struct{}
+
+// withCapture 将单次请求的 Capture 放入 context，优先于客户端级别的设置
+func withCapture(req *http.Request, capture *Capture) *http.Request {
+	if capture == nil {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), captureContextKey{}, capture))
+}
+
+func captureFrom(ctx context.Context) *Capture {
+	capture, _ := ctx.Value(captureContextKey{}).(*Capture)
+	return capture
+}
+
+func (t *CaptureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	capture := captureFrom(req.Context())
+	if capture == nil {
+		capture = t.Capture
+	}
+	if capture == nil {
+		return t.Transport.RoundTrip(req)
+	}
+
+	entry := &captureEntry{started: time.Now()}
+	mark := func(field *time.Time) {
+		capture.lock.Lock()
+		*field = time.Now()
+		capture.lock.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { mark(&entry.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { mark(&entry.dnsDone) },
+		ConnectStart:         func(string, string) { mark(&entry.connectStart) },
+		ConnectDone:          func(string, string, error) { mark(&entry.connectDone) },
+		TLSHandshakeStart:    func() { mark(&entry.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { mark(&entry.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(&entry.wroteRequest) },
+		GotFirstResponseByte: func() { mark(&entry.firstByte) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			mark(&entry.gotConn)
+			if info.Conn != nil {
+				capture.lock.Lock()
+				entry.serverAddr = info.Conn.RemoteAddr().String()
+				capture.lock.Unlock()
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	if req.Body != nil {
+		entry.requestBody = &limitedBuffer{limit: capture.option.MaxBodySize}
+		req.Body = &teeReadCloser{reader: io.TeeReader(req.Body, entry.requestBody), closer: req.Body}
+	}
+	entry.request = req
+
+	resp, err := t.Transport.RoundTrip(req)
+	capture.add(entry)
+	if err != nil {
+		capture.lock.Lock()
+		entry.err = err
+		entry.finished = time.Now()
+		capture.lock.Unlock()
+		return nil, err
+	}
+
+	capture.lock.Lock()
+	entry.response = resp
+	entry.responseBody = &limitedBuffer{limit: capture.option.MaxBodySize}
+	capture.lock.Unlock()
+	resp.Body = &captureBody{ReadCloser: resp.Body, entry: entry, capture: capture}
+	return resp, nil
+}
+
+type teeReadCloser struct {
+	reader io.Reader
+	closer io.Closer
+}
+
+func (r *teeReadCloser) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *teeReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+// captureBody 读取响应内容时记录，读取完毕或关闭时记录结束时间
+type captureBody struct {
+	io.ReadCloser
+	entry   *captureEntry
+	capture *Capture
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.entry.responseBody.Write(p[:n])
+	}
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *captureBody) finish() {
+	b.capture.lock.Lock()
+	defer b.capture.lock.Unlock()
+	if b.entry.finished.IsZero() {
+		b.entry.finished = time.Now()
+	}
+}
+
+// limitedBuffer 只保留前 limit 字节，记录总长度
+type limitedBuffer struct {
+	lock  sync.Mutex
+	limit int
+	data  []byte
+	size  int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.size += int64(len(p))
+	if remain := b.limit - len(b.data); remain > 0 {
+		if remain > len(p) {
+			remain = len(p)
+		}
+		b.data = append(b.data, p[:remain]...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) content() ([]byte, int64, bool) {
+	if b == nil {
+		return nil, 0, false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.data, b.size, b.size > int64(len(b.data))
+}
+
+// EnableCapture 记录该客户端发出的所有请求，传 nil 关闭
+func (c *HttpClient) EnableCapture(capture *Capture) {
+	for rt := c.client.Transport; rt != nil; {
+		if transport, ok := rt.(*CaptureTransport); ok {
+			transport.Capture = capture
+			return
+		}
+		wrapped, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapped.Unwrap()
+	}
+	if capture != nil {
+		c.client.Transport = &CaptureTransport{Transport: c.client.Transport, Capture: capture}
+	}
+}
+
+// httpClientFor 单次请求指定了 Capture 而客户端没有记录层时，临时包装一层
+func (c *HttpClient) httpClientFor(req *http.Request) *http.Client {
+	if captureFrom(req.Context()) == nil {
+		return c.client
+	}
+	for rt := c.client.Transport; rt != nil; {
+		if _, ok := rt.(*CaptureTransport); ok {
+			return c.client
+		}
+		wrapped, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapped.Unwrap()
+	}
+	client := *c.client
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &CaptureTransport{Transport: transport}
+	return &client
+}
+
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings 单位毫秒，blocked、dns、connect、ssl 不适用时为 -1，send、wait、receive 不能为负数
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func (c *Capture) harHeaders(header http.Header) []HARNameValue {
+	result := []HARNameValue{}
+	for key, values := range header {
+		for _, value := range values {
+			if c.maskHeaders[http.CanonicalHeaderKey(key)] {
+				value = maskedValue
+			}
+			result = append(result, HARNameValue{Name: key, Value: value})
+		}
+	}
+	return result
+}
+
+func (c *Capture) harEntry(entry *captureEntry) HAREntry {
+	req := entry.request
+	proto := req.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	har := HAREntry{
+		StartedDateTime: entry.started.Format(time.RFC3339Nano),
+		Request: HARRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: proto,
+			Cookies:     []HARNameValue{},
+			Headers:     c.harHeaders(req.Header),
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: HARResponse{
+			Cookies:     []HARNameValue{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		ServerIPAddress: entry.serverAddr,
+		Timings:         entry.timings(),
+	}
+	if !c.maskHeaders["Cookie"] {
+		for _, cookie := range req.Cookies() {
+			har.Request.Cookies = append(har.Request.Cookies, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+		}
+	}
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			har.Request.QueryString = append(har.Request.QueryString, HARNameValue{Name: key, Value: value})
+		}
+	}
+	if data, size, truncated := entry.requestBody.content(); entry.requestBody != nil {
+		har.Request.BodySize = size
+		har.Request.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type"), Text: string(data)}
+		if truncated {
+			har.Request.PostData.Comment = "truncated"
+		}
+	}
+
+	if !entry.finished.IsZero() {
+		har.Time = milliseconds(entry.started, entry.finished)
+	}
+	if entry.err != nil {
+		har.Comment = entry.err.Error()
+	}
+	if resp := entry.response; resp != nil {
+		har.Response.Status = resp.StatusCode
+		har.Response.StatusText = http.StatusText(resp.StatusCode)
+		har.Response.HTTPVersion = resp.Proto
+		har.Response.Headers = c.harHeaders(resp.Header)
+		har.Response.RedirectURL = resp.Header.Get("Location")
+		if !c.maskHeaders["Set-Cookie"] {
+			for _, cookie := range resp.Cookies() {
+				har.Response.Cookies = append(har.Response.Cookies, HARNameValue{Name: cookie.Name, Value: cookie.Value})
+			}
+		}
+		data, size, truncated := entry.responseBody.content()
+		har.Response.BodySize = size
+		har.Response.Content = HARContent{Size: size, MimeType: resp.Header.Get("Content-Type"), Text: string(data)}
+		if truncated {
+			har.Response.Content.Comment = "truncated"
+		}
+	}
+	return har
+}
+
+func (e *captureEntry) timings() HARTimings {
+	timings := HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	if !e.dnsStart.IsZero() && !e.dnsDone.IsZero() {
+		timings.DNS = milliseconds(e.dnsStart, e.dnsDone)
+	}
+	if !e.connectStart.IsZero() && !e.connectDone.IsZero() {
+		timings.Connect = milliseconds(e.connectStart, e.connectDone)
+	}
+	if !e.tlsStart.IsZero() && !e.tlsDone.IsZero() {
+		timings.SSL = milliseconds(e.tlsStart, e.tlsDone)
+	}
+	if !e.gotConn.IsZero() {
+		// blocked 不包含 dns 和 connect（ssl 已包含在 connect 中）
+		timings.Blocked = milliseconds(e.started, e.gotConn)
+		if timings.DNS > 0 {
+			timings.Blocked -= timings.DNS
+		}
+		if timings.Connect > 0 {
+			timings.Blocked -= timings.Connect
+		}
+		if timings.Blocked < 0 {
+			timings.Blocked = 0
+		}
+		if !e.wroteRequest.IsZero() {
+			timings.Send = milliseconds(e.gotConn, e.wroteRequest)
+		}
+	}
+	if !e.wroteRequest.IsZero() && !e.firstByte.IsZero() {
+		timings.Wait = milliseconds(e.wroteRequest, e.firstByte)
+	}
+	if !e.firstByte.IsZero() && !e.finished.IsZero() {
+		timings.Receive = milliseconds(e.firstByte, e.finished)
+	}
+	return timings
+}
+
+func milliseconds(start time.Time, end time.Time) float64 {
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
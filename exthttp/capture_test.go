@@ -0,0 +1,59 @@
+package exthttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCapture_HAR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	capture := NewCapture(CaptureOptions{})
+	client := NewHttpClient(ClientOption{})
+	client.EnableCapture(capture)
+	_, err := client.RawRequest(http.MethodPost, server.URL+"/items?page=2", nil, []byte(`{"name":"a"}`), &RequestOptions{
+		ContentType: JSONEncoded,
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 连接失败的请求只有 blocked/dns/connect/ssl 可以为 -1
+	client.RawRequest(http.MethodGet, "http://127.0.0.1:1/", nil, nil, nil)
+
+	var buff bytes.Buffer
+	if err := capture.WriteHAR(&buff); err != nil {
+		t.Fatal(err)
+	}
+	har := HAR{}
+	if err := json.Unmarshal(buff.Bytes(), &har); err != nil {
+		t.Fatal(err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("unexpected har %s", buff.String())
+	}
+	entry := har.Log.Entries[0]
+	if entry.Response.Status != 200 || entry.Response.Content.Text != `{"ok":true}` || entry.Request.PostData.Text != `{"name":"a"}` {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if len(entry.Request.QueryString) != 1 || entry.Request.QueryString[0].Value != "2" {
+		t.Fatalf("unexpected query %+v", entry.Request.QueryString)
+	}
+	for _, header := range entry.Request.Headers {
+		if header.Name == "Authorization" && header.Value == "Bearer secret" {
+			t.Fatal("authorization should be masked")
+		}
+	}
+	for _, entry := range har.Log.Entries {
+		if timings := entry.Timings; timings.Send < 0 || timings.Wait < 0 || timings.Receive < 0 || timings.Blocked < -1 {
+			t.Fatalf("invalid timings %+v", timings)
+		}
+	}
+}
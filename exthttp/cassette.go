@@ -0,0 +1,381 @@
+package exthttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type CassetteMode int
+
+const (
+	// CassetteRecord 发送真实请求并记录到 cassette 文件
+	CassetteRecord CassetteMode = 1
+	// CassetteReplay 只从 cassette 文件回放，不访问网络
+	CassetteReplay CassetteMode = 2
+)
+
+const redactedValue = "[REDACTED]"
+
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+var defaultRedactFields = []string{"access_token", "refresh_token", "token", "secret", "client_secret", "password", "api_key", "sign", "signature"}
+
+type CassetteOptions struct {
+	// Filename 以 .yaml/.yml 结尾时使用 YAML，否则使用 JSON
+	Filename string
+	Mode     CassetteMode
+	// MatchHeaders 除 method、url、body 外参与匹配的请求头
+	MatchHeaders []string
+	// RedactHeaders 追加需要脱敏的请求头/响应头
+	RedactHeaders []string
+	// RedactFields 追加需要脱敏的 query 参数和 JSON/表单字段
+	RedactFields []string
+	// IgnoreBody 匹配时不比较请求内容
+	IgnoreBody bool
+}
+
+type CassetteRequest struct {
+	Method  string      `json:"method" yaml:"method"`
+	URL     string      `json:"url" yaml:"url"`
+	Headers http.Header `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    string      `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	Status       int         `json:"status" yaml:"status"`
+	Headers      http.Header `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body         string      `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty" yaml:"body_encoding,omitempty"`
+}
+
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request" yaml:"request"`
+	Response CassetteResponse `json:"response" yaml:"response"`
+}
+
+type cassetteFile struct {
+	Interactions []*CassetteInteraction `json:"interactions" yaml:"interactions"`
+}
+
+// CassetteTransport 录制/回放 http 请求，用于离线运行集成测试
+type CassetteTransport struct {
+	Transport http.RoundTripper
+
+	option        CassetteOptions
+	redactHeaders map[string]bool
+	redactFields  map[string]bool
+
+	lock         sync.Mutex
+	interactions []*CassetteInteraction
+	used         map[*CassetteInteraction]bool
+}
+
+func NewCassetteTransport(transport http.RoundTripper, option CassetteOptions) (*CassetteTransport, error) {
+
+	if option.Filename == "" {
+		return nil, fmt.Errorf("cassette filename is empty")
+	}
+	if option.Mode != CassetteRecord && option.Mode != CassetteReplay {
+		return nil, fmt.Errorf("unknow cassette mode %d", option.Mode)
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	t := &CassetteTransport{
+		Transport:     transport,
+		option:        option,
+		redactHeaders: map[string]bool{},
+		redactFields:  map[string]bool{},
+		used:          map[*CassetteInteraction]bool{},
+	}
+	for _, key := range append(defaultRedactHeaders, option.RedactHeaders...) {
+		t.redactHeaders[http.CanonicalHeaderKey(key)] = true
+	}
+	for _, key := range append(defaultRedactFields, option.RedactFields...) {
+		t.redactFields[strings.ToLower(key)] = true
+	}
+
+	if option.Mode == CassetteReplay {
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *CassetteTransport) Unwrap() http.RoundTripper {
+	return t.Transport
+}
+
+func (t *CassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	recorded := t.recordRequest(req, body)
+
+	if t.option.Mode == CassetteReplay {
+		interaction := t.find(recorded)
+		if interaction == nil {
+			return nil, fmt.Errorf("cassette %s has no interaction for %s %s", t.option.Filename, recorded.Method, recorded.URL)
+		}
+		return interaction.Response.toResponse(req)
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBuff, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBuff))
+
+	interaction := &CassetteInteraction{
+		Request:  recorded,
+		Response: t.recordResponse(resp, responseBuff),
+	}
+	t.lock.Lock()
+	t.interactions = append(t.interactions, interaction)
+	err = t.save()
+	t.lock.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("cassette save error:%s", err.Error())
+	}
+	return resp, nil
+}
+
+// find 优先返回未使用过的匹配记录，全部使用过时返回最后一条匹配记录
+func (t *CassetteTransport) find(recorded CassetteRequest) *CassetteInteraction {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var last *CassetteInteraction
+	for _, interaction := range t.interactions {
+		if !t.match(interaction.Request, recorded) {
+			continue
+		}
+		if !t.used[interaction] {
+			t.used[interaction] = true
+			return interaction
+		}
+		last = interaction
+	}
+	return last
+}
+
+func (t *CassetteTransport) match(a CassetteRequest, b CassetteRequest) bool {
+	if a.Method != b.Method || a.URL != b.URL {
+		return false
+	}
+	if !t.option.IgnoreBody && a.Body != b.Body {
+		return false
+	}
+	for _, key := range t.option.MatchHeaders {
+		if a.Headers.Get(key) != b.Headers.Get(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *CassetteTransport) recordRequest(req *http.Request, body []byte) CassetteRequest {
+	u := *req.URL
+	query := u.Query()
+	for key := range query {
+		if t.redactFields[strings.ToLower(key)] {
+			query.Set(key, redactedValue)
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return CassetteRequest{
+		Method:  req.Method,
+		URL:     u.String(),
+		Headers: t.redactHeader(req.Header),
+		Body:    t.redactBody(req.Header.Get("Content-Type"), body),
+	}
+}
+
+func (t *CassetteTransport) recordResponse(resp *http.Response, body []byte) CassetteResponse {
+	recorded := CassetteResponse{
+		Status:  resp.StatusCode,
+		Headers: t.redactHeader(resp.Header),
+	}
+	if utf8.Valid(body) {
+		recorded.Body = t.redactBody(resp.Header.Get("Content-Type"), body)
+	} else {
+		recorded.Body = base64.StdEncoding.EncodeToString(body)
+		recorded.BodyEncoding = "base64"
+	}
+	return recorded
+}
+
+func (r *CassetteResponse) toResponse(req *http.Request) (*http.Response, error) {
+	body := []byte(r.Body)
+	if r.BodyEncoding == "base64" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("cassette response body error:%s", err.Error())
+		}
+	}
+	header := http.Header{}
+	for key, values := range r.Headers {
+		header[key] = append([]string{}, values...)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *CassetteTransport) redactHeader(header http.Header) http.Header {
+	result := http.Header{}
+	for key, values := range header {
+		if t.redactHeaders[http.CanonicalHeaderKey(key)] {
+			result[key] = []string{redactedValue}
+		} else {
+			result[key] = append([]string{}, values...)
+		}
+	}
+	return result
+}
+
+func (t *CassetteTransport) redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	switch encodeTypeOf(contentType) {
+	case JSONEncoded:
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err == nil {
+			if buff, err := json.Marshal(t.redactJSON(value)); err == nil {
+				return string(buff)
+			}
+		}
+	case URLEncoded:
+		if values, err := nurl.ParseQuery(string(body)); err == nil {
+			for key := range values {
+				if t.redactFields[strings.ToLower(key)] {
+					values.Set(key, redactedValue)
+				}
+			}
+			return values.Encode()
+		}
+	}
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return base64.StdEncoding.EncodeToString(body)
+}
+
+func (t *CassetteTransport) redactJSON(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for key, item := range val {
+			if t.redactFields[strings.ToLower(key)] {
+				val[key] = redactedValue
+			} else {
+				val[key] = t.redactJSON(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = t.redactJSON(item)
+		}
+	}
+	return value
+}
+
+func (t *CassetteTransport) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(t.option.Filename))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func (t *CassetteTransport) load() error {
+	buff, err := ioutil.ReadFile(t.option.Filename)
+	if err != nil {
+		return fmt.Errorf("cassette load error:%s", err.Error())
+	}
+	var file cassetteFile
+	if t.isYAML() {
+		err = yaml.Unmarshal(buff, &file)
+	} else {
+		err = json.Unmarshal(buff, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("cassette %s decode error:%s", t.option.Filename, err.Error())
+	}
+	t.interactions = file.Interactions
+	return nil
+}
+
+func (t *CassetteTransport) save() error {
+	file := cassetteFile{Interactions: t.interactions}
+	var buff []byte
+	var err error
+	if t.isYAML() {
+		buff, err = yaml.Marshal(&file)
+	} else {
+		buff, err = json.MarshalIndent(&file, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(t.option.Filename); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(t.option.Filename, buff, 0644)
+}
+
+// UseCassette 在当前 Transport 外包装录制/回放层
+func (c *HttpClient) UseCassette(option CassetteOptions) error {
+	transport, err := NewCassetteTransport(c.client.Transport, option)
+	if err != nil {
+		return err
+	}
+	c.client.Transport = transport
+	return nil
+}
+
+func cassetteModeOf(value string) CassetteMode {
+	switch strings.ToLower(value) {
+	case "record":
+		return CassetteRecord
+	case "replay":
+		return CassetteReplay
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package exthttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassette_RecordReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "sid=secret-cookie")
+		w.Write([]byte(`{"access_token":"secret-token","echo":"` + string(body) + `"}`))
+	}))
+
+	filename := filepath.Join(t.TempDir(), "cassette.yaml")
+	options := &RequestOptions{Headers: map[string]string{"Authorization": "Bearer secret-auth"}, ContentType: TextEncoded}
+	recorder := NewHttpClient(ClientOption{})
+	if err := recorder.UseCassette(CassetteOptions{Filename: filename, Mode: CassetteRecord}); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := recorder.RawRequest(http.MethodPost, server.URL+"/login", map[string]string{"token": "secret-query"}, []byte("hello"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	buff, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-token", "secret-cookie", "secret-auth", "secret-query"} {
+		if strings.Contains(string(buff), secret) {
+			t.Fatalf("cassette should not contain %s:\n%s", secret, buff)
+		}
+	}
+
+	player := NewHttpClient(ClientOption{})
+	if err := player.UseCassette(CassetteOptions{Filename: filename, Mode: CassetteReplay}); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := player.RawRequest(http.MethodPost, server.URL+"/login", map[string]string{"token": "secret-query"}, []byte("hello"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || !strings.Contains(string(replayed), `"echo":"hello"`) || strings.Contains(string(replayed), "secret-token") {
+		t.Fatalf("unexpected replay calls:%d recorded:%s replayed:%s", calls, recorded, replayed)
+	}
+
+	if _, err := player.RawRequest(http.MethodPost, server.URL+"/login", nil, []byte("other"), options); err == nil {
+		t.Fatal("unmatched request should fail in replay mode")
+	}
+}
@@ -0,0 +1,93 @@
+package exthttp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// CharsetDecoder 返回将指定字符集转换为 UTF-8 的 Transformer
+//
+// 未注册的字符集按 WHATWG Encoding 标准的名称查找（htmlindex），如 big5、shift_jis、euc-kr、windows-1251
+type CharsetDecoder func() transform.Transformer
+
+var charsetLock = sync.RWMutex{}
+
+var charsets = map[string]CharsetDecoder{
+	"utf-8":      nil,
+	"utf8":       nil,
+	"us-ascii":   nil,
+	"ascii":      nil,
+	"iso-8859-1": decoderOf(charmap.ISO8859_1),
+	"latin1":     decoderOf(charmap.ISO8859_1),
+	"gbk":        decoderOf(simplifiedchinese.GBK),
+	"gb2312":     decoderOf(simplifiedchinese.GBK),
+	"gb18030":    decoderOf(simplifiedchinese.GB18030),
+}
+
+func decoderOf(enc encoding.Encoding) CharsetDecoder {
+	return func() transform.Transformer {
+		return enc.NewDecoder()
+	}
+}
+
+// RegisterCharset 注册字符集转换，name 不区分大小写
+func RegisterCharset(name string, decoder CharsetDecoder) {
+	charsetLock.Lock()
+	defer charsetLock.Unlock()
+	charsets[strings.ToLower(name)] = decoder
+}
+
+// TranscodeToUTF8 将 charset 编码的内容转换为 UTF-8
+func TranscodeToUTF8(data []byte, charset string) ([]byte, error) {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" {
+		return data, nil
+	}
+
+	charsetLock.RLock()
+	decoder, found := charsets[charset]
+	charsetLock.RUnlock()
+
+	if !found {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported charset \"%s\", register it with exthttp.RegisterCharset", charset)
+		}
+		decoder = decoderOf(enc)
+	}
+	if decoder == nil {
+		return data, nil
+	}
+	result, _, err := transform.Bytes(decoder(), data)
+	if err != nil {
+		return nil, fmt.Errorf("transcode charset \"%s\" error:%s", charset, err.Error())
+	}
+	return result, nil
+}
+
+var xmlEncodingRegex = regexp.MustCompile(`^\s*<\?xml[^>]*encoding=["']([A-Za-z0-9._-]+)["']`)
+
+// responseCharset 优先使用 Content-Type 中的 charset，XML 内容再从声明中查找
+func responseCharset(params map[string]string, mediaType string, data []byte) string {
+	if charset := params["charset"]; charset != "" {
+		return charset
+	}
+	if isXMLMediaType(mediaType) {
+		head := data
+		if len(head) > 256 {
+			head = head[:256]
+		}
+		if match := xmlEncodingRegex.FindSubmatch(head); match != nil {
+			return string(match[1])
+		}
+	}
+	return ""
+}
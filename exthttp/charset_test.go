@@ -0,0 +1,47 @@
+package exthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// "中文编码" 的 GBK 编码
+var gbkSample = []byte{0xd6, 0xd0, 0xce, 0xc4, 0xb1, 0xe0, 0xc2, 0xeb}
+
+func TestTranscodeToUTF8(t *testing.T) {
+	for _, charset := range []string{"GBK", "gb2312", "GB18030"} {
+		result, err := TranscodeToUTF8(gbkSample, charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(result) != "中文编码" {
+			t.Fatalf("%s: unexpected result %q", charset, result)
+		}
+	}
+
+	// htmlindex 中的名称
+	result, err := TranscodeToUTF8([]byte{0xa4, 0xa4}, "big5")
+	if err != nil || string(result) != "中" {
+		t.Fatalf("big5: unexpected result %q %v", result, err)
+	}
+	if _, err := TranscodeToUTF8([]byte("x"), "x-unknown"); err == nil {
+		t.Fatal("unknown charset should be rejected")
+	}
+}
+
+func TestHttpClient_RequestIntoGBK(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=gbk")
+		w.Write(gbkSample)
+	}))
+	defer server.Close()
+
+	var text string
+	if err := DefaultClient.RequestInto(http.MethodGet, server.URL, nil, nil, &text, nil); err != nil {
+		t.Fatal(err)
+	}
+	if text != "中文编码" {
+		t.Fatalf("unexpected text %q", text)
+	}
+}
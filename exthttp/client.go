@@ -2,13 +2,13 @@ package exthttp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
-	httpcookiejar "net/http/cookiejar"
 	"net/url"
 	nurl "net/url"
 	"strings"
@@ -17,11 +17,15 @@ import (
 	cookiejar "github.com/juju/persistent-cookiejar"
 
 	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/utils"
 )
 
 type HttpClient struct {
 	client         *http.Client
 	hookBeforeSend func(*http.Request)
+	sessions       *SessionManager
+	// cacheScope Session 返回的客户端使用会话名区分缓存
+	cacheScope string
 }
 
 type HttpResponseError struct {
@@ -44,6 +48,34 @@ func IsHttpResponseError(err error) bool {
 	return ok
 }
 
+// RequestError 连接失败、超时等没有收到响应的错误，实现 net.Error，Err 为原始错误
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request error:%s", e.Err.Error())
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) Timeout() bool {
+	val, ok := e.Err.(interface{ Timeout() bool })
+	return ok && val.Timeout()
+}
+
+func (e *RequestError) Temporary() bool {
+	val, ok := e.Err.(interface{ Temporary() bool })
+	return ok && val.Temporary()
+}
+
+func IsRequestError(err error) bool {
+	_, ok := err.(*RequestError)
+	return ok
+}
+
 func (c *HttpClient) GetJSON(url string, queryParams map[string]string, responseData interface{}, options *RequestOptions) error {
 
 	return c.RequestJSON(http.MethodGet, url, queryParams, nil, responseData, options)
@@ -79,23 +111,23 @@ func (c *HttpClient) RequestJSON(method string, url string, queryParams map[stri
 }
 
 func (c *HttpClient) RawRequest(method string, url string, queryParams map[string]string, body []byte, options *RequestOptions) ([]byte, error) {
+	resp, err := c.RawRequestEX(method, url, queryParams, body, options)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// RawRequestEX 与 RawRequest 相同，返回完整的响应信息
+func (c *HttpClient) RawRequestEX(method string, url string, queryParams map[string]string, body []byte, options *RequestOptions) (*Response, error) {
 
 	var byteBuff *bytes.Buffer
 	var err error
-	encodeType := JSONEncoded
 
-	u, err := nurl.Parse(url)
+	url, err = buildURL(url, queryParams)
 	if err != nil {
 		return nil, err
 	}
-	params := u.Query()
-	if queryParams != nil {
-		for key, value := range queryParams {
-			params.Set(key, value)
-		}
-	}
-	u.RawQuery = params.Encode()
-	url = u.String()
 
 	if options == nil {
 		options = &RequestOptions{}
@@ -104,25 +136,18 @@ func (c *HttpClient) RawRequest(method string, url string, queryParams map[strin
 		options.Headers = map[string]string{}
 	}
 
-	if method == "POST" {
+	if method == http.MethodPost || (body != nil && hasRequestBody(method)) {
 
 		if options.ContentType == 0 {
-			if contentType := options.Headers["Content-Type"]; strings.HasPrefix(strings.ToLower(contentType),
-				"application/x-www-form-urlencoded") {
-				encodeType = URLEncoded
-			} else if contentType := options.Headers["Content-Type"]; strings.HasPrefix(strings.ToLower(contentType),
-				"application/json") {
-				encodeType = JSONEncoded
-			} else {
+			if contentType := options.Headers["Content-Type"]; encodeTypeOf(contentType) == 0 {
 				return nil, fmt.Errorf("unknow http content-type \"%s\"", contentType)
 			}
-		} else if options.ContentType != 0 {
-			encodeType = options.ContentType
-			if encodeType == URLEncoded {
-				options.Headers["Content-Type"] = "application/x-www-form-urlencoded"
-			} else if encodeType == JSONEncoded {
-				options.Headers["Content-Type"] = "application/json"
+		} else if options.ContentType == MultipartEncoded {
+			if contentType := options.Headers["Content-Type"]; !strings.Contains(contentType, "boundary=") {
+				return nil, fmt.Errorf("multipart content-type needs boundary \"%s\"", contentType)
 			}
+		} else if contentType, found := encodeContentTypes[options.ContentType]; found {
+			options.Headers["Content-Type"] = contentType
 		}
 		byteBuff = bytes.NewBuffer(body)
 	}
@@ -142,48 +167,83 @@ func (c *HttpClient) RawRequest(method string, url string, queryParams map[strin
 			req.Header.Add(key, value)
 		}
 	}
+	if options.Context != nil {
+		req = req.WithContext(options.Context)
+	}
+	req = withCapture(req, options.Capture)
+	req = withCacheRefresh(req, options.CacheRefresh)
+	if req, err = withProxy(req, options.Proxy); err != nil {
+		return nil, err
+	}
+
+	return c.send(req, options, options.AcceptStatus...)
+}
+
+func buildURL(url string, queryParams map[string]string) (string, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	params := u.Query()
+	if queryParams != nil {
+		for key, value := range queryParams {
+			params.Set(key, value)
+		}
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
 
+// do 发送请求，状态码不在 acceptStatus 中时（默认只接受 200）读取响应内容并返回 HttpResponseError
+func (c *HttpClient) do(req *http.Request, acceptStatus ...int) (*http.Response, error) {
 	if c.hookBeforeSend != nil {
 		c.hookBeforeSend(req)
 	}
-	resp, err := c.client.Do(req)
+	req = withCacheScope(req, c.cacheScope)
+	resp, err := c.httpClientFor(req).Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request error:%s", err.Error())
+		return nil, &RequestError{Err: err}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		var buff []byte
-		buff, _ = ioutil.ReadAll(resp.Body)
-		return nil, &HttpResponseError{
-			Status:         resp.StatusCode,
-			ResponseHeader: resp.Header,
-			ResponseData:   buff,
+	if acceptStatus == nil {
+		acceptStatus = []int{http.StatusOK}
+	}
+	for _, status := range acceptStatus {
+		if resp.StatusCode == status {
+			return resp, nil
 		}
 	}
-
-	var responseBuff []byte
-	responseBuff, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("response error:%s", err.Error())
+	defer resp.Body.Close()
+	var buff []byte
+	buff, _ = ioutil.ReadAll(resp.Body)
+	return nil, &HttpResponseError{
+		RequestURL:     req.URL.String(),
+		Status:         resp.StatusCode,
+		ResponseHeader: resp.Header,
+		ResponseData:   buff,
 	}
+}
 
-	if options != nil && options.ResponseHeaders != nil {
-		options.ResponseHeaders = resp.Header
+func (c *HttpClient) Request(method string, url string, queryParams map[string]string, formParams map[string]interface{}, options *RequestOptions) ([]byte, error) {
+	resp, err := c.RequestEX(method, url, queryParams, formParams, options)
+	if err != nil {
+		return nil, err
 	}
-
-	return responseBuff, nil
-
+	return resp.Body, nil
 }
 
-func (c *HttpClient) Request(method string, url string, queryParams map[string]string, formParams map[string]interface{}, options *RequestOptions) ([]byte, error) {
+// RequestEX 与 Request 相同，返回完整的响应信息
+func (c *HttpClient) RequestEX(method string, url string, queryParams map[string]string, formParams map[string]interface{}, options *RequestOptions) (*Response, error) {
 	var byteBuff *bytes.Buffer
 	var err error
 	encodeType := JSONEncoded
 
 	if options != nil {
 		encodeType = options.ContentType
+		if encodeType == 0 {
+			encodeType = encodeTypeOf(options.Headers["Content-Type"])
+		}
 	}
-	if method == "POST" {
+	if hasRequestBody(method) {
 		if formParams != nil {
 			if encodeType == URLEncoded {
 				byteBuff = bytes.NewBuffer(EncodeParams(formParams))
@@ -192,24 +252,45 @@ func (c *HttpClient) Request(method string, url string, queryParams map[string]s
 				if err != nil {
 					return nil, fmt.Errorf("request json content error:%s", err.Error())
 				}
+			} else if encodeType == MultipartEncoded {
+				return c.requestMultipart(method, url, queryParams, formParams, options)
 			}
 		}
 	}
 	if byteBuff != nil {
-		return c.RawRequest(method, url, queryParams, byteBuff.Bytes(), options)
+		return c.RawRequestEX(method, url, queryParams, byteBuff.Bytes(), options)
 	}
 
-	return c.RawRequest(method, url, queryParams, nil, options)
+	return c.RawRequestEX(method, url, queryParams, nil, options)
 
 }
 
-func (c *HttpClient) SetProxy(host string, port int) {
+func (c *HttpClient) SetProxy(host string, port int) error {
 	proxyURL, err := url.Parse(fmt.Sprintf("http://%s:%d", host, port))
 	if err != nil {
-		// log
+		return fmt.Errorf("proxy error:%s", err.Error())
+	}
+	transport := c.baseTransport()
+	if transport == nil {
+		return fmt.Errorf("proxy needs *http.Transport")
+	}
+	transport.Proxy = proxyFromContext(http.ProxyURL(proxyURL))
+	return nil
+}
+
+// baseTransport 返回被 CassetteTransport 等包装层包裹的最内层 *http.Transport
+func (c *HttpClient) baseTransport() *http.Transport {
+	rt := c.client.Transport
+	for {
+		switch transport := rt.(type) {
+		case *http.Transport:
+			return transport
+		case interface{ Unwrap() http.RoundTripper }:
+			rt = transport.Unwrap()
+		default:
+			return nil
+		}
 	}
-	transport := c.client.Transport.(*http.Transport)
-	transport.Proxy = http.ProxyURL(proxyURL)
 }
 
 func (c *HttpClient) SetTimeout(timeout time.Duration) {
@@ -259,50 +340,67 @@ func randString(n int) string {
 	return string(b)
 }
 
-func (c *HttpClient) Jar() *httpcookiejar.Jar {
-	return c.Jar()
+func (c *HttpClient) Jar() http.CookieJar {
+	return c.client.Jar
 }
 
-func newClient(option ClientOption) *http.Client {
-	transport := &http.Transport{}
-	if option.Proxy != "" {
-		proxyURL, err := url.Parse(option.Proxy)
-		if err == nil {
-			transport.Proxy = http.ProxyURL(proxyURL)
-		} else {
-			// TODO: LOG ERROR
-		}
-	}
+func newClient(option ClientOption) (*http.Client, error) {
+	transport, err := newTransport(option)
 	client := &http.Client{Transport: transport, Timeout: time.Duration(option.TimeoutSecond) * time.Second}
-	return client
+	return client, err
 }
 
+// NewHttpClient 创建客户端，TimeoutSecond、Proxy 为空时使用配置中的值，配置错误时记录日志
 func NewHttpClient(option ClientOption) *HttpClient {
-	proxyValue := configs.Settings.GetString(httpProxySettingKey)
-	transport := &http.Transport{}
-
-	if proxyValue != "" {
-		proxyURL, err := url.Parse(proxyValue)
-		if err == nil {
-			transport.Proxy = http.ProxyURL(proxyURL)
-		} else {
-			// TODO: LOG ERROR
-		}
-	}
-
-	client := &HttpClient{
-		client: newClient(ClientOption{
-			Proxy:         proxyValue,
-			TimeoutSecond: configs.Settings.GetInt(httpTimeoutSecondSettingKey),
-		}),
+	client, err := NewHttpClientEX(option)
+	if err != nil {
+		log.Println(fmt.Sprintf("exthttp client option error:%s", err.Error()))
 	}
-
 	return client
 }
 
-type ClientOption struct {
-	TimeoutSecond int
-	Proxy         string
+// NewHttpClientEX 同 NewHttpClient，证书等配置错误时返回错误
+func NewHttpClientEX(option ClientOption) (*HttpClient, error) {
+	if option.Proxy == "" {
+		option.Proxy = configs.Settings.GetString(httpProxySettingKey)
+	}
+	if option.TimeoutSecond == 0 {
+		option.TimeoutSecond = configs.Settings.GetInt(httpTimeoutSecondSettingKey)
+	}
+	client, err := newClient(option)
+	httpClient := &HttpClient{client: client, sessions: NewSessionManager(nil)}
+	if err != nil {
+		return httpClient, err
+	}
+	if len(option.ProxyPool.Proxies) > 0 || option.ProxyPool.File != "" {
+		pool, err := NewProxyPool(option.ProxyPool)
+		if err != nil {
+			return httpClient, err
+		}
+		httpClient.UseProxyPool(pool)
+	}
+	if option.EC1 {
+		keyring, err := utils.DefaultEC1Keyring()
+		if err != nil {
+			return httpClient, err
+		}
+		httpClient.UseEC1(keyring)
+	}
+	if option.Cache.Storage != "" {
+		storage, err := NewCacheStorage(option.Cache)
+		if err != nil {
+			return httpClient, err
+		}
+		httpClient.UseCache(storage, option.Cache)
+	}
+	if option.Auth.Type != "" {
+		provider, err := NewAuthProvider(option.Auth)
+		if err != nil {
+			return httpClient, err
+		}
+		httpClient.UseAuth(provider)
+	}
+	return httpClient, nil
 }
 
 var DefaultClient *HttpClient
@@ -312,32 +410,96 @@ const (
 	httpProxySettingKey         = "http_proxy"
 	httpUseCookieJarSettingKey  = "http_use_cookie_jar"
 	httpDebugErrorJSON          = "http_debug_error_json"
+	httpCassetteModeSettingKey  = "http_cassette_mode"
+	httpCassetteFileSettingKey  = "http_cassette_file"
+	httpCassetteMatchHeadersKey = "http_cassette_match_headers"
 )
 
 type HttpRequestEncodeType int
 
 const (
-	URLEncoded  HttpRequestEncodeType = 1
-	JSONEncoded HttpRequestEncodeType = 2
+	URLEncoded         HttpRequestEncodeType = 1
+	JSONEncoded        HttpRequestEncodeType = 2
+	MultipartEncoded   HttpRequestEncodeType = 3
+	TextEncoded        HttpRequestEncodeType = 4
+	XMLEncoded         HttpRequestEncodeType = 5
+	OctetStreamEncoded HttpRequestEncodeType = 6
 )
 
+var encodeContentTypes = map[HttpRequestEncodeType]string{
+	URLEncoded:         "application/x-www-form-urlencoded",
+	JSONEncoded:        "application/json",
+	MultipartEncoded:   "multipart/form-data",
+	TextEncoded:        "text/plain; charset=utf-8",
+	XMLEncoded:         "application/xml",
+	OctetStreamEncoded: "application/octet-stream",
+}
+
+// encodeTypeOf 根据 Content-Type 判断编码类型，未知时返回 0
+func encodeTypeOf(contentType string) HttpRequestEncodeType {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		return URLEncoded
+	case strings.HasPrefix(contentType, "application/json"):
+		return JSONEncoded
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		return MultipartEncoded
+	case strings.HasPrefix(contentType, "text/plain"):
+		return TextEncoded
+	case strings.HasPrefix(contentType, "application/xml"), strings.HasPrefix(contentType, "text/xml"):
+		return XMLEncoded
+	case strings.HasPrefix(contentType, "application/octet-stream"):
+		return OctetStreamEncoded
+	}
+	return 0
+}
+
+func hasRequestBody(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
 func init() {
 	configs.Settings.SetDefault(httpTimeoutSecondSettingKey, 90)
 	configs.Settings.SetDefault(httpUseCookieJarSettingKey, false)
 	configs.Settings.SetDefault(httpDebugErrorJSON, true)
 
-	DefaultClient = NewHttpClient(ClientOption{
-		Proxy:         configs.Settings.GetString(httpProxySettingKey),
-		TimeoutSecond: configs.Settings.GetInt(httpTimeoutSecondSettingKey),
-	})
+	DefaultClient = NewHttpClient(LoadClientOption(""))
 
 	DefaultClient.UseCookieJar(configs.Settings.GetBool(httpUseCookieJarSettingKey), nil)
+
+	if mode := cassetteModeOf(configs.Settings.GetString(httpCassetteModeSettingKey)); mode != 0 {
+		err := DefaultClient.UseCassette(CassetteOptions{
+			Filename:     configs.Settings.GetString(httpCassetteFileSettingKey),
+			Mode:         mode,
+			MatchHeaders: configs.Settings.GetStringSlice(httpCassetteMatchHeadersKey),
+		})
+		if err != nil {
+			log.Println(fmt.Sprintf("exthttp cassette error:%s", err.Error()))
+		}
+	}
 }
 
 type RequestOptions struct {
 	Headers         map[string]string
 	ContentType     HttpRequestEncodeType
 	ResponseHeaders http.Header
+	// Progress 上传/流式下载时的进度回调
+	Progress ProgressFunc
+	// Capture 记录本次请求的完整交换过程
+	Capture *Capture
+	// Proxy 本次请求固定使用的代理，不参与代理池轮换
+	Proxy string
+	// CacheRefresh 跳过响应缓存强制请求，响应仍会写入缓存
+	CacheRefresh bool
+	// AcceptStatus 视为成功的状态码，为空时只接受 200
+	AcceptStatus []int
+	// Context 结束时取消请求
+	Context context.Context
 }
 
 func mapToByteBuffer(data map[string]interface{}) (*bytes.Buffer, error) {
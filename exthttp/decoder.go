@@ -0,0 +1,259 @@
+package exthttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/zhin/go-codex/configs"
+)
+
+// ResponseDecoder 将响应内容（已转换为 UTF-8）解码到 v
+type ResponseDecoder func(data []byte, v interface{}) error
+
+var decoderLock = sync.RWMutex{}
+
+var decoders = map[string]ResponseDecoder{
+	"application/json":                  decodeJSON,
+	"text/json":                         decodeJSON,
+	"application/xml":                   decodeXML,
+	"text/xml":                          decodeXML,
+	"application/x-www-form-urlencoded": decodeForm,
+	"application/x-protobuf":            decodeProtobuf,
+	"application/protobuf":              decodeProtobuf,
+	"application/vnd.google.protobuf":   decodeProtobuf,
+	"application/octet-stream":          decodeText,
+	"text/plain":                        decodeText,
+}
+
+// RegisterDecoder 注册响应解码器，mediaType 如 "application/msgpack"，不含参数
+func RegisterDecoder(mediaType string, decoder ResponseDecoder) {
+	decoderLock.Lock()
+	defer decoderLock.Unlock()
+	decoders[strings.ToLower(mediaType)] = decoder
+}
+
+func findDecoder(mediaType string) ResponseDecoder {
+	decoderLock.RLock()
+	defer decoderLock.RUnlock()
+
+	if decoder, found := decoders[mediaType]; found {
+		return decoder
+	}
+	if strings.HasSuffix(mediaType, "+json") {
+		return decodeJSON
+	}
+	if strings.HasSuffix(mediaType, "+xml") {
+		return decodeXML
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return decodeText
+	}
+	return nil
+}
+
+// RequestInto 根据响应的 Content-Type 选择解码器将内容解码到 out，非 UTF-8 内容会先转换编码
+func (c *HttpClient) RequestInto(method string, url string, queryParams map[string]string, formParams map[string]interface{}, out interface{}, options *RequestOptions) error {
+
+	if options == nil {
+		options = &RequestOptions{}
+	}
+	if options.Headers["Content-Type"] == "" && options.ContentType == 0 {
+		options.ContentType = JSONEncoded
+	}
+
+	resp, err := c.RequestEX(method, url, queryParams, formParams, options)
+	if err != nil {
+		return err
+	}
+	err = resp.Decode(out)
+	if err != nil && configs.Settings.GetBool(httpDebugErrorJSON) {
+		log.Println(fmt.Sprintf("Error:%s", err.Error()))
+		log.Println(fmt.Sprintf("url:%s\ncontent:%s", url, string(resp.Body)))
+	}
+	return err
+}
+
+// DecodeResponse 按 contentType 转换编码并解码，未声明类型时按 JSON 处理
+func DecodeResponse(contentType string, data []byte, out interface{}) error {
+
+	mediaType := "application/json"
+	params := map[string]string{}
+	if contentType != "" {
+		var err error
+		mediaType, params, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return fmt.Errorf("response content-type error \"%s\":%s", contentType, err.Error())
+		}
+	}
+
+	decoder := findDecoder(mediaType)
+	if decoder == nil {
+		if buff, ok := out.(*[]byte); ok {
+			*buff = data
+			return nil
+		}
+		return fmt.Errorf("unknow response content-type \"%s\"", contentType)
+	}
+
+	if !isBinaryMediaType(mediaType) {
+		var err error
+		data, err = TranscodeToUTF8(data, responseCharset(params, mediaType, data))
+		if err != nil {
+			return err
+		}
+	}
+	return decoder(data, out)
+}
+
+func isXMLMediaType(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+func isBinaryMediaType(mediaType string) bool {
+	return mediaType == "application/octet-stream" || strings.Contains(mediaType, "protobuf")
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewBuffer(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func decodeXML(data []byte, v interface{}) error {
+	decoder := xml.NewDecoder(bytes.NewBuffer(data))
+	// 内容已在 DecodeResponse 中转换为 UTF-8，忽略 XML 声明中的编码
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	return decoder.Decode(v)
+}
+
+func decodeProtobuf(data []byte, v interface{}) error {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf response needs proto.Message, got %T", v)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+func decodeText(data []byte, v interface{}) error {
+	switch out := v.(type) {
+	case *string:
+		*out = string(data)
+	case *[]byte:
+		*out = data
+	default:
+		return fmt.Errorf("text response needs *string or *[]byte, got %T", v)
+	}
+	return nil
+}
+
+// decodeForm 支持 *url.Values、*map[string]string、*map[string][]string 以及带 form 标签的结构体
+func decodeForm(data []byte, v interface{}) error {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return err
+	}
+	switch out := v.(type) {
+	case *url.Values:
+		*out = values
+		return nil
+	case *map[string][]string:
+		*out = values
+		return nil
+	case *map[string]string:
+		if *out == nil {
+			*out = map[string]string{}
+		}
+		for key := range values {
+			(*out)[key] = values.Get(key)
+		}
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form response needs pointer to struct or map, got %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Tag.Get("form")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if value, found := values[name]; found && len(value) > 0 {
+			if err := setFormField(rv.Field(i), value); err != nil {
+				return fmt.Errorf("form field \"%s\" error:%s", name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func setFormField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setFormValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	return setFormValue(field, values[0])
+}
+
+func setFormValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(val)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(val)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package exthttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/zhin/go-codex/utils"
+)
+
+// EC1Transport 使用 EC-1 信封加密请求内容，并解密带 X-Content-Encrypt: EC-1 的响应
+type EC1Transport struct {
+	Transport http.RoundTripper
+	Keyring   *utils.EC1Keyring
+}
+
+func (t *EC1Transport) Unwrap() http.RoundTripper {
+	return t.Transport
+}
+
+func (t *EC1Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Keyring == nil {
+		return t.Transport.RoundTrip(req)
+	}
+
+	encReq := req.WithContext(req.Context())
+	encReq.Header = cloneHeader(req.Header)
+	// 没有请求内容时也声明 EC-1，服务端据此加密响应
+	encReq.Header.Set(utils.EC1Header, utils.EC1Name)
+
+	if req.Body != nil && req.Body != http.NoBody {
+		plaintext, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		envelope, err := t.Keyring.Seal(plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("ec1 encrypt error:%s", err.Error())
+		}
+		encReq.Body = ioutil.NopCloser(bytes.NewReader(envelope))
+		encReq.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(envelope)), nil
+		}
+		encReq.ContentLength = int64(len(envelope))
+		encReq.Header.Set("Content-Length", strconv.Itoa(len(envelope)))
+		if contentType := req.Header.Get("Content-Type"); contentType != "" {
+			encReq.Header.Set(utils.EC1ContentTypeHeader, contentType)
+		}
+		encReq.Header.Set("Content-Type", utils.EC1ContentType)
+	}
+
+	resp, err := t.Transport.RoundTrip(encReq)
+	if err != nil || resp.Header.Get(utils.EC1Header) != utils.EC1Name {
+		return resp, err
+	}
+
+	buff, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := t.Keyring.Open(buff)
+	if err != nil {
+		return nil, fmt.Errorf("ec1 decrypt error:%s", err.Error())
+	}
+	resp.Header.Del(utils.EC1Header)
+	resp.Header.Del("Content-Length")
+	if contentType := resp.Header.Get(utils.EC1ContentTypeHeader); contentType != "" {
+		resp.Header.Set("Content-Type", contentType)
+		resp.Header.Del(utils.EC1ContentTypeHeader)
+	}
+	resp.ContentLength = int64(len(envelope.Plaintext))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(envelope.Plaintext))
+	return resp, nil
+}
+
+// UseEC1 开启 EC-1 加密，传 nil 关闭。
+// 同时使用响应缓存时应先调用 UseEC1，使缓存保存解密后的内容，否则缓存的信封会被当作重放拒绝
+func (c *HttpClient) UseEC1(keyring *utils.EC1Keyring) {
+	for rt := c.client.Transport; rt != nil; {
+		if ec1Transport, ok := rt.(*EC1Transport); ok {
+			ec1Transport.Keyring = keyring
+			return
+		}
+		wrapped, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapped.Unwrap()
+	}
+	if keyring != nil {
+		c.client.Transport = &EC1Transport{Transport: c.client.Transport, Keyring: keyring}
+	}
+}
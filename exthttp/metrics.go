@@ -0,0 +1,107 @@
+package exthttp
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhin/go-codex/metrics"
+)
+
+// MetricsOptions Host 为 true 时记录 host 标签，请求的域名很多时会产生大量序列，默认为空
+type MetricsOptions struct {
+	Host bool
+}
+
+// MetricsTransport 记录每个请求的次数及耗时：
+//
+//	http_client_requests_total{client,host,method,status}
+//	http_client_request_duration_seconds{client,host,method}
+//
+// 连接失败等错误的 status 为 error
+type MetricsTransport struct {
+	Transport http.RoundTripper
+
+	// state 为 *metricsState，UseMetrics 重新设置时原子替换
+	state atomic.Value
+}
+
+type metricsState struct {
+	name     string
+	host     bool
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+func newMetricsState(name string, registry *metrics.Registry, option MetricsOptions) *metricsState {
+	if registry == nil {
+		registry = metrics.Default()
+	}
+	return &metricsState{
+		name:     name,
+		host:     option.Host,
+		requests: registry.Counter("http_client_requests_total", "Total HTTP requests sent by exthttp clients.", "client", "host", "method", "status"),
+		duration: registry.Histogram("http_client_request_duration_seconds", "Latency of HTTP requests sent by exthttp clients.", nil, "client", "host", "method"),
+	}
+}
+
+// NewMetricsTransport registry 为空时使用 metrics.Default()，不记录 host
+func NewMetricsTransport(transport http.RoundTripper, name string, registry *metrics.Registry) *MetricsTransport {
+	return NewMetricsTransportEX(transport, name, registry, MetricsOptions{})
+}
+
+// NewMetricsTransportEX 与 NewMetricsTransport 相同，按 option 记录 host
+func NewMetricsTransportEX(transport http.RoundTripper, name string, registry *metrics.Registry, option MetricsOptions) *MetricsTransport {
+	t := &MetricsTransport{Transport: transport}
+	t.state.Store(newMetricsState(name, registry, option))
+	return t
+}
+
+// Name 指标中的 client 标签
+func (t *MetricsTransport) Name() string {
+	return t.state.Load().(*metricsState).name
+}
+
+func (t *MetricsTransport) Unwrap() http.RoundTripper {
+	return t.Transport
+}
+
+func (t *MetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	state := t.state.Load().(*metricsState)
+	start := time.Now()
+	resp, err := t.Transport.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	host := ""
+	if state.host {
+		host = req.URL.Host
+	}
+	state.requests.With(state.name, host, req.Method, status).Inc()
+	state.duration.With(state.name, host, req.Method).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// UseMetrics 将请求指标写入 registry（为空时使用 metrics.Default()），name 为指标中的 client 标签，不记录 host。
+// 命名客户端可在配置中设置 metrics = true 开启
+func (c *HttpClient) UseMetrics(name string, registry *metrics.Registry) {
+	c.UseMetricsEX(name, registry, MetricsOptions{})
+}
+
+// UseMetricsEX 首次开启需要在发送请求前调用，已开启时原子替换 name、registry 及 option，可在使用中调用
+func (c *HttpClient) UseMetricsEX(name string, registry *metrics.Registry, option MetricsOptions) {
+	for rt := c.client.Transport; rt != nil; {
+		if metricsTransport, ok := rt.(*MetricsTransport); ok {
+			metricsTransport.state.Store(newMetricsState(name, registry, option))
+			return
+		}
+		wrapped, ok := rt.(interface{ Unwrap() http.RoundTripper })
+		if !ok {
+			break
+		}
+		rt = wrapped.Unwrap()
+	}
+	c.client.Transport = NewMetricsTransportEX(c.client.Transport, name, registry, option)
+}
@@ -0,0 +1,76 @@
+package exthttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zhin/go-codex/metrics"
+)
+
+func metricsText(t *testing.T, registry *metrics.Registry) string {
+	buff := &bytes.Buffer{}
+	if err := registry.WriteText(buff); err != nil {
+		t.Fatal(err)
+	}
+	return buff.String()
+}
+
+func TestHttpClient_UseMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	registry := metrics.NewRegistry()
+	client := NewHttpClient(ClientOption{})
+	client.UseMetrics("api", registry)
+	if _, err := client.RawRequestEX(http.MethodGet, server.URL, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	text := metricsText(t, registry)
+	if !strings.Contains(text, `http_client_requests_total{client="api",host="",method="GET",status="200"} 1`) {
+		t.Fatalf("host should not be recorded by default:\n%s", text)
+	}
+
+	// 已开启时替换设置，不会重复包装
+	client.UseMetricsEX("api2", registry, MetricsOptions{Host: true})
+	if _, err := client.RawRequestEX(http.MethodGet, server.URL, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	text = metricsText(t, registry)
+	if !strings.Contains(text, `http_client_requests_total{client="api2",host="`+host+`",method="GET",status="200"} 1`) {
+		t.Fatalf("host should be recorded when enabled:\n%s", text)
+	}
+	transport, ok := client.client.Transport.(*MetricsTransport)
+	if !ok || transport.Name() != "api2" {
+		t.Fatalf("unexpected transport %T", client.client.Transport)
+	}
+	if _, ok := transport.Transport.(*MetricsTransport); ok {
+		t.Fatal("metrics transport should not be wrapped twice")
+	}
+}
+
+func TestHttpClient_UseMetricsConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	registry := metrics.NewRegistry()
+	client := NewHttpClient(ClientOption{})
+	client.UseMetrics("api", registry)
+	wait := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			client.RawRequestEX(http.MethodGet, server.URL, nil, nil, nil)
+		}()
+		go func(i int) {
+			defer wait.Done()
+			client.UseMetricsEX("api", registry, MetricsOptions{Host: i%2 == 0})
+		}(i)
+	}
+	wait.Wait()
+}
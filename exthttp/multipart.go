@@ -0,0 +1,155 @@
+package exthttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MultipartFile multipart/form-data 中的文件部分，Path、Reader、Data 三选一
+type MultipartFile struct {
+	Filename    string
+	ContentType string
+	Path        string
+	Reader      io.Reader
+	Data        []byte
+}
+
+func FileFromPath(path string) *MultipartFile {
+	return &MultipartFile{Path: path}
+}
+
+func FileFromReader(filename string, reader io.Reader) *MultipartFile {
+	return &MultipartFile{Filename: filename, Reader: reader}
+}
+
+func FileFromBytes(filename string, data []byte) *MultipartFile {
+	return &MultipartFile{Filename: filename, Data: data}
+}
+
+// PostMultipart 以 multipart/form-data 提交，formParams 中 *MultipartFile 类型的值作为文件上传
+func (c *HttpClient) PostMultipart(url string, formParams map[string]interface{}, options *RequestOptions) ([]byte, error) {
+	if options == nil {
+		options = &RequestOptions{}
+	}
+	options.ContentType = MultipartEncoded
+	return c.Request(http.MethodPost, url, nil, formParams, options)
+}
+
+// requestMultipart 边编码边发送，不在内存中缓存整个请求内容
+func (c *HttpClient) requestMultipart(method string, url string, queryParams map[string]string, formParams map[string]interface{}, options *RequestOptions) (*Response, error) {
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	mw := multipart.NewWriter(writer)
+
+	headers := map[string]string{}
+	for key, value := range options.Headers {
+		headers[key] = value
+	}
+	headers["Content-Type"] = mw.FormDataContentType()
+
+	var body io.Reader = reader
+	if options.Progress != nil {
+		body = &progressReader{reader: reader, total: -1, progress: options.Progress}
+	}
+	req, err := c.newStreamRequest(options.Context, method, url, queryParams, body, -1, &RequestOptions{Headers: headers, Capture: options.Capture, Proxy: options.Proxy})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		writer.CloseWithError(writeMultipart(mw, formParams))
+	}()
+
+	return c.send(req, options, options.AcceptStatus...)
+}
+
+func writeMultipart(mw *multipart.Writer, formParams map[string]interface{}) error {
+
+	keys := make([]string, 0, len(formParams))
+	for key := range formParams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+		switch value := formParams[key].(type) {
+		case *MultipartFile:
+			err = writeMultipartFile(mw, key, value)
+		case MultipartFile:
+			err = writeMultipartFile(mw, key, &value)
+		case []*MultipartFile:
+			for _, file := range value {
+				if err = writeMultipartFile(mw, key, file); err != nil {
+					break
+				}
+			}
+		case []string:
+			for _, item := range value {
+				if err = mw.WriteField(key, item); err != nil {
+					break
+				}
+			}
+		case []interface{}:
+			for _, item := range value {
+				if err = mw.WriteField(key, fmt.Sprintf("%v", item)); err != nil {
+					break
+				}
+			}
+		default:
+			err = mw.WriteField(key, fmt.Sprintf("%v", value))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func writeMultipartFile(mw *multipart.Writer, fieldName string, file *MultipartFile) error {
+
+	var reader io.Reader
+	filename := file.Filename
+	if file.Path != "" {
+		f, err := os.Open(file.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+		if filename == "" {
+			filename = filepath.Base(file.Path)
+		}
+	} else if file.Reader != nil {
+		reader = file.Reader
+	} else {
+		reader = bytes.NewReader(file.Data)
+	}
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(fieldName), quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, reader)
+	return err
+}
@@ -0,0 +1,69 @@
+package exthttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHttpClient_PostMultipart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parts := []string{r.FormValue("name"), strings.Join(r.MultipartForm.Value["tags"], ",")}
+		for _, header := range r.MultipartForm.File["files"] {
+			file, _ := header.Open()
+			data, _ := ioutil.ReadAll(file)
+			file.Close()
+			parts = append(parts, header.Filename+"="+string(data)+";"+header.Header.Get("Content-Type"))
+		}
+		w.Write([]byte(strings.Join(parts, "|")))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(path, []byte("from path"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buff, err := DefaultClient.PostMultipart(server.URL, map[string]interface{}{
+		"name": "codex",
+		"tags": []string{"a", "b"},
+		"files": []*MultipartFile{
+			FileFromPath(path),
+			FileFromReader("b.txt", strings.NewReader("from reader")),
+			{Filename: "c.json", ContentType: "application/json", Data: []byte("{}")},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "codex|a,b|a.txt=from path;application/octet-stream|b.txt=from reader;application/octet-stream|c.json={};application/json"
+	if string(buff) != expected {
+		t.Fatalf("unexpected response %q", buff)
+	}
+}
+
+func TestHttpClient_RawRequestContentType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Header.Get("Content-Type") + "|" + string(data)))
+	}))
+	defer server.Close()
+
+	buff, err := DefaultClient.RawRequest(http.MethodPost, server.URL, nil, []byte("<a/>"), &RequestOptions{ContentType: XMLEncoded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buff) != "application/xml|<a/>" {
+		t.Fatalf("unexpected response %q", buff)
+	}
+
+	if _, err := DefaultClient.RawRequest(http.MethodPost, server.URL, nil, []byte("x"), &RequestOptions{Headers: map[string]string{"Content-Type": "image/x-unknown"}}); err == nil {
+		t.Fatal("unknown content-type should be rejected")
+	}
+}
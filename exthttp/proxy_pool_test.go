@@ -0,0 +1,110 @@
+package exthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestProxy 代理服务器直接返回自己的名称，不转发请求
+func newTestProxy(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + ":" + r.URL.Host))
+	}))
+}
+
+func TestProxyPool_RoundRobin(t *testing.T) {
+	a, b := newTestProxy("a"), newTestProxy("b")
+	defer a.Close()
+	defer b.Close()
+
+	client, err := NewHttpClientEX(ClientOption{ProxyPool: ProxyPoolOptions{Proxies: []string{a.URL, b.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := []string{}
+	for i := 0; i < 4; i++ {
+		buff, err := client.RawRequest(http.MethodGet, "http://target.invalid/", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen = append(seen, string(buff))
+	}
+	if seen[0] == seen[1] || seen[0] != seen[2] || seen[1] != seen[3] {
+		t.Fatalf("proxies should rotate, got %v", seen)
+	}
+
+	// 单次请求指定的代理不参与轮换
+	pinned := newTestProxy("pinned")
+	defer pinned.Close()
+	buff, err := client.RawRequest(http.MethodGet, "http://target.invalid/", nil, nil, &RequestOptions{Proxy: pinned.URL})
+	if err != nil || string(buff) != "pinned:target.invalid" {
+		t.Fatalf("unexpected pinned response %s %v", buff, err)
+	}
+}
+
+func TestProxyPool_Health(t *testing.T) {
+	good, dead := newTestProxy("good"), newTestProxy("dead")
+	defer good.Close()
+	deadURL := dead.URL
+	dead.Close()
+
+	pool, err := NewProxyPool(ProxyPoolOptions{Proxies: []string{deadURL, good.URL}, Strategy: ProxyRoundRobin, FailureThreshold: 2, BanSecond: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewHttpClient(ClientOption{})
+	if err := client.UseProxyPool(pool); err != nil {
+		t.Fatal(err)
+	}
+
+	failures := 0
+	for i := 0; i < 8; i++ {
+		buff, err := client.RawRequest(http.MethodGet, "http://target.invalid/", nil, nil, nil)
+		if err != nil {
+			failures++
+			continue
+		}
+		if string(buff) != "good:target.invalid" {
+			t.Fatalf("unexpected response %s", buff)
+		}
+	}
+	if failures != 2 {
+		t.Fatalf("dead proxy should be banned after 2 failures, got %d failures", failures)
+	}
+
+	stats := pool.Stats()
+	if stats[0].Failures != 2 || !stats[0].BannedUntil.After(time.Now()) || stats[0].Score >= stats[1].Score {
+		t.Fatalf("unexpected dead proxy stat %+v", stats[0])
+	}
+	if stats[1].Successes != 6 || stats[1].Failures != 0 {
+		t.Fatalf("unexpected good proxy stat %+v", stats[1])
+	}
+}
+
+func TestProxyPool_StickyPerHost(t *testing.T) {
+	a, b := newTestProxy("a"), newTestProxy("b")
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := NewProxyPool(ProxyPoolOptions{Proxies: []string{"http://user:pass@" + a.Listener.Addr().String(), b.URL}, Strategy: ProxyStickyPerHost})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewHttpClient(ClientOption{})
+	client.UseProxyPool(pool)
+
+	first, _ := client.RawRequest(http.MethodGet, "http://one.invalid/", nil, nil, nil)
+	for i := 0; i < 5; i++ {
+		buff, _ := client.RawRequest(http.MethodGet, "http://one.invalid/", nil, nil, nil)
+		if string(buff) != string(first) {
+			t.Fatalf("host should stick to one proxy, got %s and %s", first, buff)
+		}
+	}
+	for _, stat := range pool.Stats() {
+		if stat.URL == "http://user:pass@"+a.Listener.Addr().String() {
+			t.Fatal("stats should not expose proxy password")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package exthttp
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// ResponseTimings 请求各阶段耗时，复用连接时 DNS、Connect、TLS 为 0
+type ResponseTimings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// Wait 请求发送完成到收到第一个字节
+	Wait time.Duration
+	// Transfer 收到第一个字节到读取完响应内容
+	Transfer time.Duration
+	Total    time.Duration
+}
+
+// Response 完整的响应信息
+type Response struct {
+	StatusCode int
+	Status     string
+	Proto      string
+	Header     http.Header
+	// URL 跟随重定向后的最终地址
+	URL string
+	// Cookies 包括重定向过程中各响应设置的 cookie
+	Cookies   []*http.Cookie
+	Timings   ResponseTimings
+	BytesRead int64
+	Body      []byte
+}
+
+// FromCache 返回缓存命中情况，未开启缓存时为空
+func (r *Response) FromCache() string {
+	return r.Header.Get(CacheStatusHeader)
+}
+
+func (r *Response) ContentType() string {
+	return r.Header.Get("Content-Type")
+}
+
+// Text 按响应声明的字符集转换为 UTF-8 文本
+func (r *Response) Text() (string, error) {
+	mediaType, params, _ := mime.ParseMediaType(r.ContentType())
+	buff, err := TranscodeToUTF8(r.Body, responseCharset(params, mediaType, r.Body))
+	if err != nil {
+		return "", err
+	}
+	return string(buff), nil
+}
+
+func (r *Response) JSON(v interface{}) error {
+	return decodeJSON(r.Body, v)
+}
+
+func (r *Response) XML(v interface{}) error {
+	return xml.Unmarshal(r.Body, v)
+}
+
+// Decode 根据 Content-Type 选择解码器，与 RequestInto 相同
+func (r *Response) Decode(v interface{}) error {
+	return DecodeResponse(r.ContentType(), r.Body, v)
+}
+
+func (r *Response) String() string {
+	return fmt.Sprintf("%s %s (%d bytes, %s)", r.Status, r.URL, r.BytesRead, r.Timings.Total)
+}
+
+type responseTrace struct {
+	start, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, wroteRequest, firstByte          time.Time
+}
+
+func (t *responseTrace) withTrace(req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { t.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { t.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (t *responseTrace) timings(finished time.Time) ResponseTimings {
+	between := func(start, end time.Time) time.Duration {
+		if start.IsZero() || end.IsZero() || end.Before(start) {
+			return 0
+		}
+		return end.Sub(start)
+	}
+	return ResponseTimings{
+		DNS:      between(t.dnsStart, t.dnsDone),
+		Connect:  between(t.connectStart, t.connectDone),
+		TLS:      between(t.tlsStart, t.tlsDone),
+		Wait:     between(t.wroteRequest, t.firstByte),
+		Transfer: between(t.firstByte, finished),
+		Total:    between(t.start, finished),
+	}
+}
+
+// send 发送请求并读取完整响应，同时把响应头写回 options.ResponseHeaders
+func (c *HttpClient) send(req *http.Request, options *RequestOptions, acceptStatus ...int) (*Response, error) {
+
+	trace := &responseTrace{start: time.Now()}
+	req = trace.withTrace(req)
+
+	resp, err := c.do(req, acceptStatus...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBuff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("response error:%s", err.Error())
+	}
+	if options != nil {
+		options.ResponseHeaders = resp.Header
+	}
+
+	response := &Response{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Proto:      resp.Proto,
+		Header:     resp.Header,
+		URL:        resp.Request.URL.String(),
+		Timings:    trace.timings(time.Now()),
+		BytesRead:  int64(len(responseBuff)),
+		Body:       responseBuff,
+	}
+	// 重定向时每一跳的 Request.Response 指向上一个响应
+	for r := resp; r != nil; {
+		response.Cookies = append(r.Cookies(), response.Cookies...)
+		if r.Request == nil {
+			break
+		}
+		r = r.Request.Response
+	}
+	return response, nil
+}
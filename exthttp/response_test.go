@@ -0,0 +1,62 @@
+package exthttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpClient_RequestEX(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.SetCookie(w, &http.Cookie{Name: "first", Value: "1"})
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "second", Value: "2"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"codex"}`))
+	}))
+	defer server.Close()
+
+	options := &RequestOptions{}
+	resp, err := NewHttpClient(ClientOption{}).RequestEX(http.MethodGet, server.URL+"/redirect", nil, nil, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.URL != server.URL+"/final" || resp.StatusCode != http.StatusOK || resp.BytesRead != 16 {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if len(resp.Cookies) != 2 || resp.Cookies[0].Name != "first" || resp.Cookies[1].Name != "second" {
+		t.Fatalf("unexpected cookies %v", resp.Cookies)
+	}
+	if options.ResponseHeaders.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response headers %v", options.ResponseHeaders)
+	}
+	if resp.Timings.Total <= 0 || resp.Timings.Total < resp.Timings.Wait {
+		t.Fatalf("unexpected timings %+v", resp.Timings)
+	}
+	result := struct{ Name string }{}
+	if err := resp.Decode(&result); err != nil || result.Name != "codex" {
+		t.Fatalf("unexpected decode result %v %v", result, err)
+	}
+}
+
+func TestHttpClient_ResponseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad"))
+	}))
+	defer server.Close()
+
+	_, err := NewHttpClient(ClientOption{}).RequestEX(http.MethodGet, server.URL+"/path?a=1", nil, nil, nil)
+	responseErr, ok := err.(*HttpResponseError)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if responseErr.RequestURL != server.URL+"/path?a=1" || responseErr.Status != http.StatusBadRequest ||
+		responseErr.ResponseHeader.Get("X-Request-Id") != "abc" || string(responseErr.ResponseData) != "bad" {
+		t.Fatalf("unexpected response error %+v", responseErr)
+	}
+}
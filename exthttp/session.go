@@ -0,0 +1,471 @@
+package exthttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	cookiejar "github.com/juju/persistent-cookiejar"
+
+	"github.com/zhin/go-codex/rds"
+)
+
+// SessionStore 保存会话的 cookie 数据（JSON 格式）
+type SessionStore interface {
+	Load(name string) ([]byte, error)
+	Save(name string, data []byte) error
+	Delete(name string) error
+}
+
+// FileSessionStore 每个会话保存为 Dir 下的一个 JSON 文件
+type FileSessionStore struct {
+	Dir string
+}
+
+func (s *FileSessionStore) filename(name string) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%s.cookies.json", url.PathEscape(name)))
+}
+
+func (s *FileSessionStore) Load(name string) ([]byte, error) {
+	buff, err := ioutil.ReadFile(s.filename(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buff, err
+}
+
+func (s *FileSessionStore) Save(name string, data []byte) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.filename(name), data, 0600)
+}
+
+func (s *FileSessionStore) Delete(name string) error {
+	err := os.Remove(s.filename(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RedisSessionStore 保存到 redis，多个进程可共享同一登录会话，Client 为空时使用 rds.Default
+type RedisSessionStore struct {
+	Client     *redis.Client
+	Prefix     string
+	Expiration time.Duration
+}
+
+func (s *RedisSessionStore) client() (*redis.Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis session store needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (s *RedisSessionStore) key(name string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "codex:http:session:"
+	}
+	return prefix + name
+}
+
+func (s *RedisSessionStore) Load(name string) ([]byte, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	buff, err := client.Get(s.key(name)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return buff, err
+}
+
+func (s *RedisSessionStore) Save(name string, data []byte) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Set(s.key(name), data, s.Expiration).Err()
+}
+
+func (s *RedisSessionStore) Delete(name string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Del(s.key(name)).Err()
+}
+
+// CookieSession 一个独立的 cookie 会话
+type CookieSession struct {
+	name  string
+	jar   *cookiejar.Jar
+	store SessionStore
+}
+
+func newCookieSession(name string, store SessionStore) (*CookieSession, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{NoPersist: true})
+	if err != nil {
+		return nil, err
+	}
+	return &CookieSession{name: name, jar: jar, store: store}, nil
+}
+
+func (s *CookieSession) Name() string {
+	return s.name
+}
+
+func (s *CookieSession) Jar() http.CookieJar {
+	return s.jar
+}
+
+// Cookies 返回会话中所有未过期的 cookie
+func (s *CookieSession) Cookies() []*http.Cookie {
+	return s.jar.AllCookies()
+}
+
+func (s *CookieSession) Clear() {
+	s.jar.RemoveAll()
+}
+
+// SetCookies 导入 cookie，Domain 为空的 cookie 会被忽略
+func (s *CookieSession) SetCookies(cookies []*http.Cookie) {
+	for _, cookie := range cookies {
+		s.setCookie(strings.TrimPrefix(cookie.Domain, "."), cookie)
+	}
+}
+
+// setCookie 以 host 的身份写入 cookie，cookie.Domain 为空时为仅限该 host 的 cookie
+func (s *CookieSession) setCookie(host string, cookie *http.Cookie) {
+	if host == "" {
+		return
+	}
+	scheme := "http"
+	if cookie.Secure {
+		scheme = "https"
+	}
+	path := cookie.Path
+	if path == "" {
+		path = "/"
+	}
+	s.jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: path}, []*http.Cookie{cookie})
+}
+
+// hostOnly AllCookies 不返回 HostOnly，通过子域名是否能取到该 cookie 判断
+func (s *CookieSession) hostOnly(cookie *http.Cookie) bool {
+	for _, item := range s.jar.Cookies(&url.URL{Scheme: "https", Host: "codex." + cookie.Domain, Path: cookie.Path}) {
+		if item.Name == cookie.Name && item.Value == cookie.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// cookieExpires 会话 cookie 在 jar 中的过期时间为 9999 年，导出时还原为零值
+func cookieExpires(cookie *http.Cookie) time.Time {
+	if cookie.Expires.Year() >= 9999 {
+		return time.Time{}
+	}
+	return cookie.Expires
+}
+
+type cookieRecord struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Domain   string    `json:"domain"`
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"http_only"`
+	HostOnly bool      `json:"host_only,omitempty"`
+}
+
+func (s *CookieSession) ExportJSON(w io.Writer) error {
+	records := []cookieRecord{}
+	for _, cookie := range s.Cookies() {
+		records = append(records, cookieRecord{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Domain:   cookie.Domain,
+			Path:     cookie.Path,
+			Expires:  cookieExpires(cookie),
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+			HostOnly: s.hostOnly(cookie),
+		})
+	}
+	return json.NewEncoder(w).Encode(records)
+}
+
+func (s *CookieSession) ImportJSON(r io.Reader) error {
+	records := []cookieRecord{}
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return fmt.Errorf("cookie json error:%s", err.Error())
+	}
+	for _, record := range records {
+		cookie := &http.Cookie{
+			Name:     record.Name,
+			Value:    record.Value,
+			Domain:   record.Domain,
+			Path:     record.Path,
+			Expires:  record.Expires,
+			Secure:   record.Secure,
+			HttpOnly: record.HttpOnly,
+		}
+		if record.HostOnly {
+			cookie.Domain = ""
+		}
+		s.setCookie(strings.TrimPrefix(record.Domain, "."), cookie)
+	}
+	return nil
+}
+
+const netscapeHttpOnlyPrefix = "#HttpOnly_"
+
+// ExportNetscape 导出为 curl/wget 使用的 cookies.txt 格式
+func (s *CookieSession) ExportNetscape(w io.Writer) error {
+	cookies := s.Cookies()
+	sort.Slice(cookies, func(i, j int) bool {
+		if cookies[i].Domain != cookies[j].Domain {
+			return cookies[i].Domain < cookies[j].Domain
+		}
+		return cookies[i].Name < cookies[j].Name
+	})
+
+	writer := bufio.NewWriter(w)
+	writer.WriteString("# Netscape HTTP Cookie File\n")
+	for _, cookie := range cookies {
+		domain, includeSubdomains := cookie.Domain, !s.hostOnly(cookie)
+		if includeSubdomains {
+			domain = "." + domain
+		}
+		if cookie.HttpOnly {
+			domain = netscapeHttpOnlyPrefix + domain
+		}
+		var expires int64
+		if t := cookieExpires(cookie); !t.IsZero() {
+			expires = t.Unix()
+		}
+		path := cookie.Path
+		if path == "" {
+			path = "/"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(includeSubdomains), path, netscapeBool(cookie.Secure),
+			expires, cookie.Name, cookie.Value)
+	}
+	return writer.Flush()
+}
+
+// ImportNetscape 导入 cookies.txt 格式
+func (s *CookieSession) ImportNetscape(r io.Reader) error {
+	hosts, cookies := []string{}, []*http.Cookie{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(text, netscapeHttpOnlyPrefix) {
+			text = strings.TrimPrefix(text, netscapeHttpOnlyPrefix)
+			httpOnly = true
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("cookies.txt line %d needs 7 fields", line)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookies.txt line %d expires error:%s", line, err.Error())
+		}
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		// 第二列为 FALSE 时仅限该 host
+		if !strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = ""
+		}
+		hosts, cookies = append(hosts, strings.TrimPrefix(fields[0], ".")), append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for i, cookie := range cookies {
+		s.setCookie(hosts[i], cookie)
+	}
+	return nil
+}
+
+func netscapeBool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// Save 保存到 SessionStore
+func (s *CookieSession) Save() error {
+	if s.store == nil {
+		return fmt.Errorf("cookie session \"%s\" has no store", s.name)
+	}
+	buff := &strings.Builder{}
+	if err := s.ExportJSON(buff); err != nil {
+		return err
+	}
+	return s.store.Save(s.name, []byte(buff.String()))
+}
+
+// Load 从 SessionStore 加载，会话中已有的 cookie 保留
+func (s *CookieSession) Load() error {
+	if s.store == nil {
+		return fmt.Errorf("cookie session \"%s\" has no store", s.name)
+	}
+	buff, err := s.store.Load(s.name)
+	if err != nil {
+		return fmt.Errorf("cookie session \"%s\" load error:%s", s.name, err.Error())
+	}
+	if buff == nil {
+		return nil
+	}
+	return s.ImportJSON(strings.NewReader(string(buff)))
+}
+
+// SessionManager 管理一个客户端下的多个命名会话
+type SessionManager struct {
+	store SessionStore
+
+	lock     sync.Mutex
+	sessions map[string]*CookieSession
+}
+
+// NewSessionManager store 为 nil 时会话只保存在内存中
+func NewSessionManager(store SessionStore) *SessionManager {
+	return &SessionManager{store: store, sessions: map[string]*CookieSession{}}
+}
+
+// Get 获取会话，首次获取时从 store 加载
+func (m *SessionManager) Get(name string) (*CookieSession, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if session, found := m.sessions[name]; found {
+		return session, nil
+	}
+	session, err := newCookieSession(name, m.store)
+	if err != nil {
+		return nil, err
+	}
+	if m.store != nil {
+		if err := session.Load(); err != nil {
+			return nil, err
+		}
+	}
+	m.sessions[name] = session
+	return session, nil
+}
+
+func (m *SessionManager) Names() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0, len(m.sessions))
+	for name := range m.sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remove 移除会话并删除 store 中的数据
+func (m *SessionManager) Remove(name string) error {
+	m.lock.Lock()
+	delete(m.sessions, name)
+	m.lock.Unlock()
+
+	if m.store != nil {
+		return m.store.Delete(name)
+	}
+	return nil
+}
+
+// SaveAll 保存所有会话
+func (m *SessionManager) SaveAll() error {
+	m.lock.Lock()
+	sessions := make([]*CookieSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.lock.Unlock()
+
+	for _, session := range sessions {
+		if err := session.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseSessions 设置客户端的会话管理器，传 nil 时使用不保存的管理器
+func (c *HttpClient) UseSessions(manager *SessionManager) {
+	if manager == nil {
+		manager = NewSessionManager(nil)
+	}
+	c.sessions = manager
+}
+
+// Sessions 创建客户端时已初始化，UseSessions 需要在发送请求之前调用
+func (c *HttpClient) Sessions() *SessionManager {
+	return c.sessions
+}
+
+// Session 返回使用指定会话 cookie 的客户端，与原客户端共享连接及其它设置，
+// 响应缓存按会话名隔离
+func (c *HttpClient) Session(name string) (*HttpClient, error) {
+	session, err := c.Sessions().Get(name)
+	if err != nil {
+		return nil, err
+	}
+	client := *c.client
+	client.Jar = session.jar
+
+	sessionClient := *c
+	sessionClient.client = &client
+	sessionClient.cacheScope = name
+	return &sessionClient, nil
+}
+
+// DefaultSession 返回 UseCookieJar 开启的 cookie 会话，未开启时返回 nil
+func (c *HttpClient) DefaultSession() *CookieSession {
+	jar, ok := c.client.Jar.(*cookiejar.Jar)
+	if !ok {
+		return nil
+	}
+	return &CookieSession{jar: jar}
+}
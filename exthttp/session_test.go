@@ -0,0 +1,313 @@
+package exthttp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestHttpClient_Session(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "user", Value: r.URL.Query().Get("user"), Path: "/"})
+			return
+		}
+		user := ""
+		if cookie, err := r.Cookie("user"); err == nil {
+			user = cookie.Value
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(user))
+	}))
+	defer server.Close()
+
+	client := NewHttpClient(ClientOption{Cache: CacheOptions{Storage: "memory"}})
+
+	// 并发获取会话管理器及会话
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Session("alice"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, user := range []string{"alice", "bob"} {
+		session, err := client.Session(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.RawRequest(http.MethodGet, server.URL+"/login?user="+user, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		buff, err := session.RawRequest(http.MethodGet, server.URL+"/me", nil, nil, nil)
+		if err != nil || string(buff) != user {
+			t.Fatalf("session %s got %s %v", user, buff, err)
+		}
+	}
+	if names := client.Sessions().Names(); len(names) != 2 {
+		t.Fatalf("unexpected sessions %v", names)
+	}
+}
+
+const netscapeCookies = `# Netscape HTTP Cookie File
+# 注释及空行会被忽略
+
+.example.com	TRUE	/	FALSE	0	lang	zh
+#HttpOnly_example.com	FALSE	/account	TRUE	4102444800	sid	abc123
+`
+
+func TestCookieSession_Netscape(t *testing.T) {
+	session, _ := newCookieSession("netscape", nil)
+	if err := session.ImportNetscape(strings.NewReader(netscapeCookies)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range session.Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	sid := cookies["sid"]
+	if len(cookies) != 2 || cookies["lang"].Value != "zh" || sid == nil || !sid.HttpOnly || !sid.Secure || sid.Path != "/account" || sid.Expires.Unix() != 4102444800 {
+		t.Fatalf("unexpected cookies %v", session.Cookies())
+	}
+
+	buff := &bytes.Buffer{}
+	if err := session.ExportNetscape(buff); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tlang\tzh\n" +
+		"#HttpOnly_example.com\tFALSE\t/account\tTRUE\t4102444800\tsid\tabc123\n"
+	if buff.String() != expected {
+		t.Fatalf("unexpected export:\n%s", buff.String())
+	}
+
+	// 导出的内容可以再次导入，且请求时按域名及路径发送
+	imported, _ := newCookieSession("imported", nil)
+	if err := imported.ImportNetscape(bytes.NewReader(buff.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	for rawURL, expected := range map[string]string{
+		"https://example.com/account/x": "sid=abc123; lang=zh",
+		"https://www.example.com/":      "lang=zh",
+		"http://example.com/account":    "lang=zh",
+	} {
+		u, _ := url.Parse(rawURL)
+		values := []string{}
+		for _, cookie := range imported.Jar().Cookies(u) {
+			values = append(values, cookie.Name+"="+cookie.Value)
+		}
+		if got := strings.Join(values, "; "); got != expected {
+			t.Errorf("%s: got %s, want %s", rawURL, got, expected)
+		}
+	}
+
+	if err := session.ImportNetscape(strings.NewReader("example.com\tFALSE\t/\n")); err == nil {
+		t.Fatal("malformed line should return error")
+	}
+}
+
+func TestCookieSession_JSON(t *testing.T) {
+	session, _ := newCookieSession("json", nil)
+	expires := time.Unix(4102444800, 0)
+	session.SetCookies([]*http.Cookie{
+		{Name: "sid", Value: "abc", Domain: "example.com", Path: "/", Expires: expires, Secure: true, HttpOnly: true},
+		{Name: "lang", Value: "zh", Domain: ".example.com", Path: "/"},
+		{Name: "ignored", Value: "x"},
+	})
+	buff := &bytes.Buffer{}
+	if err := session.ExportJSON(buff); err != nil {
+		t.Fatal(err)
+	}
+
+	imported, _ := newCookieSession("imported", nil)
+	if err := imported.ImportJSON(bytes.NewReader(buff.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cookieStrings(imported.Cookies()), cookieStrings(session.Cookies())) || len(imported.Cookies()) != 2 {
+		t.Fatalf("json round trip got %v, want %v", imported.Cookies(), session.Cookies())
+	}
+	if !strings.Contains(buff.String(), `"name":"lang","value":"zh","domain":"example.com","path":"/","expires":"0001-01-01T00:00:00Z"`) {
+		t.Fatalf("session cookie should be exported without expiry, got %s", buff.String())
+	}
+	if err := imported.ImportJSON(strings.NewReader("{")); err == nil {
+		t.Fatal("invalid json should return error")
+	}
+}
+
+// cookieStrings 时间带时区及单调时钟，按字符串比较
+func cookieStrings(cookies []*http.Cookie) []string {
+	values := []string{}
+	for _, cookie := range cookies {
+		values = append(values, cookie.String())
+	}
+	return values
+}
+
+// testSessionStore 保存后用新的 SessionManager 加载，删除后不再加载
+func testSessionStore(t *testing.T, store SessionStore) {
+	manager := NewSessionManager(store)
+	session, err := manager.Get("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.SetCookies([]*http.Cookie{{Name: "sid", Value: "abc", Domain: "example.com", Path: "/", Expires: time.Now().Add(time.Hour).Truncate(time.Second)}})
+	if err := manager.SaveAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewSessionManager(store).Get("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cookieStrings(loaded.Cookies()), cookieStrings(session.Cookies())) {
+		t.Fatalf("loaded %v, want %v", loaded.Cookies(), session.Cookies())
+	}
+	if err := manager.Remove("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if buff, err := store.Load("alice@example.com"); err != nil || buff != nil {
+		t.Fatalf("removed session should not be loaded, got %s %v", buff, err)
+	}
+	if err := store.Delete("alice@example.com"); err != nil {
+		t.Fatalf("deleting a missing session should succeed, got %v", err)
+	}
+}
+
+func TestFileSessionStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	testSessionStore(t, &FileSessionStore{Dir: dir})
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	client := newTestRedis(t)
+	store := &RedisSessionStore{Client: client, Expiration: time.Hour}
+	testSessionStore(t, store)
+
+	store.Save("bob", []byte("[]"))
+	if ttl := client.TTL("codex:http:session:bob").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("unexpected ttl %s", ttl)
+	}
+}
+
+// newTestRedis 启动只支持 PING/GET/SET/DEL/TTL 的 redis 服务端
+func newTestRedis(t *testing.T) *redis.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type item struct {
+		value   string
+		expires time.Time
+	}
+	lock, data := sync.Mutex{}, map[string]item{}
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			args, err := readRedisCommand(reader)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			reply := "-ERR unknown command\r\n"
+			switch strings.ToLower(args[0]) {
+			case "ping":
+				reply = "+PONG\r\n"
+			case "get":
+				if v, ok := data[args[1]]; ok {
+					reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v.value), v.value)
+				} else {
+					reply = "$-1\r\n"
+				}
+			case "set":
+				v := item{value: args[2]}
+				if len(args) == 5 {
+					n, _ := strconv.Atoi(args[4])
+					unit := time.Second
+					if strings.ToLower(args[3]) == "px" {
+						unit = time.Millisecond
+					}
+					v.expires = time.Now().Add(time.Duration(n) * unit)
+				}
+				data[args[1]] = v
+				reply = "+OK\r\n"
+			case "del":
+				_, ok := data[args[1]]
+				delete(data, args[1])
+				reply = fmt.Sprintf(":%d\r\n", map[bool]int{true: 1}[ok])
+			case "ttl":
+				v, ok := data[args[1]]
+				switch {
+				case !ok:
+					reply = ":-2\r\n"
+				case v.expires.IsZero():
+					reply = ":-1\r\n"
+				default:
+					reply = fmt.Sprintf(":%d\r\n", int(time.Until(v.expires).Round(time.Second)/time.Second))
+				}
+			}
+			lock.Unlock()
+			if _, err := io.WriteString(conn, reply); err != nil {
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String()})
+	t.Cleanup(func() {
+		client.Close()
+		listener.Close()
+	})
+	return client
+}
+
+func readRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buff := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buff); err != nil {
+			return nil, err
+		}
+		args[i] = string(buff[:size])
+	}
+	return args, nil
+}
@@ -0,0 +1,351 @@
+package exthttp
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProgressFunc 传输进度回调，total 未知时为 -1
+type ProgressFunc func(transferred int64, total int64)
+
+type progressReader struct {
+	reader      io.Reader
+	transferred int64
+	total       int64
+	progress    ProgressFunc
+	err         error
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.transferred += int64(n)
+		if r.progress != nil {
+			r.progress(r.transferred, r.total)
+		}
+	}
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+type progressReadCloser struct {
+	*progressReader
+	closer io.Closer
+}
+
+func (r *progressReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+// Stream 发送请求并返回响应内容，调用方负责读取并关闭
+func (c *HttpClient) Stream(ctx context.Context, method string, url string, queryParams map[string]string, body io.Reader, options *RequestOptions) (io.ReadCloser, error) {
+
+	req, err := c.newStreamRequest(ctx, method, url, queryParams, body, -1, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if options != nil {
+		options.ResponseHeaders = resp.Header
+		if options.Progress != nil {
+			return &progressReadCloser{
+				progressReader: &progressReader{reader: resp.Body, total: resp.ContentLength, progress: options.Progress},
+				closer:         resp.Body,
+			}, nil
+		}
+	}
+	return resp.Body, nil
+}
+
+// Upload 以流的方式发送 body，size 未知时传 -1（使用 chunked 编码）
+func (c *HttpClient) Upload(ctx context.Context, method string, url string, body io.Reader, size int64, options *RequestOptions) ([]byte, error) {
+
+	if options != nil && options.Progress != nil {
+		body = &progressReader{reader: body, total: size, progress: options.Progress}
+	}
+	req, err := c.newStreamRequest(ctx, method, url, nil, body, size, options)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(req, options, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *HttpClient) newStreamRequest(ctx context.Context, method string, url string, queryParams map[string]string, body io.Reader, size int64, options *RequestOptions) (*http.Request, error) {
+
+	url, err := buildURL(url, queryParams)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("request content error:%s", err.Error())
+	}
+	if size >= 0 && body != nil {
+		req.ContentLength = size
+	}
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	if options != nil {
+		for key, value := range options.Headers {
+			req.Header.Add(key, value)
+		}
+		req = withCapture(req, options.Capture)
+		req = withCacheRefresh(req, options.CacheRefresh)
+		if req, err = withProxy(req, options.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+type DownloadOptions struct {
+	Headers map[string]string
+	// Offset 写入端已有的字节数，从该位置开始续传
+	Offset int64
+	// Resume DownloadFile 时从已存在文件的末尾续传，否则覆盖
+	Resume bool
+	// Checksum 下载完成后校验，格式 "sha256:<hex>"，支持 md5/sha1/sha256/sha512
+	Checksum string
+	// MaxRetries 连接中断时使用 Range 续传的次数，0 为默认 3 次，负数不重试
+	MaxRetries int
+	Progress   ProgressFunc
+}
+
+const defaultDownloadRetries = 3
+
+const (
+	downloadRetryDelay    = 200 * time.Millisecond
+	downloadRetryMaxDelay = 5 * time.Second
+)
+
+// Download 下载 url 内容写入 w，中断时通过 Range 请求续传，返回本次写入的字节数
+func (c *HttpClient) Download(ctx context.Context, url string, w io.Writer, option *DownloadOptions) (int64, error) {
+
+	if option == nil {
+		option = &DownloadOptions{}
+	}
+	hasher, expected, err := parseChecksum(option.Checksum)
+	if err != nil {
+		return 0, err
+	}
+	if hasher != nil && option.Offset > 0 {
+		return 0, fmt.Errorf("download checksum needs the whole content, use DownloadFile to resume with checksum")
+	}
+	return c.download(ctx, url, w, option.Offset, hasher, expected, option)
+}
+
+// DownloadFile 下载到文件，Resume 时会先将已有内容计入校验值
+func (c *HttpClient) DownloadFile(ctx context.Context, url string, filename string, option *DownloadOptions) (int64, error) {
+
+	if option == nil {
+		option = &DownloadOptions{}
+	}
+	hasher, expected, err := parseChecksum(option.Checksum)
+	if err != nil {
+		return 0, err
+	}
+
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if option.Resume {
+		flag = os.O_CREATE | os.O_RDWR | os.O_APPEND
+	}
+	file, err := os.OpenFile(filename, flag, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var offset int64
+	if option.Resume {
+		if hasher != nil {
+			offset, err = io.Copy(hasher, file)
+		} else {
+			offset, err = file.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.download(ctx, url, file, offset, hasher, expected, option)
+}
+
+func (c *HttpClient) download(ctx context.Context, url string, w io.Writer, offset int64, hasher hash.Hash, expected string, option *DownloadOptions) (int64, error) {
+
+	retries := option.MaxRetries
+	if retries == 0 {
+		retries = defaultDownloadRetries
+	}
+	if hasher != nil {
+		w = io.MultiWriter(w, hasher)
+	}
+
+	var written int64
+	for attempt := 0; ; attempt++ {
+		n, err := c.downloadOnce(ctx, url, w, offset+written, option)
+		written += n
+		if err == nil {
+			break
+		}
+		if _, ok := err.(*downloadReadError); !ok || attempt >= retries || (ctx != nil && ctx.Err() != nil) {
+			return written, err
+		}
+		if err := downloadBackoff(ctx, attempt); err != nil {
+			return written, err
+		}
+	}
+
+	if hasher != nil {
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != expected {
+			return written, fmt.Errorf("download checksum mismatch url:%s expected:%s actual:%s", url, expected, actual)
+		}
+	}
+	return written, nil
+}
+
+// downloadBackoff 第 attempt 次重试前等待 200ms、400ms、800ms……，最长 5s，ctx 结束时返回其错误
+func downloadBackoff(ctx context.Context, attempt int) error {
+	delay := downloadRetryDelay << uint(attempt)
+	if attempt >= 5 || delay > downloadRetryMaxDelay {
+		delay = downloadRetryMaxDelay
+	}
+	if ctx == nil {
+		time.Sleep(delay)
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type downloadReadError struct {
+	err error
+}
+
+func (e *downloadReadError) Error() string {
+	return fmt.Sprintf("download read error:%s", e.err.Error())
+}
+
+func (c *HttpClient) downloadOnce(ctx context.Context, url string, w io.Writer, offset int64, option *DownloadOptions) (int64, error) {
+
+	req, err := c.newStreamRequest(ctx, http.MethodGet, url, nil, nil, -1, &RequestOptions{Headers: option.Headers})
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(req, http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+	if err != nil {
+		if ctx == nil || ctx.Err() == nil {
+			if _, ok := err.(*HttpResponseError); !ok {
+				err = &downloadReadError{err}
+			}
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// 已下载完整
+		if offset > 0 {
+			return 0, nil
+		}
+		return 0, &HttpResponseError{RequestURL: req.URL.String(), Status: resp.StatusCode, ResponseHeader: resp.Header}
+	}
+
+	var body io.Reader = resp.Body
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusOK {
+		// 服务端不支持 Range，跳过已有内容
+		if offset > 0 {
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+				return 0, &downloadReadError{err}
+			}
+			if total >= 0 {
+				total -= offset
+			}
+		}
+	}
+	if total >= 0 {
+		total += offset
+	}
+	if size := contentRangeSize(resp.Header.Get("Content-Range")); size >= 0 {
+		total = size
+	}
+
+	reader := &progressReader{reader: body, transferred: offset, total: total, progress: option.Progress}
+	n, err := io.Copy(w, reader)
+	if err != nil {
+		if reader.err != nil && (ctx == nil || ctx.Err() == nil) {
+			return n, &downloadReadError{reader.err}
+		}
+		return n, err
+	}
+	if total >= 0 && offset+n < total {
+		return n, &downloadReadError{io.ErrUnexpectedEOF}
+	}
+	return n, nil
+}
+
+// contentRangeSize 解析 "bytes 100-199/200" 中的总长度，未知时返回 -1
+func contentRangeSize(value string) int64 {
+	index := strings.LastIndex(value, "/")
+	if index < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(value[index+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func parseChecksum(checksum string) (hash.Hash, string, error) {
+	if checksum == "" {
+		return nil, "", nil
+	}
+	parts := strings.SplitN(checksum, ":", 2)
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("unknow checksum format \"%s\"", checksum)
+	}
+	expected := strings.ToLower(parts[1])
+	switch strings.ToLower(parts[0]) {
+	case "md5":
+		return md5.New(), expected, nil
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	case "sha512":
+		return sha512.New(), expected, nil
+	}
+	return nil, "", fmt.Errorf("unknow checksum algorithm \"%s\"", parts[0])
+}
@@ -0,0 +1,61 @@
+package exthttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestHttpClient_DownloadResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := sha256.Sum256(data)
+	checksum := "sha256:" + hex.EncodeToString(sum[:])
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// 第一次只返回部分内容后断开连接
+			w.Header().Set("Content-Length", "100000")
+			w.Write(data[:5000])
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "data", time.Now(), bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	var buff bytes.Buffer
+	var transferred int64
+	n, err := DefaultClient.Download(context.Background(), server.URL, &buff, &DownloadOptions{
+		Checksum: checksum,
+		Progress: func(current int64, total int64) { transferred = current },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(buff.Bytes(), data) || transferred != n {
+		t.Errorf("download content error n:%d transferred:%d", n, transferred)
+	}
+
+	filename := path.Join(os.TempDir(), "gocodex_download_test")
+	defer os.Remove(filename)
+	ioutil.WriteFile(filename, data[:300], 0644)
+	n, err = DefaultClient.DownloadFile(context.Background(), server.URL, filename, &DownloadOptions{Resume: true, Checksum: checksum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(filename)
+	if n != int64(len(data)-300) || !bytes.Equal(content, data) {
+		t.Errorf("resume download content error n:%d", n)
+	}
+}
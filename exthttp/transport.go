@@ -0,0 +1,256 @@
+package exthttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhin/go-codex/configs"
+)
+
+// ClientOption 客户端及连接配置，可从配置文件的 [http] 段加载：
+//
+//	[http]
+//	timeout_second = 30
+//	max_idle_conns_per_host = 20
+//
+//	[http.clients.internal]
+//	cert_file = "/etc/certs/client.pem"
+//	key_file = "/etc/certs/client.key"
+//	ca_file = "/etc/certs/ca.pem"
+//	min_tls_version = "1.2"
+type ClientOption struct {
+	TimeoutSecond int    `mapstructure:"timeout_second"`
+	Proxy         string `mapstructure:"proxy"`
+
+	// CertFile/KeyFile 客户端证书（mTLS）
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// CAFile 自定义 CA 证书，设置后只信任该文件中的证书
+	CAFile             string `mapstructure:"ca_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	// MinTLSVersion 1.0/1.1/1.2/1.3
+	MinTLSVersion string `mapstructure:"min_tls_version"`
+	// ServerName 覆盖 SNI 及证书校验使用的主机名
+	ServerName string `mapstructure:"server_name"`
+
+	MaxIdleConns                int  `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost         int  `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost             int  `mapstructure:"max_conns_per_host"`
+	IdleConnTimeoutSecond       int  `mapstructure:"idle_conn_timeout_second"`
+	DialTimeoutSecond           int  `mapstructure:"dial_timeout_second"`
+	KeepAliveSecond             int  `mapstructure:"keep_alive_second"`
+	TLSHandshakeTimeoutSecond   int  `mapstructure:"tls_handshake_timeout_second"`
+	ResponseHeaderTimeoutSecond int  `mapstructure:"response_header_timeout_second"`
+	DisableKeepAlives           bool `mapstructure:"disable_keep_alives"`
+	DisableCompression          bool `mapstructure:"disable_compression"`
+	// DisableHTTP2 只使用 HTTP/1.1，默认通过 ALPN 协商 HTTP/2
+	DisableHTTP2 bool `mapstructure:"disable_http2"`
+
+	// DNSOverrides 主机名（或 host:port）到 IP（或 ip:port）的映射，优先于 DNS 解析
+	DNSOverrides map[string]string `mapstructure:"dns_overrides"`
+	// UnixSocket 所有连接都通过该 unix socket 建立
+	UnixSocket string `mapstructure:"unix_socket"`
+
+	// ProxyPool 配置了代理列表或文件时启用代理池，优先于 Proxy
+	ProxyPool ProxyPoolOptions `mapstructure:"proxy_pool"`
+	// Cache 配置了 storage 时开启响应缓存
+	Cache CacheOptions `mapstructure:"cache"`
+	// Auth 配置了 type 时为每个请求设置认证信息
+	Auth AuthOptions `mapstructure:"auth"`
+	// EC1 使用 utils.DefaultEC1Keyring 加密请求内容并解密响应
+	EC1 bool `mapstructure:"ec1"`
+	// Metrics 通过 Choice 创建的客户端将请求指标写入 metrics.Default()
+	Metrics bool `mapstructure:"metrics"`
+	// MetricsHost 指标中记录 host 标签
+	MetricsHost bool `mapstructure:"metrics_host"`
+}
+
+const httpSettingKey = "http"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func newTransport(option ClientOption) (*http.Transport, error) {
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if option.DialTimeoutSecond > 0 {
+		dialer.Timeout = time.Duration(option.DialTimeoutSecond) * time.Second
+	}
+	if option.KeepAliveSecond != 0 {
+		dialer.KeepAlive = time.Duration(option.KeepAliveSecond) * time.Second
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialContext(dialer, option),
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConnsPerHost:   option.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       option.MaxConnsPerHost,
+		DisableKeepAlives:     option.DisableKeepAlives,
+		DisableCompression:    option.DisableCompression,
+		// 设置了 DialContext/TLSClientConfig 后 net/http 默认不再尝试 HTTP/2
+		ForceAttemptHTTP2: !option.DisableHTTP2,
+	}
+	if option.MaxIdleConns > 0 {
+		transport.MaxIdleConns = option.MaxIdleConns
+	}
+	if option.IdleConnTimeoutSecond > 0 {
+		transport.IdleConnTimeout = time.Duration(option.IdleConnTimeoutSecond) * time.Second
+	}
+	if option.TLSHandshakeTimeoutSecond > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(option.TLSHandshakeTimeoutSecond) * time.Second
+	}
+	if option.ResponseHeaderTimeoutSecond > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(option.ResponseHeaderTimeoutSecond) * time.Second
+	}
+
+	if option.Proxy != "" {
+		proxyURL, err := url.Parse(option.Proxy)
+		if err != nil {
+			return transport, fmt.Errorf("proxy \"%s\" error:%s", option.Proxy, err.Error())
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	transport.Proxy = proxyFromContext(transport.Proxy)
+
+	tlsConfig, err := newTLSConfig(option)
+	if err != nil {
+		return transport, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func newTLSConfig(option ClientOption) (*tls.Config, error) {
+	if option.CertFile == "" && option.CAFile == "" && option.MinTLSVersion == "" &&
+		option.ServerName == "" && !option.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         option.ServerName,
+		InsecureSkipVerify: option.InsecureSkipVerify,
+	}
+	if option.MinTLSVersion != "" {
+		version, found := tlsVersions[option.MinTLSVersion]
+		if !found {
+			return nil, fmt.Errorf("unknow tls version \"%s\"", option.MinTLSVersion)
+		}
+		config.MinVersion = version
+	}
+	if option.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(option.CertFile, option.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate error:%s", err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if option.CAFile != "" {
+		buff, err := ioutil.ReadFile(option.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca file error:%s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buff) {
+			return nil, fmt.Errorf("ca file \"%s\" has no certificate", option.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func dialContext(dialer *net.Dialer, option ClientOption) dialContextFunc {
+	overrides := map[string]string{}
+	for key, value := range option.DNSOverrides {
+		overrides[strings.ToLower(key)] = value
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if option.UnixSocket != "" {
+			return dialer.DialContext(ctx, "unix", option.UnixSocket)
+		}
+		if target, found := overrides[strings.ToLower(addr)]; found {
+			addr = target
+		} else if host, port, err := net.SplitHostPort(addr); err == nil {
+			if target, found := overrides[strings.ToLower(host)]; found {
+				if _, _, err := net.SplitHostPort(target); err == nil {
+					addr = target
+				} else {
+					addr = net.JoinHostPort(target, port)
+				}
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// LoadClientOption 读取 [http] 段配置，name 不为空时再用 [http.clients.<name>] 覆盖
+func LoadClientOption(name string) ClientOption {
+	option := ClientOption{}
+	if configs.Settings.IsSet(httpSettingKey) {
+		if err := configs.Settings.UnmarshalKey(httpSettingKey, &option); err != nil {
+			log.Println(fmt.Sprintf("exthttp load option [%s] error:%s", httpSettingKey, err.Error()))
+		}
+	}
+	if name != "" {
+		key := fmt.Sprintf("%s.clients.%s", httpSettingKey, name)
+		if configs.Settings.IsSet(key) {
+			if err := configs.Settings.UnmarshalKey(key, &option); err != nil {
+				log.Println(fmt.Sprintf("exthttp load option [%s] error:%s", key, err.Error()))
+			}
+		}
+	}
+	return option
+}
+
+var clientOptions = map[string]ClientOption{}
+var clients = map[string]*HttpClient{}
+var clientLock = sync.Mutex{}
+
+// SetClientOption 注册命名客户端的配置，已创建的同名客户端会被替换
+func SetClientOption(name string, option ClientOption) {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+	clientOptions[name] = option
+	delete(clients, name)
+}
+
+// Choice 获取命名客户端，未通过 SetClientOption 注册时从 [http.clients.<name>] 加载
+func Choice(name string) *HttpClient {
+	clientLock.Lock()
+	defer clientLock.Unlock()
+
+	if client, found := clients[name]; found {
+		return client
+	}
+	option, found := clientOptions[name]
+	if !found {
+		option = LoadClientOption(name)
+	}
+	clients[name] = NewHttpClient(option)
+	if option.Metrics {
+		clients[name].UseMetricsEX(name, nil, MetricsOptions{Host: option.MetricsHost})
+	}
+	return clients[name]
+}
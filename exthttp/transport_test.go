@@ -0,0 +1,66 @@
+package exthttp
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHttpClient_HTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range []struct {
+		option   ClientOption
+		expected string
+	}{
+		{ClientOption{CAFile: caFile}, "HTTP/2.0"},
+		{ClientOption{CAFile: caFile, DisableHTTP2: true}, "HTTP/1.1"},
+	} {
+		client, err := NewHttpClientEX(item.option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.RawRequestEX(http.MethodGet, server.URL, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Proto != item.expected || string(resp.Body) != item.expected {
+			t.Fatalf("expected %s, got %s %s", item.expected, resp.Proto, resp.Body)
+		}
+	}
+}
+
+func TestNewHttpClient_DNSOverrides(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	client, err := NewHttpClientEX(ClientOption{DNSOverrides: map[string]string{"api.example.invalid": "127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buff, err := client.RawRequest(http.MethodGet, "http://api.example.invalid:"+port+"/", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buff) != "api.example.invalid:"+port {
+		t.Fatalf("unexpected host %s", buff)
+	}
+}
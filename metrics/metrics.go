@@ -0,0 +1,325 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// DefBuckets 默认的耗时分桶（秒）
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry 指标集合，同一个名称只能注册一种类型
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+var defaultRegistry *Registry
+var defaultRegistryLock = sync.Mutex{}
+
+// Default web、database、exthttp 默认使用的指标集合
+func Default() *Registry {
+	defaultRegistryLock.Lock()
+	defer defaultRegistryLock.Unlock()
+	if defaultRegistry == nil {
+		defaultRegistry = NewRegistry()
+	}
+	return defaultRegistry
+}
+
+func SetDefault(registry *Registry) {
+	defaultRegistryLock.Lock()
+	defer defaultRegistryLock.Unlock()
+	defaultRegistry = registry
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  uint64 // float64 bits
+	counts []uint64
+	sum    uint64 // float64 bits
+	count  uint64
+}
+
+// register 同名且类型、标签及分桶相同时返回已有的指标
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if f, found := r.families[name]; found {
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metric \"%s\" already registered as %s%v", name, f.kind, f.labels))
+		}
+		if !sameBuckets(f.buckets, buckets) {
+			panic(fmt.Sprintf("metric \"%s\" already registered with buckets %v", name, f.buckets))
+		}
+		return f
+	}
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.families[name] = f
+	return f
+}
+
+func sameBuckets(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric \"%s\" needs %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if s, found := f.series[key]; found {
+		return s
+	}
+	s := &series{labels: append([]string{}, values...)}
+	if f.kind == histogramType {
+		s.counts = make([]uint64, len(f.buckets))
+	}
+	f.series[key] = s
+	return s
+}
+
+func addFloat(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(addr, old, next) {
+			return
+		}
+	}
+}
+
+func loadFloat(addr *uint64) float64 {
+	return math.Float64frombits(atomic.LoadUint64(addr))
+}
+
+// CounterVec 只增不减的计数
+type CounterVec struct{ family *family }
+
+type Counter struct{ series *series }
+
+// Counter 注册计数器，labels 为标签名
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{family: r.register(name, help, counterType, nil, labels)}
+}
+
+// With 按标签名的顺序传入标签值
+func (v *CounterVec) With(values ...string) Counter {
+	return Counter{series: v.family.with(values)}
+}
+
+func (c Counter) Inc() {
+	c.Add(1)
+}
+
+// Add delta 小于 0 时忽略
+func (c Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	addFloat(&c.series.value, delta)
+}
+
+func (c Counter) Value() float64 {
+	return loadFloat(&c.series.value)
+}
+
+// GaugeVec 可增可减的数值
+type GaugeVec struct{ family *family }
+
+type Gauge struct{ series *series }
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{family: r.register(name, help, gaugeType, nil, labels)}
+}
+
+func (v *GaugeVec) With(values ...string) Gauge {
+	return Gauge{series: v.family.with(values)}
+}
+
+func (g Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.series.value, math.Float64bits(value))
+}
+
+func (g Gauge) Add(delta float64) {
+	addFloat(&g.series.value, delta)
+}
+
+func (g Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g Gauge) Value() float64 {
+	return loadFloat(&g.series.value)
+}
+
+// HistogramVec 分桶统计，用于耗时、大小等
+type HistogramVec struct{ family *family }
+
+type Histogram struct {
+	series  *series
+	buckets []float64
+}
+
+// Histogram 注册直方图，buckets 为空时使用 DefBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{family: r.register(name, help, histogramType, buckets, labels)}
+}
+
+func (v *HistogramVec) With(values ...string) Histogram {
+	return Histogram{series: v.family.with(values), buckets: v.family.buckets}
+}
+
+func (h Histogram) Observe(value float64) {
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		atomic.AddUint64(&h.series.counts[i], 1)
+	}
+	addFloat(&h.series.sum, value)
+	atomic.AddUint64(&h.series.count, 1)
+}
+
+// WriteText 以 Prometheus 文本格式（0.0.4）输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*family, 0, len(names))
+	for _, name := range names {
+		list = append(list, r.families[name])
+	}
+	r.lock.Unlock()
+
+	buff := bufio.NewWriter(w)
+	for _, f := range list {
+		f.write(buff)
+	}
+	return buff.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.lock.Lock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, f.series[key])
+	}
+	f.lock.Unlock()
+	if len(list) == 0 {
+		return
+	}
+
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range list {
+		if f.kind != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelText(f.labels, s.labels, "", ""), formatFloat(loadFloat(&s.value)))
+			continue
+		}
+		// Observe 先增加分桶再增加 count，先读取 count，并发写入时分桶不超过 count
+		count := atomic.LoadUint64(&s.count)
+		cumulative := uint64(0)
+		for i, bound := range f.buckets {
+			cumulative += atomic.LoadUint64(&s.counts[i])
+			if cumulative > count {
+				cumulative = count
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(f.labels, s.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(f.labels, s.labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelText(f.labels, s.labels, "", ""), formatFloat(loadFloat(&s.sum)))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelText(f.labels, s.labels, "", ""), count)
+	}
+}
+
+func labelText(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(text string) string {
+	return helpReplacer.Replace(text)
+}
+
+func escapeLabel(text string) string {
+	return labelReplacer.Replace(text)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
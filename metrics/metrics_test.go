@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("requests_total", "Total requests.", "path", "status")
+	requests.With("/a\"b", "200").Inc()
+	requests.With("/a\"b", "200").Add(2)
+	registry.Gauge("in_flight", "In flight.").With().Set(3)
+	latency := registry.Histogram("latency_seconds", "Latency\nseconds.", []float64{1, 0.5})
+	latency.With().Observe(0.5)
+	latency.With().Observe(2)
+
+	if registry.Counter("requests_total", "", "path", "status") == nil {
+		t.Fatal("register again should return the existing metric")
+	}
+
+	var buff bytes.Buffer
+	if err := registry.WriteText(&buff); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight 3
+# HELP latency_seconds Latency\nseconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.5"} 1
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 2.5
+latency_seconds_count 2
+# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{path="/a\"b",status="200"} 3
+`
+	if buff.String() != expected {
+		t.Fatalf("unexpected output:\n%s", buff.String())
+	}
+}
+
+func TestRegistry_TypeMismatch(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("jobs", "")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	registry.Gauge("jobs", "")
+}
+
+func TestRegistry_BucketMismatch(t *testing.T) {
+	registry := NewRegistry()
+	registry.Histogram("duration_seconds", "", []float64{1, 0.5})
+	if registry.Histogram("duration_seconds", "", []float64{0.5, 1}) == nil {
+		t.Fatal("register again should return the existing metric")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	registry.Histogram("duration_seconds", "", nil)
+}
+
+func TestRegistry_HistogramInFlight(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.Histogram("size_bytes", "", []float64{1}).With()
+	histogram.Observe(0)
+	// Observe 已增加分桶，还没有增加 count
+	histogram.series.counts[0]++
+
+	buff := &bytes.Buffer{}
+	registry.WriteText(buff)
+	expected := `# TYPE size_bytes histogram
+size_bytes_bucket{le="1"} 1
+size_bytes_bucket{le="+Inf"} 1
+size_bytes_sum 0
+size_bytes_count 1
+`
+	if buff.String() != expected {
+		t.Fatalf("unexpected output:\n%s", buff.String())
+	}
+}
@@ -2,35 +2,365 @@ package utils
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/rds"
 )
 
-func (e *Encrypt_Util) EC1Encrypt(origData, key []byte) (*bytes.Buffer, error) {
+// EC-1 加密信封格式（整数均为大端）：
+//
+//	version(1) | key_id_len(1) | key_id | timestamp(8, unix 秒) | nonce(12) | AES-GCM 密文及 tag
+//
+// version 到 nonce 的部分作为 GCM 的附加数据参与认证，篡改 key_id 或 timestamp 会导致解密失败。
+const (
+	EC1Header = "X-Content-Encrypt"
+	EC1Name   = "EC-1"
+	// EC1ContentTypeHeader 加密前的 Content-Type，加密后的内容使用 application/octet-stream
+	EC1ContentTypeHeader = "X-Content-Encrypt-Type"
+	EC1ContentType       = "application/octet-stream"
+
+	ec1Version = 1
+
+	ec1NonceSize = 12
+)
+
+const (
+	ec1KeysSettingKey          = "ec1_keys"
+	ec1CurrentKeySettingKey    = "ec1_current_key"
+	ec1MaxSkewSecondSettingKey = "ec1_max_skew_second"
+	ec1NonceStoreSettingKey    = "ec1_nonce_store"
+)
+
+var (
+	ErrEC1Format   = fmt.Errorf("ec1 envelope format error")
+	ErrEC1Key      = fmt.Errorf("ec1 unknow key")
+	ErrEC1Expired  = fmt.Errorf("ec1 envelope expired")
+	ErrEC1Replay   = fmt.Errorf("ec1 envelope replayed")
+	ErrEC1Tampered = fmt.Errorf("ec1 envelope tampered")
+)
+
+// EC1NonceStore 记录已使用的 nonce，Seen 在 ttl 内重复出现时返回 true
+type EC1NonceStore interface {
+	Seen(nonce string, ttl time.Duration) (bool, error)
+}
+
+type memoryNonceStore struct {
+	lock   sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+func NewMemoryNonceStore() EC1NonceStore {
+	return &memoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+func (s *memoryNonceStore) Seen(nonce string, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.pruned) > ttl {
+		for key, expire := range s.nonces {
+			if now.After(expire) {
+				delete(s.nonces, key)
+			}
+		}
+		s.pruned = now
+	}
+	if expire, found := s.nonces[nonce]; found && now.Before(expire) {
+		return true, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return false, nil
+}
+
+// RedisNonceStore 多个实例共享 nonce 记录，Client 为空时使用 rds.Default
+type RedisNonceStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (s *RedisNonceStore) Seen(nonce string, ttl time.Duration) (bool, error) {
+	client := s.Client
+	if client == nil {
+		if client = rds.Default; client == nil {
+			return false, fmt.Errorf("redis nonce store needs rds.Default")
+		}
+	}
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "codex:ec1:nonce:"
+	}
+	ok, err := client.SetNX(prefix+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+// EC1Keyring 管理多个密钥，加密使用当前密钥，解密按信封中的 key_id 选择密钥，
+// 轮换时先添加新密钥并设为当前密钥，旧密钥保留到所有客户端切换后再删除
+type EC1Keyring struct {
+	// MaxSkew 允许的时间偏差，超出时拒绝，默认 5 分钟
+	MaxSkew time.Duration
+	// Nonces 为 nil 时不做重放检查
+	Nonces EC1NonceStore
+
+	lock    sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func NewEC1Keyring() *EC1Keyring {
+	return &EC1Keyring{MaxSkew: 5 * time.Minute, Nonces: NewMemoryNonceStore(), keys: map[string]cipher.AEAD{}}
+}
+
+// AddKey 添加密钥，长度不是 16/24/32 字节时使用其 SHA-256，第一个添加的密钥作为当前密钥
+func (k *EC1Keyring) AddKey(id string, key []byte) error {
+	if len(id) > 255 {
+		return fmt.Errorf("ec1 key id too long")
+	}
+	aead, err := newEC1AEAD(key)
+	if err != nil {
+		return err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keys[id] = aead
+	if len(k.keys) == 1 {
+		k.current = id
+	}
+	return nil
+}
+
+func (k *EC1Keyring) SetCurrent(id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, found := k.keys[id]; !found {
+		return fmt.Errorf("ec1 unknow key \"%s\"", id)
+	}
+	k.current = id
+	return nil
+}
+
+func (k *EC1Keyring) Current() string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.current
+}
+
+func (k *EC1Keyring) RemoveKey(id string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	delete(k.keys, id)
+	if k.current == id {
+		k.current = ""
+	}
+}
 
-	return bytes.NewBuffer(origData), nil
-	// var err error
-	// timestamp := time.Now().Unix()
-	// encryptByteBuff := new(bytes.Buffer)
-	// bs := make([]byte, 8)
-	// binary.LittleEndian.PutUint64(bs, uint64(timestamp))
-	// encryptByteBuff.Write(bs)
-	// buff2, err = e.DesEncrypt(origData, bs)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// sign := StringUtil.MD5Byte(buff2)
-	// encryptByteBuff.Write(sign)
-	// encryptByteBuff.Write(buff2)
+func newEC1AEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		sum := sha256.Sum256(key)
+		key = sum[:]
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
 
-	// return encryptByteBuff, nil
+// Seal 使用当前密钥加密
+func (k *EC1Keyring) Seal(plaintext []byte) ([]byte, error) {
+	return k.SealWith(k.Current(), plaintext)
+}
+
+// SealWith 使用指定密钥加密，服务端用请求的 key_id 加密响应
+func (k *EC1Keyring) SealWith(id string, plaintext []byte) ([]byte, error) {
+	k.lock.RLock()
+	aead, found := k.keys[id]
+	k.lock.RUnlock()
+	if !found {
+		return nil, ErrEC1Key
+	}
+	return ec1Seal(aead, id, time.Now(), plaintext)
+}
+
+func ec1Seal(aead cipher.AEAD, id string, timestamp time.Time, plaintext []byte) ([]byte, error) {
+	header := make([]byte, 0, 2+len(id)+8+ec1NonceSize)
+	header = append(header, ec1Version, byte(len(id)))
+	header = append(header, id...)
+	header = append(header, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(header[len(header)-8:], uint64(timestamp.Unix()))
+	nonce := make([]byte, ec1NonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// EC1Envelope 解析后的信封
+type EC1Envelope struct {
+	KeyID     string
+	Timestamp time.Time
+	Nonce     []byte
+	Plaintext []byte
+}
 
+// Open 校验并解密，检查时间偏差及 nonce 重放
+func (k *EC1Keyring) Open(envelope []byte) (*EC1Envelope, error) {
+	if len(envelope) < 2 || envelope[0] != ec1Version {
+		return nil, ErrEC1Format
+	}
+	idLen := int(envelope[1])
+	headerLen := 2 + idLen + 8 + ec1NonceSize
+	if len(envelope) < headerLen {
+		return nil, ErrEC1Format
+	}
+	result := &EC1Envelope{
+		KeyID:     string(envelope[2 : 2+idLen]),
+		Timestamp: time.Unix(int64(binary.BigEndian.Uint64(envelope[2+idLen:2+idLen+8])), 0),
+		Nonce:     envelope[headerLen-ec1NonceSize : headerLen],
+	}
+
+	k.lock.RLock()
+	aead, found := k.keys[result.KeyID]
+	k.lock.RUnlock()
+	if !found {
+		return nil, ErrEC1Key
+	}
+	plaintext, err := aead.Open(nil, result.Nonce, envelope[headerLen:], envelope[:headerLen])
+	if err != nil {
+		return nil, ErrEC1Tampered
+	}
+	result.Plaintext = plaintext
+
+	if k.MaxSkew > 0 {
+		skew := time.Since(result.Timestamp)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > k.MaxSkew {
+			return nil, ErrEC1Expired
+		}
+	}
+	if k.Nonces != nil {
+		ttl := 2 * k.MaxSkew
+		if ttl <= 0 {
+			ttl = 24 * time.Hour
+		}
+		seen, err := k.Nonces.Seen(result.KeyID+":"+hex.EncodeToString(result.Nonce), ttl)
+		if err != nil {
+			return nil, fmt.Errorf("ec1 nonce store error:%s", err.Error())
+		}
+		if seen {
+			return nil, ErrEC1Replay
+		}
+	}
+	return result, nil
+}
+
+// LoadEC1Keyring 从配置加载密钥：
+//
+//	ec1_current_key = "2024"
+//	ec1_max_skew_second = 300
+//	ec1_nonce_store = "redis"
+//	[ec1_keys]
+//	2023 = "base64 key"
+//	2024 = "base64 key"
+func LoadEC1Keyring() (*EC1Keyring, error) {
+	keyring := NewEC1Keyring()
+	keyring.MaxSkew = time.Duration(configs.Settings.GetInt(ec1MaxSkewSecondSettingKey)) * time.Second
+	switch store := configs.Settings.GetString(ec1NonceStoreSettingKey); store {
+	case "", "memory":
+	case "redis":
+		keyring.Nonces = &RedisNonceStore{}
+	case "none":
+		keyring.Nonces = nil
+	default:
+		return nil, fmt.Errorf("unknow ec1 nonce store \"%s\"", store)
+	}
+	for id, value := range configs.Settings.GetStringMapString(ec1KeysSettingKey) {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("ec1 key \"%s\" error:%s", id, err.Error())
+		}
+		if err := keyring.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if current := configs.Settings.GetString(ec1CurrentKeySettingKey); current != "" {
+		if err := keyring.SetCurrent(current); err != nil {
+			return nil, err
+		}
+	}
+	return keyring, nil
+}
+
+var ec1Keyring *EC1Keyring
+var ec1KeyringLock = sync.Mutex{}
+
+// DefaultEC1Keyring 首次调用时从配置加载
+func DefaultEC1Keyring() (*EC1Keyring, error) {
+	ec1KeyringLock.Lock()
+	defer ec1KeyringLock.Unlock()
+	if ec1Keyring == nil {
+		keyring, err := LoadEC1Keyring()
+		if err != nil {
+			return nil, err
+		}
+		ec1Keyring = keyring
+	}
+	return ec1Keyring, nil
+}
+
+func SetDefaultEC1Keyring(keyring *EC1Keyring) {
+	ec1KeyringLock.Lock()
+	defer ec1KeyringLock.Unlock()
+	ec1Keyring = keyring
+}
+
+func init() {
+	configs.Settings.SetDefault(ec1MaxSkewSecondSettingKey, 300)
+}
+
+// EC1Encrypt 使用单个密钥加密，key_id 为空
+func (e *Encrypt_Util) EC1Encrypt(origData, key []byte) (*bytes.Buffer, error) {
+	aead, err := newEC1AEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	buff, err := ec1Seal(aead, "", time.Now(), origData)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(buff), nil
 }
 
+// EC1Decrypt 使用单个密钥解密，只校验完整性及时间偏差，不做重放检查
 func (*Encrypt_Util) EC1Decrypt(crypted, key []byte) ([]byte, error) {
-	// 校验内容是否有篡改
-	// 解密内容
-	// key4 := crypted[:4]
-	// timestamp := crypted[4:12]
-	// buff2 := append(append(origData[12:], bs...), key...)
-	// sign := StringUtil.MD5Byte(buff2)
-	return crypted, nil
+	keyring := NewEC1Keyring()
+	keyring.Nonces = nil
+	if err := keyring.AddKey("", key); err != nil {
+		return nil, err
+	}
+	envelope, err := keyring.Open(crypted)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.Plaintext, nil
 }
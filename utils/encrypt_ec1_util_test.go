@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEC1Keyring_SealOpen(t *testing.T) {
+	keyring := NewEC1Keyring()
+	if err := keyring.AddKey("2024", []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := keyring.Seal([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := keyring.Open(envelope)
+	if err != nil || string(result.Plaintext) != "hello" || result.KeyID != "2024" {
+		t.Fatalf("unexpected open result %+v %v", result, err)
+	}
+	if _, err := keyring.Open(envelope); err != ErrEC1Replay {
+		t.Fatalf("replayed envelope should be rejected, got %v", err)
+	}
+
+	tampered, _ := keyring.Seal([]byte("hello"))
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := keyring.Open(tampered); err != ErrEC1Tampered {
+		t.Fatalf("tampered envelope should be rejected, got %v", err)
+	}
+	if _, err := keyring.Open([]byte{2, 0}); err != ErrEC1Format {
+		t.Fatalf("unexpected format error %v", err)
+	}
+
+	aead, _ := newEC1AEAD([]byte("0123456789abcdef"))
+	expired, _ := ec1Seal(aead, "2024", time.Now().Add(-time.Hour), []byte("hello"))
+	if _, err := keyring.Open(expired); err != ErrEC1Expired {
+		t.Fatalf("expired envelope should be rejected, got %v", err)
+	}
+}
+
+func TestEC1Keyring_Rotate(t *testing.T) {
+	server := NewEC1Keyring()
+	client := NewEC1Keyring()
+	server.AddKey("old", []byte("old key"))
+	client.AddKey("old", []byte("old key"))
+	oldEnvelope, _ := client.Seal([]byte("old"))
+
+	// 服务端先添加新密钥并切换，旧密钥仍可解密
+	server.AddKey("new", []byte("new key"))
+	if err := server.SetCurrent("new"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := server.Open(oldEnvelope)
+	if err != nil || result.KeyID != "old" {
+		t.Fatalf("old key should still open, got %+v %v", result, err)
+	}
+	// 响应使用请求的 key_id 加密，客户端切换前仍可解密
+	response, _ := server.SealWith(result.KeyID, []byte("response"))
+	if result, err := client.Open(response); err != nil || !bytes.Equal(result.Plaintext, []byte("response")) {
+		t.Fatalf("client should open response, got %v", err)
+	}
+
+	client.AddKey("new", []byte("new key"))
+	client.SetCurrent("new")
+	newEnvelope, _ := client.Seal([]byte("new"))
+	server.RemoveKey("old")
+	if result, err := server.Open(newEnvelope); err != nil || result.KeyID != "new" {
+		t.Fatalf("new key should open, got %v", err)
+	}
+	if _, err := server.Open(oldEnvelope); err != ErrEC1Key {
+		t.Fatalf("removed key should be rejected, got %v", err)
+	}
+	if err := server.SetCurrent("missing"); err == nil {
+		t.Fatal("unknow key should not be current")
+	}
+}
+
+func TestEncrypt_Util_EC1(t *testing.T) {
+	util := &Encrypt_Util{}
+	buff, err := util.EC1Encrypt([]byte("hello"), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := util.EC1Decrypt(buff.Bytes(), []byte("secret"))
+	if err != nil || string(plaintext) != "hello" {
+		t.Fatalf("unexpected plaintext %s %v", plaintext, err)
+	}
+	if _, err := util.EC1Decrypt(buff.Bytes(), []byte("other")); err == nil {
+		t.Fatal("wrong key should fail")
+	}
+}
@@ -94,7 +94,7 @@ func (c *Http_Util) RequestJSON(method string, url string, formParams map[string
 			if options.Headers == nil {
 				options.Headers = map[string]string{}
 			}
-			options.Headers["X-Content-Encrypt"] = "EC-1"
+			options.Headers[EC1Header] = EC1Name
 			byteBuff = encryptByteBuff
 		}
 		req, err = http.NewRequest(method, url, byteBuff)
@@ -117,7 +117,10 @@ func (c *Http_Util) RequestJSON(method string, url string, formParams map[string
 	defer resp.Body.Close()
 
 	var responseBuff []byte
-	if resp.Header.Get("X-Content-Encrypt") == "EC-1" {
+	if resp.Header.Get(EC1Header) == EC1Name {
+		if options == nil || options.ContentCipher == nil {
+			return fmt.Errorf("ec1 response needs ContentCipher")
+		}
 		buff2, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return err
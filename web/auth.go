@@ -0,0 +1,505 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/rds"
+)
+
+const (
+	jwtAccessTTLSecondSettingKey  = "jwt_access_ttl_second"
+	jwtRefreshTTLSecondSettingKey = "jwt_refresh_ttl_second"
+)
+
+const jwtClaimsContextKey = "codex.jwt.claims"
+
+func init() {
+	configs.Settings.SetDefault(jwtAccessTTLSecondSettingKey, 15*60)
+	configs.Settings.SetDefault(jwtRefreshTTLSecondSettingKey, 30*24*3600)
+}
+
+// JWTStore 保存刷新令牌及撤销记录
+type JWTStore interface {
+	SaveRefresh(key string, data []byte, ttl time.Duration) error
+	// TakeRefresh 原子地取出刷新令牌并标记为已使用，令牌已经使用过时 used 为 true，不存在时 data 为 nil
+	TakeRefresh(key string, usedTTL time.Duration) (data []byte, used bool, err error)
+	Revoke(key string, ttl time.Duration) error
+	// Revoked 任意一个 key 已撤销时返回 true
+	Revoked(keys ...string) (bool, error)
+}
+
+type memoryJWTItem struct {
+	data   []byte
+	expire time.Time
+}
+
+type memoryJWTStore struct {
+	lock    sync.Mutex
+	refresh map[string]memoryJWTItem
+	used    map[string]memoryJWTItem
+	revoked map[string]time.Time
+	swept   time.Time
+}
+
+// NewMemoryJWTStore 单实例使用，重启后刷新令牌失效
+func NewMemoryJWTStore() JWTStore {
+	return &memoryJWTStore{refresh: map[string]memoryJWTItem{}, used: map[string]memoryJWTItem{}, revoked: map[string]time.Time{}}
+}
+
+// sweep 每分钟删除一次过期的记录，调用时需要持有锁
+func (s *memoryJWTStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for _, items := range []map[string]memoryJWTItem{s.refresh, s.used} {
+		for key, item := range items {
+			if now.After(item.expire) {
+				delete(items, key)
+			}
+		}
+	}
+	for key, expire := range s.revoked {
+		if now.After(expire) {
+			delete(s.revoked, key)
+		}
+	}
+}
+
+func (s *memoryJWTStore) SaveRefresh(key string, data []byte, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep(time.Now())
+	s.refresh[key] = memoryJWTItem{data: data, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryJWTStore) TakeRefresh(key string, usedTTL time.Duration) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if item, found := s.refresh[key]; found && now.Before(item.expire) {
+		delete(s.refresh, key)
+		s.used[key] = memoryJWTItem{data: item.data, expire: now.Add(usedTTL)}
+		return item.data, false, nil
+	}
+	if item, found := s.used[key]; found && now.Before(item.expire) {
+		return item.data, true, nil
+	}
+	return nil, false, nil
+}
+
+func (s *memoryJWTStore) Revoke(key string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep(time.Now())
+	s.revoked[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memoryJWTStore) Revoked(keys ...string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key := range keys {
+		if expire, found := s.revoked[key]; found && time.Now().Before(expire) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RedisJWTStore Client 为空时使用 rds.Default
+type RedisJWTStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+const takeRefreshScript = `
+local v = redis.call('GET', KEYS[1])
+if v then
+	redis.call('DEL', KEYS[1])
+	redis.call('SET', KEYS[2], v, 'PX', ARGV[1])
+	return {1, v}
+end
+local u = redis.call('GET', KEYS[2])
+if u then
+	return {2, u}
+end
+return {0}
+`
+
+func (s *RedisJWTStore) client() (*redis.Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis jwt store needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (s *RedisJWTStore) key(key string) string {
+	if s.Prefix == "" {
+		return "codex:jwt:" + key
+	}
+	return s.Prefix + key
+}
+
+func (s *RedisJWTStore) SaveRefresh(key string, data []byte, ttl time.Duration) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Set(s.key("refresh:"+key), data, ttl).Err()
+}
+
+func (s *RedisJWTStore) TakeRefresh(key string, usedTTL time.Duration) ([]byte, bool, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, false, err
+	}
+	result, err := client.Eval(takeRefreshScript, []string{s.key("refresh:" + key), s.key("used:" + key)}, int64(usedTTL/time.Millisecond)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	items, ok := result.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, false, fmt.Errorf("redis jwt store unexpected result")
+	}
+	state, _ := items[0].(int64)
+	if state == 0 || len(items) < 2 {
+		return nil, false, nil
+	}
+	data, _ := items[1].(string)
+	return []byte(data), state == 2, nil
+}
+
+func (s *RedisJWTStore) Revoke(key string, ttl time.Duration) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Set(s.key("revoked:"+key), 1, ttl).Err()
+}
+
+func (s *RedisJWTStore) Revoked(keys ...string) (bool, error) {
+	client, err := s.client()
+	if err != nil {
+		return false, err
+	}
+	redisKeys := []string{}
+	for _, key := range keys {
+		redisKeys = append(redisKeys, s.key("revoked:"+key))
+	}
+	count, err := client.Exists(redisKeys...).Result()
+	return count > 0, err
+}
+
+// TokenPair Issue、Refresh 返回的令牌
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// JWTAuth 签发及校验令牌。同一次登录签发的令牌属于同一个 family（fam 声明），
+// 刷新令牌每次使用后轮换，已使用的刷新令牌再次出现时撤销整个 family
+type JWTAuth struct {
+	KeySet     *JWTKeySet
+	Store      JWTStore
+	Issuer     string
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	// Leeway 校验有效期时允许的时间偏差
+	Leeway time.Duration
+	// RefreshClaims 刷新时重新生成写入访问令牌的内容，如读取用户最新的 roles，返回错误时拒绝刷新；
+	// 为 nil 时沿用登录时的 claims
+	RefreshClaims func(subject string, claims Claims) (Claims, error)
+}
+
+type refreshRecord struct {
+	Subject string `json:"sub"`
+	Family  string `json:"fam"`
+	Claims  Claims `json:"claims"`
+}
+
+// NewJWTAuth 使用配置及 DefaultJWTKeySet，有 rds.Default 时使用 redis 保存，否则使用内存
+func NewJWTAuth() (*JWTAuth, error) {
+	keySet, err := DefaultJWTKeySet()
+	if err != nil {
+		return nil, err
+	}
+	auth := &JWTAuth{
+		KeySet:     keySet,
+		Issuer:     configs.Settings.GetString(jwtIssuerSettingKey),
+		Audience:   configs.Settings.GetString(jwtAudienceSettingKey),
+		AccessTTL:  time.Duration(configs.Settings.GetInt(jwtAccessTTLSecondSettingKey)) * time.Second,
+		RefreshTTL: time.Duration(configs.Settings.GetInt(jwtRefreshTTLSecondSettingKey)) * time.Second,
+		Leeway:     time.Duration(configs.Settings.GetInt(jwtLeewaySecondSettingKey)) * time.Second,
+	}
+	if rds.Default != nil {
+		auth.Store = &RedisJWTStore{}
+	} else {
+		auth.Store = NewMemoryJWTStore()
+	}
+	return auth, nil
+}
+
+var jwtAuth *JWTAuth
+var jwtAuthLock = sync.Mutex{}
+
+// DefaultJWTAuth 首次调用时使用 NewJWTAuth 创建
+func DefaultJWTAuth() (*JWTAuth, error) {
+	jwtAuthLock.Lock()
+	defer jwtAuthLock.Unlock()
+	if jwtAuth == nil {
+		auth, err := NewJWTAuth()
+		if err != nil {
+			return nil, err
+		}
+		jwtAuth = auth
+	}
+	return jwtAuth, nil
+}
+
+func SetDefaultJWTAuth(auth *JWTAuth) {
+	jwtAuthLock.Lock()
+	defer jwtAuthLock.Unlock()
+	jwtAuth = auth
+}
+
+func refreshKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue 登录时签发访问令牌及刷新令牌，claims 中的内容会写入访问令牌，如 roles
+func (a *JWTAuth) Issue(subject string, claims Claims) (*TokenPair, error) {
+	return a.issue(subject, uuid.NewV4().String(), claims)
+}
+
+func (a *JWTAuth) issue(subject string, family string, claims Claims) (*TokenPair, error) {
+	now := time.Now()
+	access := Claims{}
+	for key, value := range claims {
+		access[key] = value
+	}
+	access["sub"] = subject
+	access["jti"] = uuid.NewV4().String()
+	access["fam"] = family
+	access["iat"] = now.Unix()
+	access["exp"] = now.Add(a.AccessTTL).Unix()
+	if a.Issuer != "" {
+		access["iss"] = a.Issuer
+	}
+	if a.Audience != "" {
+		access["aud"] = a.Audience
+	}
+	accessToken, err := a.KeySet.Sign(access)
+	if err != nil {
+		return nil, err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	refreshToken := jwtEncode(random)
+	data, err := json.Marshal(refreshRecord{Subject: subject, Family: family, Claims: claims})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Store.SaveRefresh(refreshKey(refreshToken), data, a.RefreshTTL); err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(a.AccessTTL / time.Second),
+	}, nil
+}
+
+// Refresh 使用刷新令牌换取新的令牌，旧的刷新令牌失效
+func (a *JWTAuth) Refresh(refreshToken string) (*TokenPair, error) {
+	data, used, err := a.Store.TakeRefresh(refreshKey(refreshToken), a.RefreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrJWTExpired
+	}
+	record := refreshRecord{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	if used {
+		// 刷新令牌被重复使用，可能已经泄露，撤销这次登录的所有令牌
+		if err := a.RevokeFamily(record.Family); err != nil {
+			return nil, err
+		}
+		return nil, ErrJWTRevoked
+	}
+	if revoked, err := a.Store.Revoked("fam:" + record.Family); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrJWTRevoked
+	}
+	claims := record.Claims
+	if a.RefreshClaims != nil {
+		if claims, err = a.RefreshClaims(record.Subject, claims); err != nil {
+			return nil, err
+		}
+	}
+	return a.issue(record.Subject, record.Family, claims)
+}
+
+// Verify 校验签名、有效期、iss、aud 及撤销记录，没有 exp 的令牌视为无效
+func (a *JWTAuth) Verify(token string) (Claims, error) {
+	claims, err := a.KeySet.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	exp := claims.Time("exp")
+	if exp.IsZero() {
+		return nil, ErrJWTClaims
+	}
+	if now.After(exp.Add(a.Leeway)) {
+		return nil, ErrJWTExpired
+	}
+	if nbf := claims.Time("nbf"); !nbf.IsZero() && now.Add(a.Leeway).Before(nbf) {
+		return nil, ErrJWTClaims
+	}
+	if a.Issuer != "" && claims.String("iss") != a.Issuer {
+		return nil, ErrJWTClaims
+	}
+	if a.Audience != "" {
+		found := false
+		for _, aud := range claims.Strings("aud") {
+			if aud == a.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, ErrJWTClaims
+		}
+	}
+
+	keys := []string{}
+	if id := claims.ID(); id != "" {
+		keys = append(keys, "jti:"+id)
+	}
+	if family := claims.String("fam"); family != "" {
+		keys = append(keys, "fam:"+family)
+	}
+	if len(keys) > 0 && a.Store != nil {
+		revoked, err := a.Store.Revoked(keys...)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrJWTRevoked
+		}
+	}
+	return claims, nil
+}
+
+// Revoke 撤销一个访问令牌，记录保留到令牌过期
+func (a *JWTAuth) Revoke(claims Claims) error {
+	ttl := time.Until(claims.Time("exp")) + a.Leeway
+	if claims.Time("exp").IsZero() {
+		ttl = a.RefreshTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return a.Store.Revoke("jti:"+claims.ID(), ttl)
+}
+
+// RevokeFamily 撤销一次登录签发的所有访问令牌及刷新令牌，用于退出登录
+func (a *JWTAuth) RevokeFamily(family string) error {
+	return a.Store.Revoke("fam:"+family, a.RefreshTTL)
+}
+
+type JWTOptions struct {
+	// Auth 为 nil 时使用 DefaultJWTAuth
+	Auth *JWTAuth
+	// Optional 没有令牌时继续处理，令牌无效时仍然拒绝
+	Optional bool
+	// Cookie 没有 Authorization 头时从该 cookie 读取令牌
+	Cookie string
+}
+
+func unauthorized(c *gin.Context, msg string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, NewJSONResult().Error(cerror.UNAUTHORIZED, msg))
+}
+
+// JWT 校验 Authorization: Bearer 令牌，通过后使用 ClaimsOf 读取内容
+func JWT(option JWTOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := option.Auth
+		if auth == nil {
+			var err error
+			if auth, err = DefaultJWTAuth(); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+				return
+			}
+		}
+
+		token := ""
+		if header := c.GetHeader("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+			token = strings.TrimSpace(header[7:])
+		} else if option.Cookie != "" {
+			token, _ = c.Cookie(option.Cookie)
+		}
+		if token == "" {
+			if option.Optional {
+				c.Next()
+				return
+			}
+			unauthorized(c, "未登录")
+			return
+		}
+
+		claims, err := auth.Verify(token)
+		if err != nil {
+			if err != ErrJWTFormat && err != ErrJWTSignature && err != ErrJWTExpired && err != ErrJWTClaims && err != ErrJWTRevoked {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+				return
+			}
+			unauthorized(c, err.Error())
+			return
+		}
+		c.Set(jwtClaimsContextKey, claims)
+		c.Next()
+	}
+}
+
+// ClaimsOf 返回 JWT 中间件校验通过的内容，没有时返回 nil
+func ClaimsOf(c *gin.Context) Claims {
+	if val, found := c.Get(jwtClaimsContextKey); found {
+		if claims, ok := val.(Claims); ok {
+			return claims
+		}
+	}
+	return nil
+}
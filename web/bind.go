@@ -0,0 +1,442 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	validator "gopkg.in/go-playground/validator.v8"
+
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/enum"
+)
+
+const webValidateLangSettingKey = "web_validate_lang"
+
+func init() {
+	configs.Settings.SetDefault(webValidateLangSettingKey, "zh")
+	binding.Validator = &jsonValidator{}
+}
+
+// jsonValidator 与 gin 默认的校验器相同，另外以 json 名称作为错误的字段名，
+// c.Bind 等 gin 方法返回的 validator.ValidationErrors 经 MapError 转换后字段名与 Bind 一致
+type jsonValidator struct {
+	once     sync.Once
+	validate *validator.Validate
+}
+
+func (v *jsonValidator) ValidateStruct(obj interface{}) error {
+	value := reflect.ValueOf(obj)
+	kind := value.Kind()
+	if kind == reflect.Ptr {
+		kind = value.Elem().Kind()
+	}
+	if kind != reflect.Struct {
+		return nil
+	}
+	return v.Engine().(*validator.Validate).Struct(obj)
+}
+
+func (v *jsonValidator) Engine() interface{} {
+	v.once.Do(func() {
+		v.validate = validator.New(&validator.Config{TagName: "binding", FieldNameTag: "json"})
+	})
+	return v.validate
+}
+
+// ParameterErrors Bind 失败时返回，MapError 转换为 ParameterError，data 为字段错误
+type ParameterErrors []FieldError
+
+func (e ParameterErrors) Error() string {
+	messages := []string{}
+	for _, item := range e {
+		messages = append(messages, item.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// 消息模板，{field} 替换为字段路径，{param} 替换为规则参数
+var validateMessages = map[string]map[string]string{
+	"zh": {
+		"required": "{field}不能为空",
+		"min":      "{field}不能小于{param}",
+		"max":      "{field}不能大于{param}",
+		"len":      "{field}长度必须为{param}",
+		"eq":       "{field}必须等于{param}",
+		"ne":       "{field}不能等于{param}",
+		"gt":       "{field}必须大于{param}",
+		"gte":      "{field}必须大于等于{param}",
+		"lt":       "{field}必须小于{param}",
+		"lte":      "{field}必须小于等于{param}",
+		"email":    "{field}必须是邮箱地址",
+		"url":      "{field}必须是网址",
+		"alpha":    "{field}只能包含字母",
+		"alphanum": "{field}只能包含字母和数字",
+		"numeric":  "{field}必须是数字",
+		"enum":     "{field}不是有效的选项",
+		"type":     "{field}类型错误",
+		"format":   "请求内容格式错误",
+		"filter":   "{field}不能用于查询",
+		"sort":     "{field}不能用于排序",
+		"":         "{field}校验失败({rule})",
+	},
+	"en": {
+		"required": "{field} is required",
+		"min":      "{field} must be at least {param}",
+		"max":      "{field} must be at most {param}",
+		"len":      "{field} must have length {param}",
+		"eq":       "{field} must equal {param}",
+		"ne":       "{field} must not equal {param}",
+		"gt":       "{field} must be greater than {param}",
+		"gte":      "{field} must be greater than or equal to {param}",
+		"lt":       "{field} must be less than {param}",
+		"lte":      "{field} must be less than or equal to {param}",
+		"email":    "{field} must be a valid email address",
+		"url":      "{field} must be a valid URL",
+		"alpha":    "{field} must contain only letters",
+		"alphanum": "{field} must contain only letters and numbers",
+		"numeric":  "{field} must be numeric",
+		"enum":     "{field} is not a valid option",
+		"type":     "{field} has an invalid type",
+		"format":   "malformed request body",
+		"filter":   "{field} cannot be used as a filter",
+		"sort":     "{field} cannot be used for sorting",
+		"":         "{field} failed on the {rule} rule",
+	},
+}
+var validateMessagesLock = sync.RWMutex{}
+
+// SetValidateMessage 设置或添加规则的消息模板，rule 为空时设置默认模板
+func SetValidateMessage(lang string, rule string, template string) {
+	validateMessagesLock.Lock()
+	defer validateMessagesLock.Unlock()
+	if validateMessages[lang] == nil {
+		validateMessages[lang] = map[string]string{}
+	}
+	validateMessages[lang][rule] = template
+}
+
+func validateMessage(lang string, rule string, field string, param string) string {
+	validateMessagesLock.RLock()
+	messages, found := validateMessages[lang]
+	if !found {
+		messages = validateMessages[configs.Settings.GetString(webValidateLangSettingKey)]
+	}
+	template, found := messages[rule]
+	if !found {
+		template = messages[""]
+	}
+	validateMessagesLock.RUnlock()
+	return strings.NewReplacer("{field}", field, "{param}", param, "{rule}", rule).Replace(template)
+}
+
+// requestLang 按 Accept-Language 选择消息语言，没有对应的消息时使用配置 web_validate_lang
+func requestLang(c *gin.Context) string {
+	validateMessagesLock.RLock()
+	defer validateMessagesLock.RUnlock()
+	for _, item := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		lang := strings.ToLower(strings.TrimSpace(strings.SplitN(item, ";", 2)[0]))
+		if _, found := validateMessages[lang]; found {
+			return lang
+		}
+		lang = strings.SplitN(lang, "-", 2)[0]
+		if _, found := validateMessages[lang]; found {
+			return lang
+		}
+	}
+	return configs.Settings.GetString(webValidateLangSettingKey)
+}
+
+var enumSets = map[string]*enum.EnumSet{}
+var enumSetsLock = sync.RWMutex{}
+var registerEnumOnce = sync.Once{}
+
+// RegisterEnum 注册枚举，字段使用 binding:"enum=name" 校验值必须在枚举中
+func RegisterEnum(name string, set *enum.EnumSet) {
+	registerEnumOnce.Do(func() {
+		RegisterValidation("enum", validateEnum)
+	})
+	enumSetsLock.Lock()
+	defer enumSetsLock.Unlock()
+	enumSets[name] = set
+}
+
+func validateEnum(v *validator.Validate, topStruct reflect.Value, currentStruct reflect.Value, field reflect.Value, fieldType reflect.Type, fieldKind reflect.Kind, param string) bool {
+	enumSetsLock.RLock()
+	set, found := enumSets[param]
+	enumSetsLock.RUnlock()
+	if !found {
+		return false
+	}
+	// int 与 int64 等不同类型的相同值视为相等
+	value := fmt.Sprint(field.Interface())
+	for _, item := range set.GetData() {
+		if fmt.Sprint(item.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterValidation 在 gin 的校验器上注册自定义规则
+func RegisterValidation(tag string, fn validator.Func) error {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return fmt.Errorf("binding validator is not validator.v8")
+	}
+	return engine.RegisterValidation(tag, fn)
+}
+
+// Bind 依次合并 JSON 内容、表单、查询参数及路径参数到 obj 后校验，后面的来源覆盖前面的。
+// 表单、查询及路径参数按 form 标签匹配，没有时使用 json 标签或字段名。
+// 失败时返回 ParameterErrors，字段路径使用 json 名称，消息按 Accept-Language 选择语言
+func Bind(c *gin.Context, obj interface{}) error {
+	lang := requestLang(c)
+	value := reflect.ValueOf(obj)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bind needs a struct pointer")
+	}
+
+	contentType := c.ContentType()
+	if c.Request.Method != http.MethodGet && c.Request.Body != nil && strings.HasSuffix(contentType, "json") {
+		if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil && err != io.EOF {
+			if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+				return ParameterErrors{{Field: typeErr.Field, Rule: "type", Message: validateMessage(lang, "type", typeErr.Field, "")}}
+			}
+			return ParameterErrors{{Rule: "format", Message: validateMessage(lang, "format", "", "")}}
+		}
+	}
+
+	values := map[string][]string{}
+	if contentType == binding.MIMEPOSTForm || contentType == binding.MIMEMultipartPOSTForm {
+		if contentType == binding.MIMEMultipartPOSTForm {
+			c.Request.ParseMultipartForm(32 << 20)
+		} else {
+			c.Request.ParseForm()
+		}
+		for key, items := range c.Request.PostForm {
+			values[key] = items
+		}
+	}
+	for key, items := range c.Request.URL.Query() {
+		values[key] = items
+	}
+	for _, param := range c.Params {
+		values[param.Key] = []string{param.Value}
+	}
+	if errs := mapValues(value.Elem(), values, "", lang); len(errs) > 0 {
+		return errs
+	}
+	return validateStruct(lang, obj)
+}
+
+// validateStruct 校验 obj，失败时返回 ParameterErrors
+func validateStruct(lang string, obj interface{}) error {
+	value := reflect.ValueOf(obj)
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+		errs := ParameterErrors{}
+		for _, item := range validationErrors {
+			path := fieldPath(value.Elem().Type(), item.FieldNamespace)
+			errs = append(errs, FieldError{
+				Field:   path,
+				Rule:    item.Tag,
+				Param:   item.Param,
+				Message: validateMessage(lang, item.Tag, path, item.Param),
+			})
+		}
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return errs
+	}
+	return nil
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"form", "json"} {
+		if name := strings.Split(field.Tag.Get(tag), ",")[0]; name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// mapValues 只设置 values 中存在的字段，不覆盖 JSON 中已经解析的值
+func mapValues(value reflect.Value, values map[string][]string, prefix string, lang string) ParameterErrors {
+	errs := ParameterErrors{}
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := fieldName(field)
+		if name == "-" {
+			continue
+		}
+		fieldValue := value.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			childPrefix := prefix
+			if !field.Anonymous {
+				childPrefix = prefix + name + "."
+			}
+			errs = append(errs, mapValues(fieldValue, values, childPrefix, lang)...)
+			continue
+		}
+
+		items, found := values[prefix+name]
+		if !found || len(items) == 0 {
+			continue
+		}
+		if err := setValue(fieldValue, field, items); err != nil {
+			errs = append(errs, FieldError{Field: prefix + name, Rule: "type", Message: validateMessage(lang, "type", prefix+name, "")})
+		}
+	}
+	return errs
+}
+
+func setValue(value reflect.Value, field reflect.StructField, items []string) error {
+	switch value.Kind() {
+	case reflect.Ptr:
+		item := reflect.New(value.Type().Elem())
+		if err := setValue(item.Elem(), field, items); err != nil {
+			return err
+		}
+		value.Set(item)
+		return nil
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			value.SetBytes([]byte(items[0]))
+			return nil
+		}
+		slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), field, []string{item}); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+		return nil
+	}
+
+	text := items[0]
+	if value.Type() == timeType {
+		layout := field.Tag.Get("time_format")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		if layout == "unix" {
+			seconds, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return err
+			}
+			value.Set(reflect.ValueOf(time.Unix(seconds, 0)))
+			return nil
+		}
+		t, err := time.ParseInLocation(layout, text, time.Local)
+		if err != nil {
+			return err
+		}
+		value.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		if text == "on" {
+			text = "true"
+		}
+		val, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(val)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		val, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(text, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(val)
+	default:
+		return fmt.Errorf("unknow field kind \"%s\"", value.Kind().String())
+	}
+	return nil
+}
+
+// fieldPath 将校验器的 Type.Items[0].Name 转换为 json 名称 items[0].name
+func fieldPath(valueType reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")
+	if len(parts) > 1 {
+		parts = parts[1:]
+	}
+	path := []string{}
+	current := valueType
+	for _, part := range parts {
+		name, index := part, ""
+		if i := strings.Index(part, "["); i >= 0 {
+			name, index = part[:i], part[i:]
+		}
+		for current != nil && current.Kind() == reflect.Ptr {
+			current = current.Elem()
+		}
+		if current == nil || current.Kind() != reflect.Struct {
+			path = append(path, part)
+			current = nil
+			continue
+		}
+		field, found := current.FieldByName(name)
+		if !found {
+			path = append(path, part)
+			current = nil
+			continue
+		}
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" && jsonName != "-" {
+			name = jsonName
+		} else {
+			name = fieldName(field)
+		}
+		if !field.Anonymous {
+			path = append(path, name+index)
+		}
+		current = field.Type
+		for index != "" && current != nil {
+			for current.Kind() == reflect.Ptr {
+				current = current.Elem()
+			}
+			if current.Kind() != reflect.Slice && current.Kind() != reflect.Array && current.Kind() != reflect.Map {
+				break
+			}
+			current = current.Elem()
+			index = index[strings.Index(index, "]")+1:]
+		}
+	}
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type bindAddress struct {
+	City string `json:"city" binding:"required"`
+}
+
+type bindUser struct {
+	ID      int         `json:"id"`
+	Name    string      `json:"name" binding:"required"`
+	Age     int         `json:"age" binding:"min=1"`
+	Tags    []string    `json:"tags" form:"tag"`
+	Address bindAddress `json:"address"`
+}
+
+func bindRequest(method string, target string, contentType string, body string, header map[string]string) (*bindUser, error) {
+	engine := gin.New()
+	user := &bindUser{}
+	var err error
+	engine.Handle(method, "/users/:id", func(c *gin.Context) {
+		err = Bind(c, user)
+	})
+	req := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	return user, err
+}
+
+func TestBind_Merge(t *testing.T) {
+	// 路径参数覆盖查询参数，查询参数覆盖 JSON
+	user, err := bindRequest(http.MethodPost, "/users/7?id=3&age=20&tag=a&tag=b", "application/json",
+		`{"id":1,"name":"json","age":10,"address":{"city":"sz"}}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 7 || user.Name != "json" || user.Age != 20 || len(user.Tags) != 2 || user.Address.City != "sz" {
+		t.Fatalf("unexpected merge result %+v", user)
+	}
+
+	user, err = bindRequest(http.MethodPost, "/users/7?name=query", "application/x-www-form-urlencoded",
+		"name=form&age=5&address.city=gz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "query" || user.Age != 5 || user.Address.City != "gz" {
+		t.Fatalf("unexpected form result %+v", user)
+	}
+}
+
+func TestBind_FieldErrors(t *testing.T) {
+	_, err := bindRequest(http.MethodPost, "/users/1", "application/json", `{"age":0}`, nil)
+	errs, ok := err.(ParameterErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("unexpected errors %#v", err)
+	}
+	if errs[0].Field != "address.city" || errs[1].Field != "age" || errs[1].Rule != "min" || errs[1].Param != "1" || errs[2].Field != "name" {
+		t.Fatalf("unexpected field errors %+v", errs)
+	}
+	if errs[2].Message != "name不能为空" {
+		t.Fatalf("unexpected message %s", errs[2].Message)
+	}
+
+	_, err = bindRequest(http.MethodPost, "/users/1", "application/json", `{"age":0}`, map[string]string{"Accept-Language": "en-US,en;q=0.9"})
+	if errs := err.(ParameterErrors); errs[2].Message != "name is required" {
+		t.Fatalf("unexpected message %s", errs[2].Message)
+	}
+
+	_, err = bindRequest(http.MethodPost, "/users/1", "application/json", `{"name":1}`, nil)
+	if errs, ok := err.(ParameterErrors); !ok || errs[0].Field != "name" || errs[0].Rule != "type" {
+		t.Fatalf("unexpected type error %#v", err)
+	}
+	_, err = bindRequest(http.MethodPost, "/users/abc", "application/json", `{"name":"a","age":1}`, nil)
+	if errs, ok := err.(ParameterErrors); !ok || errs[0].Field != "id" || errs[0].Rule != "type" {
+		t.Fatalf("unexpected type error %#v", err)
+	}
+	_, err = bindRequest(http.MethodPost, "/users/1", "application/json", `{`, nil)
+	if errs, ok := err.(ParameterErrors); !ok || errs[0].Rule != "format" {
+		t.Fatalf("unexpected format error %#v", err)
+	}
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"encoding/json"
+	"fmt"
 
 	uuid "github.com/satori/go.uuid"
 
@@ -61,7 +62,7 @@ func (u *JSONResult) MarshalJSON() ([]byte, error) {
 	}
 
 	if u.ErrID != "" {
-		if u.ErrID != "" {
+		if u.errIDField != "" {
 			val[u.errIDField] = u.ErrID
 		} else {
 			val[errIDField] = u.ErrID
@@ -119,6 +120,9 @@ func (r *JSONResult) Error(msg ...interface{}) *JSONResult {
 			} else if val, ok := msg[0].(cerror.CodeError); ok {
 				r.Code = val.Code
 				r.Msg = val.Error()
+			} else if val, ok := msg[0].(*cerror.CodeError); ok && val != nil {
+				r.Code = val.Code
+				r.Msg = val.Error()
 			} else if val, ok := msg[0].(string); ok {
 				r.Code = 1
 				r.Msg = val
@@ -127,8 +131,18 @@ func (r *JSONResult) Error(msg ...interface{}) *JSONResult {
 				err = er
 			}
 		} else if len(msg) == 2 {
-			r.Code = msg[0].(int)
-			r.Msg = msg[1].(string)
+			// Error(code, msg) 或 Error(code, err)
+			r.Code = 1
+			if val, ok := msg[0].(int); ok {
+				r.Code = val
+			}
+			if val, ok := msg[1].(string); ok {
+				r.Msg = val
+			} else if er, ok := msg[1].(error); ok {
+				err = er
+			} else if msg[1] != nil {
+				r.Msg = fmt.Sprint(msg[1])
+			}
 		}
 	} else {
 
@@ -0,0 +1,141 @@
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/utils"
+)
+
+const ec1KeyIDContextKey = "codex.ec1.key_id"
+
+// errBodyTooLarge http.MaxBytesReader 超过长度时的错误
+const errBodyTooLarge = "http: request body too large"
+
+type EC1Options struct {
+	// Keyring 为 nil 时使用 utils.DefaultEC1Keyring
+	Keyring *utils.EC1Keyring
+	// Required 拒绝未加密的请求
+	Required bool
+	// MaxBodySize 加密请求内容的最大长度，默认 10MB
+	MaxBodySize int64
+}
+
+// ec1ResponseWriter 缓存响应内容，加密后才写入状态码及内容
+type ec1ResponseWriter struct {
+	gin.ResponseWriter
+	body    *bytes.Buffer
+	written bool
+}
+
+func (w *ec1ResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *ec1ResponseWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *ec1ResponseWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *ec1ResponseWriter) Flush() {
+}
+
+func (w *ec1ResponseWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *ec1ResponseWriter) Written() bool {
+	return w.written
+}
+
+// EC1 解密带 X-Content-Encrypt: EC-1 的请求，并使用请求的 key_id 加密响应
+// 加密内容的 Content-Type 为 application/octet-stream，原 Content-Type 放在 X-Content-Encrypt-Type 中
+func EC1(option EC1Options) gin.HandlerFunc {
+	if option.MaxBodySize <= 0 {
+		option.MaxBodySize = 10 << 20
+	}
+	return func(c *gin.Context) {
+		keyring := option.Keyring
+		if keyring == nil {
+			var err error
+			if keyring, err = utils.DefaultEC1Keyring(); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+				return
+			}
+		}
+
+		if c.GetHeader(utils.EC1Header) != utils.EC1Name {
+			if option.Required {
+				c.AbortWithStatusJSON(http.StatusBadRequest, NewJSONResult().ParameterError("需要加密请求"))
+				return
+			}
+			c.Next()
+			return
+		}
+
+		keyID := keyring.Current()
+		if c.Request.Body != nil && c.Request.ContentLength != 0 {
+			buff, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, option.MaxBodySize))
+			if err != nil {
+				status := http.StatusBadRequest
+				if err.Error() == errBodyTooLarge {
+					status = http.StatusRequestEntityTooLarge
+				}
+				c.AbortWithStatusJSON(status, NewJSONResult().ParameterError(err.Error()))
+				return
+			}
+			if len(buff) > 0 {
+				envelope, err := keyring.Open(buff)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, NewJSONResult().ParameterError(err.Error()))
+					return
+				}
+				keyID = envelope.KeyID
+				buff = envelope.Plaintext
+			}
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(buff))
+			c.Request.ContentLength = int64(len(buff))
+			c.Request.Header.Set("Content-Length", strconv.Itoa(len(buff)))
+			if contentType := c.Request.Header.Get(utils.EC1ContentTypeHeader); contentType != "" {
+				c.Request.Header.Set("Content-Type", contentType)
+				c.Request.Header.Del(utils.EC1ContentTypeHeader)
+			}
+		}
+		c.Set(ec1KeyIDContextKey, keyID)
+
+		writer := &ec1ResponseWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if writer.body.Len() == 0 {
+			return
+		}
+		envelope, err := keyring.SealWith(keyID, writer.body.Bytes())
+		if err != nil {
+			c.Writer.Header().Del("Content-Length")
+			c.Writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		header := c.Writer.Header()
+		if contentType := header.Get("Content-Type"); contentType != "" {
+			header.Set(utils.EC1ContentTypeHeader, contentType)
+		}
+		header.Set("Content-Type", utils.EC1ContentType)
+		header.Set(utils.EC1Header, utils.EC1Name)
+		header.Set("Content-Length", strconv.Itoa(len(envelope)))
+		c.Writer.Write(envelope)
+	}
+}
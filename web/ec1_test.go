@@ -0,0 +1,124 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/exthttp"
+	"github.com/zhin/go-codex/utils"
+)
+
+func newEC1TestKeyring() *utils.EC1Keyring {
+	keyring := utils.NewEC1Keyring()
+	keyring.AddKey("2024", []byte("ec1 test key"))
+	return keyring
+}
+
+func TestEC1(t *testing.T) {
+	engine := gin.New()
+	engine.Use(EC1(EC1Options{Keyring: newEC1TestKeyring(), Required: true, MaxBodySize: 1024}))
+	engine.POST("/echo", func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.JSON(http.StatusOK, gin.H{"body": string(body), "content_type": c.ContentType()})
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	client := exthttp.NewHttpClient(exthttp.ClientOption{})
+	client.UseEC1(newEC1TestKeyring())
+	result := struct {
+		Body        string `json:"body"`
+		ContentType string `json:"content_type"`
+	}{}
+	options := &exthttp.RequestOptions{}
+	err := client.RequestInto(http.MethodPost, server.URL+"/echo", nil, map[string]interface{}{"name": "codex"}, &result, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Body != `{"name":"codex"}` || result.ContentType != "application/json" {
+		t.Fatalf("unexpected decrypted request %+v", result)
+	}
+	if contentType := options.ResponseHeaders.Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+		t.Fatalf("unexpected decrypted response content type %s", contentType)
+	}
+
+	// 未加密的请求及超过大小的请求
+	resp, err := http.Post(server.URL+"/echo", "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain request should be rejected, got %v %v", resp, err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/echo", bytes.NewReader(make([]byte, 2048)))
+	req.Header.Set(utils.EC1Header, utils.EC1Name)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large request should be rejected, got %v %v", resp, err)
+	}
+
+	// 加密的响应使用 application/octet-stream
+	envelope, _ := newEC1TestKeyring().Seal([]byte("{}"))
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/echo", bytes.NewReader(envelope))
+	req.Header.Set(utils.EC1Header, utils.EC1Name)
+	req.Header.Set("Content-Type", utils.EC1ContentType)
+	req.Header.Set(utils.EC1ContentTypeHeader, "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != utils.EC1ContentType || resp.Header.Get(utils.EC1ContentTypeHeader) != "application/json; charset=utf-8" {
+		t.Fatalf("unexpected encrypted response header %v", resp.Header)
+	}
+}
+
+type errorReader struct{}
+
+func (errorReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestEC1_Buffered(t *testing.T) {
+	keyring := newEC1TestKeyring()
+	engine := gin.New()
+	engine.Use(EC1(EC1Options{Keyring: keyring}), Errors())
+	engine.GET("/error", func(c *gin.Context) {
+		c.Writer.Flush()
+		c.Error(errors.New("failed"))
+		c.JSON(http.StatusConflict, NewJSONResult().ParameterError("冲突"))
+		if c.Writer.Size() <= 0 || !c.Writer.Written() {
+			t.Errorf("buffered writer should report size, got %d", c.Writer.Size())
+		}
+	})
+	engine.POST("/echo", func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+
+	// Errors 不会在已写入的内容后追加，Flush 不会提前写出未加密的状态码
+	req := httptest.NewRequest(http.MethodGet, "/error", nil)
+	req.Header.Set(utils.EC1Header, utils.EC1Name)
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusConflict || recorder.Header().Get("Content-Type") != utils.EC1ContentType {
+		t.Fatalf("unexpected response %d %v", recorder.Code, recorder.Header())
+	}
+	envelope, err := keyring.Open(recorder.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(envelope.Plaintext, []byte("{")) != 1 {
+		t.Fatalf("unexpected plaintext %s", envelope.Plaintext)
+	}
+
+	// 读取请求内容失败不是 413
+	req = httptest.NewRequest(http.MethodPost, "/echo", ioutil.NopCloser(errorReader{}))
+	req.Header.Set(utils.EC1Header, utils.EC1Name)
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("body read error should return 400, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,188 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	validator "gopkg.in/go-playground/validator.v8"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/database"
+	"github.com/zhin/go-codex/exthttp"
+)
+
+// ErrorMapper 将错误转换为 HTTP 状态码及 JSONResult，不处理时返回 nil
+type ErrorMapper func(err error) (int, *JSONResult)
+
+var errorMappers = []ErrorMapper{}
+var codeStatus = map[int]int{
+	cerror.DB_ERROR:          http.StatusInternalServerError,
+	cerror.DB_NOT_FOUND:      http.StatusNotFound,
+	cerror.HTTP_ERROR:        http.StatusBadGateway,
+	cerror.UNAUTHORIZED:      http.StatusUnauthorized,
+	cerror.FORBIDDEN:         http.StatusForbidden,
+	cerror.TOO_MANY_REQUESTS: http.StatusTooManyRequests,
+}
+var errorMappingLock = sync.RWMutex{}
+
+// RegisterErrorMapper 添加自定义转换，先于内置规则按注册顺序匹配
+func RegisterErrorMapper(mapper ErrorMapper) {
+	errorMappingLock.Lock()
+	defer errorMappingLock.Unlock()
+	errorMappers = append(errorMappers, mapper)
+}
+
+// SetCodeStatus 设置 cerror.CodeError 的 Code 对应的 HTTP 状态码，未设置的 Code 使用 400
+func SetCodeStatus(code int, status int) {
+	errorMappingLock.Lock()
+	defer errorMappingLock.Unlock()
+	codeStatus[code] = status
+}
+
+// FieldError 参数校验失败的字段
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// fieldErrors 字段路径使用 json 名称（见 jsonValidator），去掉开头的结构体名
+func fieldErrors(lang string, errs validator.ValidationErrors) []FieldError {
+	fields := []FieldError{}
+	for _, item := range errs {
+		path := item.Name
+		if parts := strings.SplitN(item.NameNamespace, ".", 2); len(parts) == 2 {
+			path = parts[1]
+		}
+		fields = append(fields, FieldError{
+			Field:   path,
+			Rule:    item.Tag,
+			Param:   item.Param,
+			Message: validateMessage(lang, item.Tag, path, item.Param),
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
+// MapError 按以下规则转换错误：
+//
+//	自定义 ErrorMapper
+//	ParameterErrors、validator.ValidationErrors  400 参数错误，data 为字段错误
+//	*database.DbError                          找不到记录 404，无法连接 503，其他 500
+//	*exthttp.HttpResponseError                 502
+//	*exthttp.RequestError                      超时 504，其他 502
+//	net.Error 超时                              504
+//	cerror.CodeError                           SetCodeStatus 设置的状态码，默认 400
+//	其他                                        500
+//
+// DbError、HttpResponseError 及其他错误会生成 errid 并触发 SetErrorHook 注册的处理
+func MapError(err error) (int, *JSONResult) {
+	return MapErrorEX(nil, err)
+}
+
+// MapErrorEX 同 MapError，字段错误的消息按请求的 Accept-Language 选择语言，c 为 nil 时使用配置 web_validate_lang
+func MapErrorEX(c *gin.Context, err error) (int, *JSONResult) {
+	errorMappingLock.RLock()
+	mappers := errorMappers
+	errorMappingLock.RUnlock()
+	for _, mapper := range mappers {
+		if status, result := mapper(err); result != nil {
+			return status, result
+		}
+	}
+
+	switch val := err.(type) {
+	case ParameterErrors:
+		return http.StatusBadRequest, NewJSONResult().ParameterError().SetData(val)
+	case validator.ValidationErrors:
+		lang := configs.Settings.GetString(webValidateLangSettingKey)
+		if c != nil {
+			lang = requestLang(c)
+		}
+		return http.StatusBadRequest, NewJSONResult().ParameterError().SetData(fieldErrors(lang, val))
+	case *database.DbError:
+		if val.RecordNotFound() {
+			return http.StatusNotFound, NewJSONResult().Error(cerror.DB_NOT_FOUND, "记录不存在")
+		}
+		result := NewJSONResult().Error(cerror.DB_ERROR, err)
+		if val.Tag() == "DB.OPEN" {
+			return http.StatusServiceUnavailable, result
+		}
+		return http.StatusInternalServerError, result
+	case *exthttp.HttpResponseError:
+		return http.StatusBadGateway, NewJSONResult().Error(cerror.HTTP_ERROR, err)
+	case *exthttp.RequestError:
+		if val.Timeout() {
+			return http.StatusGatewayTimeout, NewJSONResult().Error(cerror.HTTP_ERROR, err)
+		}
+		return http.StatusBadGateway, NewJSONResult().Error(cerror.HTTP_ERROR, err)
+	case net.Error:
+		if val.Timeout() {
+			return http.StatusGatewayTimeout, NewJSONResult().Error(cerror.HTTP_ERROR, err)
+		}
+	case cerror.CodeError:
+		return statusOfCode(val.Code), NewJSONResult().Error(val)
+	case *cerror.CodeError:
+		if val != nil {
+			return statusOfCode(val.Code), NewJSONResult().Error(val)
+		}
+	}
+	return http.StatusInternalServerError, NewJSONResult().Error(err)
+}
+
+func statusOfCode(code int) int {
+	errorMappingLock.RLock()
+	defer errorMappingLock.RUnlock()
+	if status, found := codeStatus[code]; found {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+// AbortWithError 转换错误并返回 JSONResult
+func AbortWithError(c *gin.Context, err error) {
+	status, result := MapErrorEX(c, err)
+	c.AbortWithStatusJSON(status, result)
+}
+
+// Errors 处理 c.Error 添加的错误，handler 没有写入内容时使用最后一个错误返回 JSONResult，
+// 需要在注册路由之前 Use
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		// MustBindWith 只写入了状态码，内容为空时仍然返回 JSONResult
+		if len(c.Errors) == 0 || c.Writer.Size() > 0 {
+			return
+		}
+		last := c.Errors.Last()
+		var status int
+		var result *JSONResult
+		if _, ok := last.Err.(validator.ValidationErrors); !ok && last.IsType(gin.ErrorTypeBind) {
+			status, result = http.StatusBadRequest, NewJSONResult().ParameterError()
+		} else {
+			status, result = MapErrorEX(c, last.Err)
+		}
+		if c.Writer.Written() {
+			status = c.Writer.Status()
+		}
+		c.JSON(status, result)
+	}
+}
+
+// Handle 将返回错误的 handler 转换为 gin.HandlerFunc，出错时通过 c.Error 记录并返回 JSONResult
+func Handle(handler func(c *gin.Context) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := handler(c); err != nil {
+			c.Error(err)
+			AbortWithError(c, err)
+		}
+	}
+}
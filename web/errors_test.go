@@ -0,0 +1,126 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/exthttp"
+)
+
+func TestMapError(t *testing.T) {
+	client := exthttp.NewHttpClient(exthttp.ClientOption{TimeoutSecond: 1})
+
+	// 接受连接但不响应，触发客户端超时
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, timeoutErr := client.RawRequest(http.MethodGet, "http://"+listener.Addr().String(), nil, nil, nil)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, refusedErr := client.RawRequest(http.MethodGet, closed.URL, nil, nil, nil)
+
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   int
+	}{
+		{"parameter", ParameterErrors{{Field: "name", Rule: "required"}}, http.StatusBadRequest, 22},
+		{"response", &exthttp.HttpResponseError{RequestURL: "http://example.com", Status: 500}, http.StatusBadGateway, cerror.HTTP_ERROR},
+		{"timeout", timeoutErr, http.StatusGatewayTimeout, cerror.HTTP_ERROR},
+		{"refused", refusedErr, http.StatusBadGateway, cerror.HTTP_ERROR},
+		{"code", cerror.NewCodeError(cerror.UNAUTHORIZED, fmt.Errorf("token")), http.StatusUnauthorized, cerror.UNAUTHORIZED},
+		{"code pointer", &cerror.CodeError{Code: 999, InnerErr: fmt.Errorf("custom")}, http.StatusBadRequest, 999},
+		{"other", fmt.Errorf("unknow"), http.StatusInternalServerError, 1},
+	}
+	for _, item := range cases {
+		status, result := MapError(item.err)
+		if status != item.status || result.Code != item.code {
+			t.Errorf("%s: got %d %d, want %d %d (%v)", item.name, status, result.Code, item.status, item.code, item.err)
+		}
+	}
+	if !exthttp.IsRequestError(timeoutErr) {
+		t.Fatalf("client should keep the original error, got %T", timeoutErr)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	RegisterErrorMapper(func(err error) (int, *JSONResult) {
+		if err.Error() == "teapot" {
+			return http.StatusTeapot, NewJSONResult().Error(418, "teapot")
+		}
+		return 0, nil
+	})
+	engine := gin.New()
+	engine.Use(Errors())
+	engine.GET("/teapot", Handle(func(c *gin.Context) error {
+		return fmt.Errorf("teapot")
+	}))
+	engine.GET("/later", func(c *gin.Context) {
+		c.Error(cerror.NewCodeError(cerror.FORBIDDEN, fmt.Errorf("denied")))
+	})
+
+	for path, status := range map[string]int{"/teapot": http.StatusTeapot, "/later": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status || w.Body.Len() == 0 {
+			t.Errorf("%s: got %d %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestErrors_FieldErrors(t *testing.T) {
+	type item struct {
+		SkuCode string `json:"sku_code" binding:"required"`
+	}
+	type order struct {
+		UserName string  `json:"user_name" binding:"required"`
+		Items    []*item `json:"items" binding:"dive"`
+	}
+	engine := gin.New()
+	engine.Use(Errors())
+	engine.POST("/orders", func(c *gin.Context) {
+		c.Bind(&order{})
+	})
+
+	// gin 的 c.Bind 返回的校验错误使用 json 名称及请求的语言
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"items":[{}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	result := struct {
+		Data []FieldError `json:"data"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	expected := []FieldError{
+		{Field: "items[0].sku_code", Rule: "required", Message: "items[0].sku_code is required"},
+		{Field: "user_name", Rule: "required", Message: "user_name is required"},
+	}
+	if !reflect.DeepEqual(result.Data, expected) {
+		t.Fatalf("unexpected field errors %+v", result.Data)
+	}
+}
@@ -0,0 +1,307 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/database"
+	"github.com/zhin/go-codex/exthttp"
+	"github.com/zhin/go-codex/rds"
+)
+
+const (
+	webHealthTimeoutSecondSettingKey = "web_health_timeout_second"
+	webHealthCacheSecondSettingKey   = "web_health_cache_second"
+	webHealthUpstreamsSettingKey     = "web_health_upstreams"
+	webAdminTokenSettingKey          = "web_admin_token"
+)
+
+// AdminTokenHeader /debug 下的接口使用该请求头传入 web_admin_token，不支持查询参数，避免令牌被写入访问日志
+const AdminTokenHeader = "X-Admin-Token"
+
+// 编译时使用 -ldflags "-X github.com/zhin/go-codex/web.BuildVersion=1.2.0" 设置
+var (
+	BuildVersion string
+	BuildCommit  string
+	BuildTime    string
+)
+
+var startedAt = time.Now()
+
+func init() {
+	configs.Settings.SetDefault(webHealthTimeoutSecondSettingKey, 3)
+	configs.Settings.SetDefault(webHealthCacheSecondSettingKey, 5)
+}
+
+// HealthChecker 返回错误时服务未就绪，需要在 ctx 结束前返回
+type HealthChecker func(ctx context.Context) error
+
+type healthCheck struct {
+	lock      sync.Mutex
+	checker   HealthChecker
+	timeout   time.Duration
+	err       error
+	duration  time.Duration
+	checkedAt time.Time
+}
+
+var healthChecks = map[string]*healthCheck{}
+var healthChecksLock = sync.Mutex{}
+
+// RegisterHealthChecker 添加就绪检查，超时使用配置 web_health_timeout_second
+func RegisterHealthChecker(name string, checker HealthChecker) {
+	RegisterHealthCheckerEX(name, 0, checker)
+}
+
+// RegisterHealthCheckerEX 添加就绪检查并设置超时，同名的检查会被替换
+func RegisterHealthCheckerEX(name string, timeout time.Duration, checker HealthChecker) {
+	healthChecksLock.Lock()
+	defer healthChecksLock.Unlock()
+	healthChecks[name] = &healthCheck{checker: checker, timeout: timeout}
+}
+
+// DatabaseChecker 检查 database 中的连接
+func DatabaseChecker(dbKey string) HealthChecker {
+	return func(ctx context.Context) error {
+		return database.PingContext(ctx, dbKey)
+	}
+}
+
+// RedisChecker client 为空时使用 rds.Default
+func RedisChecker(client *redis.Client) HealthChecker {
+	return func(ctx context.Context) error {
+		current := client
+		if current == nil {
+			current = rds.Default
+		}
+		if current == nil {
+			return fmt.Errorf("redis checker needs rds.Default")
+		}
+		return current.WithContext(ctx).Ping().Err()
+	}
+}
+
+// HTTPChecker 使用 exthttp.Choice(name) 请求 url，状态码为 2xx 或 3xx 时可用
+func HTTPChecker(name string, url string) HealthChecker {
+	acceptStatus := []int{}
+	for status := 200; status < 400; status++ {
+		acceptStatus = append(acceptStatus, status)
+	}
+	return func(ctx context.Context) error {
+		_, err := exthttp.Choice(name).RawRequestEX(http.MethodGet, url, nil, nil, &exthttp.RequestOptions{AcceptStatus: acceptStatus, Context: ctx})
+		return err
+	}
+}
+
+// currentHealthChecks 注册的检查及内置检查：database 中的每个连接、rds.Default 及配置 web_health_upstreams 中的地址
+//
+//	[web_health_upstreams]
+//	payment = "https://pay.example.com/healthz"
+func currentHealthChecks() map[string]*healthCheck {
+	healthChecksLock.Lock()
+	defer healthChecksLock.Unlock()
+	builtin := map[string]HealthChecker{}
+	for _, dbKey := range database.Keys() {
+		builtin["database:"+dbKey] = DatabaseChecker(dbKey)
+	}
+	if rds.Default != nil {
+		builtin["redis"] = RedisChecker(nil)
+	}
+	for name, url := range configs.Settings.GetStringMapString(webHealthUpstreamsSettingKey) {
+		builtin["http:"+name] = HTTPChecker(name, url)
+	}
+	for name, checker := range builtin {
+		if _, found := healthChecks[name]; !found {
+			healthChecks[name] = &healthCheck{checker: checker}
+		}
+	}
+	checks := map[string]*healthCheck{}
+	for name, check := range healthChecks {
+		checks[name] = check
+	}
+	return checks
+}
+
+// run 在缓存时间内直接返回上次的结果
+func (h *healthCheck) run(cacheTTL time.Duration, defaultTimeout time.Duration) (time.Duration, time.Time, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < cacheTTL {
+		return h.duration, h.checkedAt, h.err
+	}
+
+	timeout := h.timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if val := recover(); val != nil {
+				done <- fmt.Errorf("health check panic:%v", val)
+			}
+		}()
+		done <- h.checker(ctx)
+	}()
+	select {
+	case h.err = <-done:
+	case <-ctx.Done():
+		h.err = fmt.Errorf("health check timeout after %s", timeout)
+	}
+	h.duration = time.Since(start)
+	h.checkedAt = time.Now()
+	return h.duration, h.checkedAt, h.err
+}
+
+// HealthCheckResult 单个检查的结果，Error 只在 ShowErrorDetail 或提供管理令牌时返回
+type HealthCheckResult struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// CheckHealth 并发执行所有就绪检查
+func CheckHealth(detail bool) (bool, map[string]HealthCheckResult) {
+	checks := currentHealthChecks()
+	cacheTTL := time.Duration(configs.Settings.GetInt(webHealthCacheSecondSettingKey)) * time.Second
+	timeout := time.Duration(configs.Settings.GetInt(webHealthTimeoutSecondSettingKey)) * time.Second
+
+	lock := sync.Mutex{}
+	wait := sync.WaitGroup{}
+	ready := true
+	results := map[string]HealthCheckResult{}
+	for name, check := range checks {
+		wait.Add(1)
+		go func(name string, check *healthCheck) {
+			defer wait.Done()
+			duration, checkedAt, err := check.run(cacheTTL, timeout)
+			result := HealthCheckResult{Status: "ok", DurationMS: int64(duration / time.Millisecond), CheckedAt: checkedAt}
+			if err != nil {
+				result.Status = "fail"
+				if detail {
+					result.Error = err.Error()
+				}
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			results[name] = result
+			ready = ready && err == nil
+		}(name, check)
+	}
+	wait.Wait()
+	return ready, results
+}
+
+// isAdmin 检查管理令牌，没有配置 web_admin_token 时总是返回 false
+func isAdmin(c *gin.Context) bool {
+	expected := configs.Settings.GetString(webAdminTokenSettingKey)
+	if expected == "" {
+		return false
+	}
+	return hmac.Equal([]byte(c.GetHeader(AdminTokenHeader)), []byte(expected))
+}
+
+func requireAdmin(c *gin.Context) {
+	if !isAdmin(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, NewJSONResult().Error(cerror.FORBIDDEN, "没有权限"))
+		return
+	}
+	c.Next()
+}
+
+// RegisterHealth 注册以下接口：
+//
+//	/healthz          存活检查，进程可以处理请求时返回 200
+//	/readyz           就绪检查，任意检查失败时返回 503
+//	/debug/status     配置来源、版本、运行状态及检查结果，需要管理令牌
+//	/debug/pprof/     pprof，需要管理令牌
+func RegisterHealth(group gin.IRouter) {
+	Route(group, http.MethodGet, "/healthz", APIDoc{Summary: "存活检查", Tags: []string{"health"}}, func(c *gin.Context) {
+		c.JSON(http.StatusOK, NewJSONResult().Success().SetData(gin.H{"status": "ok"}))
+	})
+
+	Route(group, http.MethodGet, "/readyz", APIDoc{Summary: "就绪检查", Tags: []string{"health"}}, func(c *gin.Context) {
+		ready, results := CheckHealth(showErrValue || isAdmin(c))
+		status, result := http.StatusOK, NewJSONResult().Success()
+		data := gin.H{"status": "ok", "checks": results}
+		if !ready {
+			status, result = http.StatusServiceUnavailable, NewJSONResult().Error("not ready")
+			data["status"] = "fail"
+		}
+		c.JSON(status, result.SetData(data))
+	})
+
+	debug := group.Group("/debug", requireAdmin)
+	Route(debug, http.MethodGet, "/status", APIDoc{Summary: "运行状态", Tags: []string{"health"}, Errors: []int{cerror.FORBIDDEN}}, func(c *gin.Context) {
+		_, results := CheckHealth(true)
+		memory := runtime.MemStats{}
+		runtime.ReadMemStats(&memory)
+		hostname, _ := os.Hostname()
+		c.JSON(http.StatusOK, NewJSONResult().Success().SetData(gin.H{
+			"config_source": configSource(),
+			"build": gin.H{
+				"version":    BuildVersion,
+				"commit":     BuildCommit,
+				"time":       BuildTime,
+				"go_version": runtime.Version(),
+			},
+			"runtime": gin.H{
+				"hostname":       hostname,
+				"pid":            os.Getpid(),
+				"started_at":     startedAt,
+				"uptime_second":  int64(time.Since(startedAt) / time.Second),
+				"goroutines":     runtime.NumGoroutine(),
+				"cpus":           runtime.NumCPU(),
+				"heap_alloc":     memory.HeapAlloc,
+				"heap_sys":       memory.HeapSys,
+				"gc_count":       memory.NumGC,
+				"gc_pause_total": memory.PauseTotalNs,
+			},
+			"checks": results,
+		}))
+	})
+
+	debug.GET("/pprof/*name", func(c *gin.Context) {
+		name := strings.Trim(c.Param("name"), "/")
+		switch name {
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	})
+}
+
+// configSource 去掉地址中的查询参数，避免泄露其中的令牌
+func configSource() string {
+	source := configs.SettingsSource
+	if i := strings.Index(source, "?"); i >= 0 {
+		source = source[:i]
+	}
+	return source
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/database"
+)
+
+func TestRegisterHealth_AdminToken(t *testing.T) {
+	configs.Settings.Set(webAdminTokenSettingKey, "admin-secret")
+	defer configs.Settings.Set(webAdminTokenSettingKey, "")
+	engine := gin.New()
+	RegisterHealth(engine)
+
+	call := func(path string, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set(AdminTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := call("/debug/status", "admin-secret"); code != http.StatusOK {
+		t.Fatalf("header token should be accepted, got %d", code)
+	}
+	if code := call("/debug/status", "wrong"); code != http.StatusForbidden {
+		t.Fatalf("wrong token should be rejected, got %d", code)
+	}
+	// 查询参数会出现在访问日志中，不再支持
+	if code := call("/debug/status?token=admin-secret", ""); code != http.StatusForbidden {
+		t.Fatalf("query token should be rejected, got %d", code)
+	}
+	if code := call("/healthz", ""); code != http.StatusOK {
+		t.Fatalf("healthz should be public, got %d", code)
+	}
+}
+
+func TestHealthCheckers_Context(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := HTTPChecker("health_test", server.URL)(ctx); err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("http checker should stop with ctx, got %v after %s", err, time.Since(start))
+	}
+
+	dbKey := "health_" + t.Name()
+	database.SetDBSet(dbKey, database.DBSetOption{DBType: "sqlite3", DBConnectionString: filepath.Join(t.TempDir(), "health.db")})
+	if err := DatabaseChecker(dbKey)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DatabaseChecker(dbKey)(canceled); err == nil {
+		t.Fatal("database checker should use ctx")
+	}
+}
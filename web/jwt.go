@@ -0,0 +1,551 @@
+package web
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/exthttp"
+)
+
+const (
+	jwtSecretSettingKey         = "jwt_secret"
+	jwtPrivateKeyFileSettingKey = "jwt_private_key_file"
+	jwtPublicKeyFileSettingKey  = "jwt_public_key_file"
+	jwtKeyIDSettingKey          = "jwt_key_id"
+	jwtJWKSFileSettingKey       = "jwt_jwks_file"
+	jwtJWKSURLSettingKey        = "jwt_jwks_url"
+	jwtIssuerSettingKey         = "jwt_issuer"
+	jwtAudienceSettingKey       = "jwt_audience"
+	jwtLeewaySecondSettingKey   = "jwt_leeway_second"
+)
+
+var (
+	ErrJWTFormat    = fmt.Errorf("jwt format error")
+	ErrJWTSignature = fmt.Errorf("jwt signature error")
+	ErrJWTExpired   = fmt.Errorf("jwt expired")
+	ErrJWTClaims    = fmt.Errorf("jwt claims invalid")
+	ErrJWTRevoked   = fmt.Errorf("jwt revoked")
+)
+
+func init() {
+	configs.Settings.SetDefault(jwtLeewaySecondSettingKey, 30)
+}
+
+// Claims JWT 内容，数字解析为 float64
+type Claims map[string]interface{}
+
+func (c Claims) String(key string) string {
+	if val, ok := c[key].(string); ok {
+		return val
+	}
+	return ""
+}
+
+func (c Claims) Time(key string) time.Time {
+	switch val := c[key].(type) {
+	case float64:
+		return time.Unix(int64(val), 0)
+	case int64:
+		return time.Unix(val, 0)
+	case int:
+		return time.Unix(int64(val), 0)
+	case json.Number:
+		seconds, _ := val.Int64()
+		return time.Unix(seconds, 0)
+	}
+	return time.Time{}
+}
+
+func (c Claims) Strings(key string) []string {
+	switch val := c[key].(type) {
+	case string:
+		return strings.Fields(val)
+	case []string:
+		return val
+	case []interface{}:
+		items := []string{}
+		for _, item := range val {
+			items = append(items, fmt.Sprint(item))
+		}
+		return items
+	}
+	return nil
+}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) ID() string {
+	return c.String("jti")
+}
+
+// Roles 读取 roles 声明，可以是数组或空格分隔的字符串
+func (c Claims) Roles() []string {
+	return c.Strings("roles")
+}
+
+// JWTKey Algorithm 为 HS256、RS256 或 ES256，HS256 使用 Secret，其他使用 PrivateKey 签名、PublicKey 校验
+type JWTKey struct {
+	ID         string
+	Algorithm  string
+	Secret     []byte
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+func (k *JWTKey) sign(data []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case "RS256":
+		if k.PrivateKey == nil {
+			return nil, fmt.Errorf("jwt key \"%s\" has no private key", k.ID)
+		}
+		sum := sha256.Sum256(data)
+		return k.PrivateKey.Sign(rand.Reader, sum[:], crypto.SHA256)
+	case "ES256":
+		privateKey, ok := k.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("jwt key \"%s\" has no private key", k.ID)
+		}
+		sum := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS 使用定长的 r||s 而不是 ASN.1
+		signature := make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+		return signature, nil
+	}
+	return nil, fmt.Errorf("unknow jwt algorithm \"%s\"", k.Algorithm)
+}
+
+func (k *JWTKey) verify(data []byte, signature []byte) bool {
+	switch k.Algorithm {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(data)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		publicKey, ok := k.publicKey().(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, sum[:], signature) == nil
+	case "ES256":
+		publicKey, ok := k.publicKey().(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(data)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(publicKey, sum[:], r, s)
+	}
+	return false
+}
+
+func (k *JWTKey) publicKey() crypto.PublicKey {
+	if k.PublicKey != nil {
+		return k.PublicKey
+	}
+	if k.PrivateKey != nil {
+		return k.PrivateKey.Public()
+	}
+	return nil
+}
+
+// JWTKeySet 按 kid 选择校验密钥，签名使用当前密钥。
+// 设置 JWKSURL 时遇到未知的 kid 会重新获取，两次获取至少间隔 1 分钟
+type JWTKeySet struct {
+	JWKSURL string
+
+	lock      sync.RWMutex
+	keys      map[string]*JWTKey
+	current   string
+	fetchedAt time.Time
+	// fetched 上次从 JWKSURL 获取的 kid，重新获取时替换
+	fetched map[string]bool
+}
+
+func NewJWTKeySet() *JWTKeySet {
+	return &JWTKeySet{keys: map[string]*JWTKey{}}
+}
+
+// AddKey 添加密钥，第一个添加的密钥作为当前密钥
+func (s *JWTKeySet) AddKey(key *JWTKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys[key.ID] = key
+	if len(s.keys) == 1 {
+		s.current = key.ID
+	}
+}
+
+func (s *JWTKeySet) SetCurrent(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, found := s.keys[id]; !found {
+		return fmt.Errorf("unknow jwt key \"%s\"", id)
+	}
+	s.current = id
+	return nil
+}
+
+func (s *JWTKeySet) key(id string) *JWTKey {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if key, found := s.keys[id]; found {
+		return key
+	}
+	// 没有 kid 时只有一个密钥才能确定
+	if id == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func jwtEncode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func jwtDecode(text string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(text, "="))
+}
+
+// Sign 使用当前密钥签名
+func (s *JWTKeySet) Sign(claims Claims) (string, error) {
+	s.lock.RLock()
+	key := s.keys[s.current]
+	s.lock.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("jwt key set has no current key")
+	}
+	header, err := json.Marshal(map[string]string{"alg": key.Algorithm, "typ": "JWT", "kid": key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := jwtEncode(header) + "." + jwtEncode(payload)
+	signature, err := key.sign([]byte(signing))
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + jwtEncode(signature), nil
+}
+
+// Parse 校验签名并返回内容，不检查有效期
+func (s *JWTKeySet) Parse(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTFormat
+	}
+	headerData, err := jwtDecode(parts[0])
+	if err != nil {
+		return nil, ErrJWTFormat
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(headerData, &header); err != nil {
+		return nil, ErrJWTFormat
+	}
+	signature, err := jwtDecode(parts[2])
+	if err != nil {
+		return nil, ErrJWTFormat
+	}
+
+	key := s.key(header.Kid)
+	if key == nil && s.JWKSURL != "" && s.refetch() {
+		key = s.key(header.Kid)
+	}
+	// 算法必须与密钥一致，避免使用公钥作为 HMAC 密钥等混淆攻击
+	if key == nil || key.Algorithm != header.Alg || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrJWTSignature
+	}
+
+	payload, err := jwtDecode(parts[1])
+	if err != nil {
+		return nil, ErrJWTFormat
+	}
+	claims := Claims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrJWTFormat
+	}
+	return claims, nil
+}
+
+func (s *JWTKeySet) refetch() bool {
+	s.lock.Lock()
+	if time.Since(s.fetchedAt) < time.Minute {
+		s.lock.Unlock()
+		return false
+	}
+	s.fetchedAt = time.Now()
+	s.lock.Unlock()
+	return s.LoadJWKSURL(s.JWKSURL) == nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS 添加 JWK Set 中的 RSA、EC P-256 及 oct 密钥
+func (s *JWTKeySet) LoadJWKS(data []byte) error {
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		s.AddKey(key)
+	}
+	return nil
+}
+
+func parseJWKS(data []byte) ([]*JWTKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := []*JWTKey{}
+	for _, item := range set.Keys {
+		if item.Use != "" && item.Use != "sig" {
+			continue
+		}
+		key := &JWTKey{ID: item.Kid, Algorithm: item.Alg}
+		switch item.Kty {
+		case "RSA":
+			n, err := jwtDecode(item.N)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key \"%s\" error:%s", item.Kid, err.Error())
+			}
+			e, err := jwtDecode(item.E)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key \"%s\" error:%s", item.Kid, err.Error())
+			}
+			key.PublicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if key.Algorithm == "" {
+				key.Algorithm = "RS256"
+			}
+		case "EC":
+			if item.Crv != "P-256" {
+				continue
+			}
+			x, err := jwtDecode(item.X)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key \"%s\" error:%s", item.Kid, err.Error())
+			}
+			y, err := jwtDecode(item.Y)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key \"%s\" error:%s", item.Kid, err.Error())
+			}
+			key.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			key.Algorithm = "ES256"
+		case "oct":
+			secret, err := jwtDecode(item.K)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key \"%s\" error:%s", item.Kid, err.Error())
+			}
+			key.Secret = secret
+			key.Algorithm = "HS256"
+		default:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *JWTKeySet) LoadJWKSFile(filename string) error {
+	buff, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return s.LoadJWKS(buff)
+}
+
+// LoadJWKSURL 获取 JWK Set，替换上次从 URL 获取的密钥，已经移除的 kid 不再能校验
+func (s *JWTKeySet) LoadJWKSURL(url string) error {
+	buff, err := exthttp.DefaultClient.RawRequest("GET", url, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(buff)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id := range s.fetched {
+		delete(s.keys, id)
+	}
+	s.fetched = map[string]bool{}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+		s.fetched[key.ID] = true
+	}
+	if _, found := s.keys[s.current]; !found {
+		s.current = ""
+		if len(s.keys) == 1 {
+			for id := range s.keys {
+				s.current = id
+			}
+		}
+	}
+	return nil
+}
+
+// ParsePEMKey 解析 PEM 格式的 RSA、EC 私钥或公钥，返回对应算法的 JWTKey
+func ParsePEMKey(id string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt key \"%s\" is not pem", id)
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unknow pem type \"%s\"", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &JWTKey{ID: id}
+	switch val := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.PrivateKey = "RS256", val
+	case *rsa.PublicKey:
+		key.Algorithm, key.PublicKey = "RS256", val
+	case *ecdsa.PrivateKey:
+		key.Algorithm, key.PrivateKey = "ES256", val
+	case *ecdsa.PublicKey:
+		key.Algorithm, key.PublicKey = "ES256", val
+	default:
+		return nil, fmt.Errorf("jwt key \"%s\" type not supported", id)
+	}
+	return key, nil
+}
+
+// LoadJWTKeySet 从配置加载密钥：
+//
+//	jwt_secret = "HS256 密钥"
+//	jwt_private_key_file = "RS256/ES256 私钥 PEM"
+//	jwt_public_key_file = "只校验时使用公钥 PEM"
+//	jwt_key_id = "kid"
+//	jwt_jwks_file = "jwks.json"
+//	jwt_jwks_url = "http://127.0.0.1:8081/.well-known/jwks.json"
+//
+// jwt_secret 与 PEM 密钥使用同一个 jwt_key_id，不能同时配置
+func LoadJWTKeySet() (*JWTKeySet, error) {
+	keySet := NewJWTKeySet()
+	id := configs.Settings.GetString(jwtKeyIDSettingKey)
+	secret := configs.Settings.GetString(jwtSecretSettingKey)
+	for _, settingKey := range []string{jwtPrivateKeyFileSettingKey, jwtPublicKeyFileSettingKey} {
+		if filename := configs.Settings.GetString(settingKey); filename != "" {
+			if secret != "" {
+				return nil, fmt.Errorf("%s and %s cannot be used together", jwtSecretSettingKey, settingKey)
+			}
+			buff, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			key, err := ParsePEMKey(id, buff)
+			if err != nil {
+				return nil, err
+			}
+			keySet.AddKey(key)
+			break
+		}
+	}
+	if secret != "" {
+		keySet.AddKey(&JWTKey{ID: id, Algorithm: "HS256", Secret: []byte(secret)})
+	}
+	if filename := configs.Settings.GetString(jwtJWKSFileSettingKey); filename != "" {
+		if err := keySet.LoadJWKSFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	if url := configs.Settings.GetString(jwtJWKSURLSettingKey); url != "" {
+		keySet.JWKSURL = url
+		if err := keySet.LoadJWKSURL(url); err != nil {
+			return nil, err
+		}
+		keySet.fetchedAt = time.Now()
+	}
+	return keySet, nil
+}
+
+var jwtKeySet *JWTKeySet
+var jwtKeySetLock = sync.Mutex{}
+
+// DefaultJWTKeySet 首次调用时从配置加载
+func DefaultJWTKeySet() (*JWTKeySet, error) {
+	jwtKeySetLock.Lock()
+	defer jwtKeySetLock.Unlock()
+	if jwtKeySet == nil {
+		keySet, err := LoadJWTKeySet()
+		if err != nil {
+			return nil, err
+		}
+		jwtKeySet = keySet
+	}
+	return jwtKeySet, nil
+}
+
+func SetDefaultJWTKeySet(keySet *JWTKeySet) {
+	jwtKeySetLock.Lock()
+	defer jwtKeySetLock.Unlock()
+	jwtKeySet = keySet
+}
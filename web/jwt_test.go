@@ -0,0 +1,285 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zhin/go-codex/configs"
+)
+
+func newTestJWTKeys(t *testing.T) []*JWTKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*JWTKey{
+		{ID: "hs", Algorithm: "HS256", Secret: []byte("secret")},
+		{ID: "rs", Algorithm: "RS256", PrivateKey: rsaKey},
+		{ID: "es", Algorithm: "ES256", PrivateKey: ecKey},
+	}
+}
+
+func TestJWTKeySet_SignParse(t *testing.T) {
+	for _, key := range newTestJWTKeys(t) {
+		keySet := NewJWTKeySet()
+		keySet.AddKey(key)
+		token, err := keySet.Sign(Claims{"sub": "u1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := keySet.Parse(token)
+		if err != nil || claims.Subject() != "u1" {
+			t.Fatalf("%s: unexpected claims %v %v", key.Algorithm, claims, err)
+		}
+
+		// 只有公钥时可以校验
+		if key.PrivateKey != nil {
+			verifySet := NewJWTKeySet()
+			verifySet.AddKey(&JWTKey{ID: key.ID, Algorithm: key.Algorithm, PublicKey: key.PrivateKey.Public()})
+			if _, err := verifySet.Parse(token); err != nil {
+				t.Fatalf("%s: public key should verify, got %v", key.Algorithm, err)
+			}
+		}
+
+		parts := strings.Split(token, ".")
+		payload, _ := json.Marshal(Claims{"sub": "admin"})
+		if _, err := keySet.Parse(parts[0] + "." + jwtEncode(payload) + "." + parts[2]); err != ErrJWTSignature {
+			t.Fatalf("%s: modified payload should be rejected, got %v", key.Algorithm, err)
+		}
+	}
+}
+
+func TestJWTKeySet_AlgorithmConfusion(t *testing.T) {
+	rsaKey := newTestJWTKeys(t)[1]
+	publicDER, _ := x509.MarshalPKIXPublicKey(rsaKey.PrivateKey.Public())
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	verifySet := NewJWTKeySet()
+	verifySet.AddKey(&JWTKey{ID: "rs", Algorithm: "RS256", PublicKey: rsaKey.PrivateKey.Public()})
+
+	// 使用公钥作为 HMAC 密钥签名，并声明 alg 为 HS256
+	forged := NewJWTKeySet()
+	forged.AddKey(&JWTKey{ID: "rs", Algorithm: "HS256", Secret: publicPEM})
+	token, _ := forged.Sign(Claims{"sub": "admin"})
+	if _, err := verifySet.Parse(token); err != ErrJWTSignature {
+		t.Fatalf("alg confusion should be rejected, got %v", err)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rs"})
+	payload, _ := json.Marshal(Claims{"sub": "admin"})
+	if _, err := verifySet.Parse(jwtEncode(header) + "." + jwtEncode(payload) + "."); err != ErrJWTSignature {
+		t.Fatalf("alg none should be rejected, got %v", err)
+	}
+}
+
+func TestLoadJWTKeySet_Conflict(t *testing.T) {
+	ecKey := newTestJWTKeys(t)[2]
+	der, _ := x509.MarshalECPrivateKey(ecKey.PrivateKey.(*ecdsa.PrivateKey))
+	file, err := ioutil.TempFile("", "jwt*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	file.Close()
+
+	configs.Settings.Set(jwtPrivateKeyFileSettingKey, file.Name())
+	defer configs.Settings.Set(jwtPrivateKeyFileSettingKey, "")
+	keySet, err := LoadJWTKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := keySet.Sign(Claims{"sub": "u1"}); err != nil || !strings.HasPrefix(token, jwtEncode([]byte(`{"alg":"ES256"`))) {
+		t.Fatalf("unexpected token %s %v", token, err)
+	}
+
+	configs.Settings.Set(jwtSecretSettingKey, "secret")
+	defer configs.Settings.Set(jwtSecretSettingKey, "")
+	if _, err := LoadJWTKeySet(); err == nil {
+		t.Fatal("jwt_secret and pem key should not be used together")
+	}
+}
+
+func newTestJWTAuth(t *testing.T) *JWTAuth {
+	keySet := NewJWTKeySet()
+	keySet.AddKey(newTestJWTKeys(t)[0])
+	return &JWTAuth{KeySet: keySet, Store: NewMemoryJWTStore(), Issuer: "codex", AccessTTL: time.Minute, RefreshTTL: time.Hour}
+}
+
+func TestJWTAuth_Verify(t *testing.T) {
+	auth := newTestJWTAuth(t)
+	pair, err := auth.Issue("u1", Claims{"roles": []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.Verify(pair.AccessToken)
+	if err != nil || claims.Subject() != "u1" || claims.Roles()[0] != "admin" {
+		t.Fatalf("unexpected claims %v %v", claims, err)
+	}
+
+	noExp, _ := auth.KeySet.Sign(Claims{"sub": "u1", "iss": "codex"})
+	if _, err := auth.Verify(noExp); err != ErrJWTClaims {
+		t.Fatalf("token without exp should be rejected, got %v", err)
+	}
+	expired, _ := auth.KeySet.Sign(Claims{"sub": "u1", "iss": "codex", "exp": time.Now().Add(-time.Hour).Unix()})
+	if _, err := auth.Verify(expired); err != ErrJWTExpired {
+		t.Fatalf("expired token should be rejected, got %v", err)
+	}
+	otherIssuer, _ := auth.KeySet.Sign(Claims{"sub": "u1", "iss": "other", "exp": time.Now().Add(time.Hour).Unix()})
+	if _, err := auth.Verify(otherIssuer); err != ErrJWTClaims {
+		t.Fatalf("token of other issuer should be rejected, got %v", err)
+	}
+
+	if err := auth.Revoke(claims); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Verify(pair.AccessToken); err != ErrJWTRevoked {
+		t.Fatalf("revoked token should be rejected, got %v", err)
+	}
+}
+
+func TestJWTAuth_Refresh(t *testing.T) {
+	auth := newTestJWTAuth(t)
+	first, err := auth.Issue("u1", Claims{"roles": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := auth.Refresh(first.RefreshToken)
+	if err != nil || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token should rotate, got %v", err)
+	}
+	claims, err := auth.Verify(second.AccessToken)
+	if err != nil || claims.Subject() != "u1" || claims.Roles()[0] != "admin" {
+		t.Fatalf("unexpected refreshed claims %v %v", claims, err)
+	}
+
+	// 已使用的刷新令牌再次出现时撤销整个 family
+	if _, err := auth.Refresh(first.RefreshToken); err != ErrJWTRevoked {
+		t.Fatalf("reused refresh token should be rejected, got %v", err)
+	}
+	if _, err := auth.Refresh(second.RefreshToken); err != ErrJWTRevoked {
+		t.Fatalf("family should be revoked, got %v", err)
+	}
+	if _, err := auth.Verify(first.AccessToken); err != ErrJWTRevoked {
+		t.Fatalf("access token of revoked family should be rejected, got %v", err)
+	}
+	if _, err := auth.Refresh("unknow"); err != ErrJWTExpired {
+		t.Fatalf("unknow refresh token should be rejected, got %v", err)
+	}
+}
+
+func TestJWTAuth_RefreshClaims(t *testing.T) {
+	auth := newTestJWTAuth(t)
+	roles := "admin"
+	auth.RefreshClaims = func(subject string, claims Claims) (Claims, error) {
+		if roles == "" {
+			return nil, fmt.Errorf("user \"%s\" disabled", subject)
+		}
+		claims["roles"] = roles
+		return claims, nil
+	}
+	pair, err := auth.Issue("u1", Claims{"roles": "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 刷新时使用最新的角色
+	roles = "viewer"
+	if pair, err = auth.Refresh(pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.Verify(pair.AccessToken)
+	if err != nil || strings.Join(claims.Roles(), ",") != "viewer" {
+		t.Fatalf("refreshed token should have the reloaded roles, got %v %v", claims, err)
+	}
+	roles = ""
+	if _, err := auth.Refresh(pair.RefreshToken); err == nil {
+		t.Fatal("refresh should fail when claims cannot be reloaded")
+	}
+}
+
+func TestJWTKeySet_JWKSURL(t *testing.T) {
+	var kid atomic.Value
+	kid.Store("k1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := kid.Load().(string)
+		fmt.Fprintf(w, `{"keys":[{"kty":"oct","kid":"%s","k":"%s"}]}`, id, jwtEncode([]byte("secret "+id)))
+	}))
+	defer server.Close()
+	sign := func(id string) string {
+		keySet := NewJWTKeySet()
+		keySet.AddKey(&JWTKey{ID: id, Algorithm: "HS256", Secret: []byte("secret " + id)})
+		token, err := keySet.Sign(Claims{"sub": id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	keySet := NewJWTKeySet()
+	keySet.AddKey(&JWTKey{ID: "local", Algorithm: "HS256", Secret: []byte("secret local")})
+	keySet.JWKSURL = server.URL
+	if err := keySet.LoadJWKSURL(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keySet.Parse(sign("k1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未知的 kid 触发重新获取，新的密钥集替换之前获取的密钥，本地密钥保留
+	kid.Store("k2")
+	if _, err := keySet.Parse(sign("k2")); err != nil {
+		t.Fatalf("unknow kid should refetch, got %v", err)
+	}
+	if _, err := keySet.Parse(sign("k1")); err != ErrJWTSignature {
+		t.Fatalf("removed key should be rejected, got %v", err)
+	}
+	if _, err := keySet.Parse(sign("local")); err != nil {
+		t.Fatalf("local key should be kept, got %v", err)
+	}
+}
+
+func TestMemoryJWTStore_Sweep(t *testing.T) {
+	store := NewMemoryJWTStore().(*memoryJWTStore)
+	store.SaveRefresh("expired", []byte("1"), -time.Second)
+	store.Revoke("expired", -time.Second)
+	store.SaveRefresh("valid", []byte("1"), time.Hour)
+	store.swept = time.Time{}
+	store.Revoke("valid", time.Hour)
+	if len(store.refresh) != 1 || len(store.revoked) != 1 {
+		t.Fatalf("expired entries should be swept, got %d %d", len(store.refresh), len(store.revoked))
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	configs.Settings.Set(rbacRolesSettingKey, map[string]interface{}{"Operator": []string{"orders:*"}})
+	defer configs.Settings.Set(rbacRolesSettingKey, nil)
+
+	rbac := NewRBAC()
+	rbac.LoadFromConfig()
+	rbac.SetRole("Admin", "*")
+	if !rbac.Allowed([]string{"OPERATOR"}, "orders:write") || rbac.Allowed([]string{"operator"}, "users:write") {
+		t.Fatal("role names should be case insensitive")
+	}
+	if !rbac.Allowed([]string{"admin"}, "users:write") || rbac.Allowed(nil, "orders:read") {
+		t.Fatal("unexpected admin permission")
+	}
+}
@@ -0,0 +1,255 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/metrics"
+)
+
+const webMetricsRequireAdminSettingKey = "web_metrics_require_admin"
+
+// unmatchedRoute 没有匹配路由的请求使用该值作为 route 标签，避免按路径产生大量指标
+const unmatchedRoute = "unmatched"
+
+// metricsBodyLimit 读取 JSONResult.Code 时最多缓存的响应内容
+const metricsBodyLimit = 64 * 1024
+
+func init() {
+	configs.Settings.SetDefault(webMetricsRequireAdminSettingKey, false)
+}
+
+// MetricsOptions Registry 为空时使用 metrics.Default()
+type MetricsOptions struct {
+	Registry *metrics.Registry
+	// SkipPaths 不记录的路由，如 /metrics、/healthz
+	SkipPaths []string
+}
+
+// RequestMetrics 按路由模板记录请求，需要在注册路由之前 Use，engine 为空时使用 Default：
+//
+//	http_requests_total{method,route,status,code}
+//	http_request_duration_seconds{method,route,status}
+//	http_requests_in_flight
+//
+// code 为响应中 JSONResult 的 Code，非 JSON 响应为空
+func RequestMetrics(engine *gin.Engine, opt MetricsOptions) gin.HandlerFunc {
+	if engine == nil {
+		engine = Default
+	}
+	registry := opt.Registry
+	if registry == nil {
+		registry = metrics.Default()
+	}
+	requests := registry.Counter("http_requests_total", "Total HTTP requests handled.", "method", "route", "status", "code")
+	duration := registry.Histogram("http_request_duration_seconds", "Latency of HTTP requests.", nil, "method", "route", "status")
+	inFlight := registry.Gauge("http_requests_in_flight", "HTTP requests being handled.").With()
+	skip := map[string]bool{}
+	for _, item := range opt.SkipPaths {
+		skip[item] = true
+	}
+	matcher := &routeMatcher{engine: engine}
+
+	return func(c *gin.Context) {
+		route := matcher.match(c.Request.Method, c.Request.URL.Path)
+		if skip[route] {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		inFlight.Inc()
+		writer := &metricsResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer func() {
+			c.Writer = writer.ResponseWriter
+			inFlight.Dec()
+			status := strconv.Itoa(writer.Status())
+			// 外层的 Recovery 恢复 panic 后返回 500，记录后继续 panic
+			err := recover()
+			if err != nil {
+				status = strconv.Itoa(http.StatusInternalServerError)
+			}
+			requests.With(c.Request.Method, route, status, writer.code()).Inc()
+			duration.With(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+			if err != nil {
+				panic(err)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// RegisterMetrics 注册 /metrics，以 Prometheus 文本格式输出 registry（为空时使用 metrics.Default()）。
+// 配置 web_metrics_require_admin 为 true 时需要管理令牌；
+// database.UseMetrics、exthttp 的 UseMetrics 默认写入同一个 registry
+func RegisterMetrics(group gin.IRouter, registry *metrics.Registry) {
+	group.GET("/metrics", func(c *gin.Context) {
+		if configs.Settings.GetBool(webMetricsRequireAdminSettingKey) && !isAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, NewJSONResult().Error(cerror.FORBIDDEN, "没有权限"))
+			return
+		}
+		current := registry
+		if current == nil {
+			current = metrics.Default()
+		}
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		current.WriteText(c.Writer)
+	})
+}
+
+// routeMatcher gin 1.3 的 Context 中没有匹配到的路由模板，按 engine.Routes() 重新匹配。
+// 找不到时最多每秒重新读取一次路由
+type routeMatcher struct {
+	engine *gin.Engine
+
+	lock     sync.RWMutex
+	routes   map[string][]routePattern
+	loadedAt time.Time
+}
+
+type routePattern struct {
+	path     string
+	segments []string
+	// rank 每段静态为 0、参数为 1、通配为 2，多个模板匹配时取最小的，与 httprouter 静态优先一致
+	rank string
+}
+
+func (m *routeMatcher) match(method string, urlPath string) string {
+	m.lock.RLock()
+	route, found := matchRoute(m.routes[method], urlPath)
+	loadedAt := m.loadedAt
+	m.lock.RUnlock()
+	if found || time.Since(loadedAt) < time.Second {
+		return route
+	}
+
+	m.lock.Lock()
+	if time.Since(m.loadedAt) >= time.Second {
+		m.routes = map[string][]routePattern{}
+		for _, info := range m.engine.Routes() {
+			m.routes[info.Method] = append(m.routes[info.Method], newRoutePattern(info.Path))
+		}
+		m.loadedAt = time.Now()
+	}
+	route, _ = matchRoute(m.routes[method], urlPath)
+	m.lock.Unlock()
+	return route
+}
+
+func newRoutePattern(routePath string) routePattern {
+	segments := strings.Split(strings.TrimPrefix(routePath, "/"), "/")
+	rank := make([]byte, len(segments))
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			rank[i] = '1'
+		case strings.HasPrefix(segment, "*"):
+			rank[i] = '2'
+		default:
+			rank[i] = '0'
+		}
+	}
+	return routePattern{path: routePath, segments: segments, rank: string(rank)}
+}
+
+func matchRoute(patterns []routePattern, urlPath string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+	var best *routePattern
+	for i := range patterns {
+		if patterns[i].match(parts) && (best == nil || patterns[i].rank < best.rank) {
+			best = &patterns[i]
+		}
+	}
+	if best == nil {
+		return unmatchedRoute, false
+	}
+	return best.path, true
+}
+
+func (p routePattern) match(parts []string) bool {
+	for i, segment := range p.segments {
+		if strings.HasPrefix(segment, "*") {
+			return true
+		}
+		if i >= len(parts) {
+			return false
+		}
+		if strings.HasPrefix(segment, ":") {
+			if parts[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != parts[i] {
+			return false
+		}
+	}
+	return len(parts) == len(p.segments)
+}
+
+// metricsResponseWriter 缓存 JSON 响应的开头部分，用于读取 JSONResult.Code
+type metricsResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *metricsResponseWriter) capture(data []byte) {
+	if w.body.Len() >= metricsBodyLimit || !strings.Contains(w.Header().Get("Content-Type"), "json") {
+		return
+	}
+	if remain := metricsBodyLimit - w.body.Len(); len(data) > remain {
+		data = data[:remain]
+	}
+	w.body.Write(data)
+}
+
+func (w *metricsResponseWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *metricsResponseWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// code 只读取顶层的 codeField，MarshalJSON 按字段名排序输出，code 通常在 data 之前
+func (w *metricsResponseWriter) code() string {
+	if w.body.Len() == 0 {
+		return ""
+	}
+	decoder := json.NewDecoder(bytes.NewReader(w.body.Bytes()))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return ""
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if key == codeField {
+			value := json.Number("")
+			if err := decoder.Decode(&value); err != nil {
+				return ""
+			}
+			return value.String()
+		}
+		skipped := json.RawMessage{}
+		if err := decoder.Decode(&skipped); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/metrics"
+)
+
+func TestRequestMetrics_Panic(t *testing.T) {
+	registry := metrics.NewRegistry()
+	engine := gin.New()
+	engine.Use(gin.RecoveryWithWriter(ioutil.Discard))
+	engine.Use(RequestMetrics(engine, MetricsOptions{Registry: registry}))
+	engine.GET("/users/:id", func(c *gin.Context) {
+		if c.Param("id") == "0" {
+			panic("boom")
+		}
+		c.JSON(http.StatusOK, NewJSONResult().Success())
+	})
+
+	for _, path := range []string{"/users/0", "/users/1"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	buff := &bytes.Buffer{}
+	registry.WriteText(buff)
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/users/:id",status="500",code=""} 1`,
+		`http_requests_total{method="GET",route="/users/:id",status="200",code="0"} 1`,
+	} {
+		if !strings.Contains(buff.String(), line) {
+			t.Errorf("missing %s in:\n%s", line, buff.String())
+		}
+	}
+}
@@ -0,0 +1,552 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+)
+
+const (
+	webOpenAPITitleSettingKey   = "web_openapi_title"
+	webOpenAPIVersionSettingKey = "web_openapi_version"
+	webOpenAPIUIDirSettingKey   = "web_openapi_ui_dir"
+)
+
+func init() {
+	configs.Settings.SetDefault(webOpenAPITitleSettingKey, "API")
+	configs.Settings.SetDefault(webOpenAPIVersionSettingKey, "1.0.0")
+}
+
+// APIParam 额外的参数，In 为 query、header 或 path
+type APIParam struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Type 为 string、integer、number 或 boolean，默认 string
+	Type string
+}
+
+// APIDoc 路由的文档
+type APIDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	OperationID string
+	// Request 请求结构体，GET、DELETE 生成查询参数，其他方法生成 JSON 内容，与路径参数同名的字段作为路径参数
+	Request interface{}
+	// Response JSONResult 中 data 的类型
+	Response interface{}
+	// Errors 可能返回的错误代码，如 cerror.DB_NOT_FOUND，参数错误及服务器错误会自动添加
+	Errors []int
+	Params []APIParam
+	// Auth 需要 JWT 令牌
+	Auth       bool
+	Deprecated bool
+}
+
+var apiDocs = map[string]*APIDoc{}
+var apiDocsLock = sync.RWMutex{}
+
+var codeDescriptions = map[int]string{
+	1:                        "服务器错误",
+	22:                       "参数错误",
+	cerror.DB_ERROR:          "数据库错误",
+	cerror.DB_NOT_FOUND:      "记录不存在",
+	cerror.HTTP_ERROR:        "上游服务错误",
+	cerror.UNAUTHORIZED:      "未登录",
+	cerror.FORBIDDEN:         "没有权限",
+	cerror.TOO_MANY_REQUESTS: "请求过于频繁",
+}
+
+// SetCodeDescription 设置错误代码在文档中的说明
+func SetCodeDescription(code int, description string) {
+	apiDocsLock.Lock()
+	defer apiDocsLock.Unlock()
+	codeDescriptions[code] = description
+}
+
+// Doc 设置路由的文档，path 为完整路径，如 /api/users/:id
+func Doc(method string, path string, doc APIDoc) {
+	apiDocsLock.Lock()
+	defer apiDocsLock.Unlock()
+	apiDocs[strings.ToUpper(method)+" "+path] = &doc
+}
+
+// Route 注册路由并设置文档
+func Route(group gin.IRouter, method string, relativePath string, doc APIDoc, handlers ...gin.HandlerFunc) {
+	group.Handle(strings.ToUpper(method), relativePath, handlers...)
+	Doc(method, fullPath(group, relativePath), doc)
+}
+
+// fullPath 与 gin 合并分组路径的规则相同
+func fullPath(group gin.IRouter, relativePath string) string {
+	base := "/"
+	if val, ok := group.(interface{ BasePath() string }); ok {
+		base = val.BasePath()
+	}
+	if relativePath == "" {
+		return base
+	}
+	result := path.Join(base, relativePath)
+	if strings.HasSuffix(relativePath, "/") && !strings.HasSuffix(result, "/") {
+		return result + "/"
+	}
+	return result
+}
+
+// OpenAPIOptions 为空的字段使用配置中的值
+type OpenAPIOptions struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+	// JSONPath 默认 /openapi.json
+	JSONPath string
+	// UIPath 文档页面，默认 /docs
+	UIPath string
+	// UIDir swagger-ui-dist 的本地目录，优先于 UIFiles
+	UIDir string
+	// UIFiles swagger-ui-dist 的文件，如 swaggerui.Assets()，UIDir 及 UIFiles 都为空时使用内置的简单页面
+	UIFiles http.FileSystem
+}
+
+func (option *OpenAPIOptions) defaults() {
+	if option.Title == "" {
+		option.Title = configs.Settings.GetString(webOpenAPITitleSettingKey)
+	}
+	if option.Version == "" {
+		option.Version = configs.Settings.GetString(webOpenAPIVersionSettingKey)
+	}
+	if option.JSONPath == "" {
+		option.JSONPath = "/openapi.json"
+	}
+	if option.UIPath == "" {
+		option.UIPath = "/docs"
+	}
+	if option.UIDir == "" {
+		option.UIDir = configs.Settings.GetString(webOpenAPIUIDirSettingKey)
+	}
+}
+
+// RegisterOpenAPI 注册 JSONPath 及 UIPath，文档在每次请求时按 engine 中的路由生成
+func RegisterOpenAPI(engine *gin.Engine, option OpenAPIOptions) {
+	option.defaults()
+	engine.GET(option.JSONPath, func(c *gin.Context) {
+		c.JSON(http.StatusOK, OpenAPI(engine, option))
+	})
+
+	assets := strings.TrimRight(option.UIPath, "/") + "/assets"
+	if option.UIDir != "" {
+		engine.Static(assets, option.UIDir)
+	} else if option.UIFiles != nil {
+		engine.StaticFS(assets, option.UIFiles)
+	} else {
+		assets = ""
+	}
+	engine.GET(option.UIPath, func(c *gin.Context) {
+		buff := &bytes.Buffer{}
+		if err := openAPIPage.Execute(buff, map[string]string{"Title": option.Title, "URL": option.JSONPath, "Assets": assets}); err != nil {
+			AbortWithError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", buff.Bytes())
+	})
+}
+
+// OpenAPI 按 engine 中的路由及 Doc 设置的文档生成 OpenAPI 3 文档，没有文档的路由只包含路径参数及默认响应
+func OpenAPI(engine *gin.Engine, option OpenAPIOptions) map[string]interface{} {
+	option.defaults()
+	generator := &openAPIGenerator{schemas: map[string]interface{}{}, names: map[reflect.Type]string{}}
+
+	apiDocsLock.RLock()
+	docs := map[string]*APIDoc{}
+	for key, doc := range apiDocs {
+		docs[key] = doc
+	}
+	descriptions := map[int]string{}
+	for code, description := range codeDescriptions {
+		descriptions[code] = description
+	}
+	apiDocsLock.RUnlock()
+	generator.descriptions = descriptions
+
+	paths := map[string]map[string]interface{}{}
+	auth := false
+	for _, route := range engine.Routes() {
+		if route.Path == option.JSONPath || route.Path == option.UIPath || strings.HasPrefix(route.Path, strings.TrimRight(option.UIPath, "/")+"/assets/") {
+			continue
+		}
+		doc := docs[route.Method+" "+route.Path]
+		if doc == nil {
+			doc = &APIDoc{}
+		}
+		auth = auth || doc.Auth
+		openAPIPath, params := openAPIPathOf(route.Path)
+		if paths[openAPIPath] == nil {
+			paths[openAPIPath] = map[string]interface{}{}
+		}
+		paths[openAPIPath][strings.ToLower(route.Method)] = generator.operation(route.Method, route.Path, params, doc)
+	}
+
+	generator.schemas["JSONResult"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			codeField:  map[string]interface{}{"type": "integer"},
+			msgField:   map[string]interface{}{"type": "string"},
+			dataField:  map[string]interface{}{},
+			errIDField: map[string]interface{}{"type": "string", "description": "错误 ID，用于查找日志"},
+		},
+		"required": []string{codeField, msgField},
+	}
+	components := map[string]interface{}{"schemas": generator.schemas}
+	if auth {
+		components["securitySchemes"] = map[string]interface{}{
+			"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		}
+	}
+	info := map[string]interface{}{"title": option.Title, "version": option.Version}
+	if option.Description != "" {
+		info["description"] = option.Description
+	}
+	document := map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      paths,
+		"components": components,
+	}
+	if len(option.Servers) > 0 {
+		servers := []map[string]interface{}{}
+		for _, server := range option.Servers {
+			servers = append(servers, map[string]interface{}{"url": server})
+		}
+		document["servers"] = servers
+	}
+	return document
+}
+
+// openAPIPathOf 将 /users/:id 转换为 /users/{id}，返回路径参数
+func openAPIPathOf(routePath string) (string, []string) {
+	params := []string{}
+	parts := strings.Split(routePath, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+type openAPIGenerator struct {
+	schemas      map[string]interface{}
+	names        map[reflect.Type]string
+	descriptions map[int]string
+}
+
+func (g *openAPIGenerator) operation(method string, routePath string, pathParams []string, doc *APIDoc) map[string]interface{} {
+	operation := map[string]interface{}{}
+	if doc.Summary != "" {
+		operation["summary"] = doc.Summary
+	}
+	if doc.Description != "" {
+		operation["description"] = doc.Description
+	}
+	if len(doc.Tags) > 0 {
+		operation["tags"] = doc.Tags
+	}
+	if doc.Deprecated {
+		operation["deprecated"] = true
+	}
+	operationID := doc.OperationID
+	if operationID == "" {
+		operationID = strings.ToLower(method) + strings.NewReplacer("/", "_", ":", "", "*", "", "-", "_", ".", "_").Replace(strings.TrimRight(routePath, "/"))
+	}
+	operation["operationId"] = operationID
+
+	// 路径参数使用请求结构体中同名字段的类型
+	var requestType reflect.Type
+	fields := map[string]reflect.StructField{}
+	if doc.Request != nil {
+		requestType = reflect.TypeOf(doc.Request)
+		for requestType.Kind() == reflect.Ptr {
+			requestType = requestType.Elem()
+		}
+		if requestType.Kind() == reflect.Struct {
+			collectFields(requestType, "", fields)
+		}
+	}
+	parameters := []interface{}{}
+	for _, name := range pathParams {
+		schema := map[string]interface{}{"type": "string"}
+		if field, found := fields[name]; found {
+			schema = g.schema(field.Type)
+			delete(fields, name)
+		}
+		parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true, "schema": schema})
+	}
+
+	bodyMethod := method != http.MethodGet && method != http.MethodDelete && method != http.MethodHead
+	if requestType != nil && !bodyMethod {
+		names := []string{}
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			parameter := map[string]interface{}{"name": name, "in": "query", "schema": g.schema(fields[name].Type)}
+			if hasRule(fields[name], "required") {
+				parameter["required"] = true
+			}
+			parameters = append(parameters, parameter)
+		}
+	}
+	for _, param := range doc.Params {
+		paramType := param.Type
+		if paramType == "" {
+			paramType = "string"
+		}
+		parameter := map[string]interface{}{"name": param.Name, "in": param.In, "schema": map[string]interface{}{"type": paramType}}
+		if param.Description != "" {
+			parameter["description"] = param.Description
+		}
+		if param.Required || param.In == "path" {
+			parameter["required"] = true
+		}
+		parameters = append(parameters, parameter)
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if requestType != nil && bodyMethod {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(requestType)},
+			},
+		}
+	}
+
+	var data interface{} = map[string]interface{}{}
+	if doc.Response != nil {
+		data = g.schema(reflect.TypeOf(doc.Response))
+	}
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "OK",
+			"content":     openAPIEnvelope(map[string]interface{}{dataField: data}),
+		},
+	}
+
+	codes := append([]int{1}, doc.Errors...)
+	if requestType != nil || len(pathParams) > 0 || len(doc.Params) > 0 {
+		codes = append(codes, 22)
+	}
+	if doc.Auth {
+		codes = append(codes, cerror.UNAUTHORIZED)
+		operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+	}
+	statusCodes := map[int][]int{}
+	for _, code := range codes {
+		status := http.StatusInternalServerError
+		if code != 1 {
+			status = statusOfCode(code)
+		}
+		if !containsCode(statusCodes[status], code) {
+			statusCodes[status] = append(statusCodes[status], code)
+		}
+	}
+	for status, items := range statusCodes {
+		sort.Ints(items)
+		descriptions := []string{}
+		enum := []interface{}{}
+		for _, code := range items {
+			descriptions = append(descriptions, fmt.Sprintf("%d %s", code, g.descriptions[code]))
+			enum = append(enum, code)
+		}
+		responses[fmt.Sprint(status)] = map[string]interface{}{
+			"description": strings.Join(descriptions, "; "),
+			"content":     openAPIEnvelope(map[string]interface{}{codeField: map[string]interface{}{"type": "integer", "enum": enum}}),
+		}
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+func containsCode(codes []int, code int) bool {
+	for _, item := range codes {
+		if item == code {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPIEnvelope 在 JSONResult 的基础上覆盖部分字段
+func openAPIEnvelope(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{
+				"allOf": []interface{}{
+					map[string]interface{}{"$ref": "#/components/schemas/JSONResult"},
+					map[string]interface{}{"type": "object", "properties": properties},
+				},
+			},
+		},
+	}
+}
+
+// collectFields 按 Bind 的规则收集表单字段，嵌套的结构体使用 a.b 形式的名称
+func collectFields(valueType reflect.Type, prefix string, fields map[string]reflect.StructField) {
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := fieldName(field)
+		if name == "-" {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+			if field.Anonymous {
+				collectFields(fieldType, prefix, fields)
+			} else {
+				collectFields(fieldType, prefix+name+".", fields)
+			}
+			continue
+		}
+		fields[prefix+name] = field
+	}
+}
+
+func bindingRules(field reflect.StructField) []string {
+	return strings.Split(field.Tag.Get("binding"), ",")
+}
+
+func hasRule(field reflect.StructField, rule string) bool {
+	for _, item := range bindingRules(field) {
+		if item == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *openAPIGenerator) schema(valueType reflect.Type) map[string]interface{} {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	if valueType == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch valueType.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if valueType.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(valueType.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(valueType.Elem())}
+	case reflect.Struct:
+		if valueType.Name() == "" {
+			return g.object(valueType)
+		}
+		name, found := g.names[valueType]
+		if !found {
+			name = valueType.Name()
+			if _, exists := g.schemas[name]; exists {
+				name = path.Base(valueType.PkgPath()) + "." + name
+			}
+			g.names[valueType] = name
+			// 先占位，处理递归引用的类型
+			g.schemas[name] = map[string]interface{}{}
+			g.schemas[name] = g.object(valueType)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *openAPIGenerator) object(valueType reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	g.properties(valueType, properties, &required)
+	result := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		result["required"] = required
+	}
+	return result
+}
+
+func (g *openAPIGenerator) properties(valueType reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			g.properties(fieldType, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := g.schema(field.Type)
+		for _, rule := range bindingRules(field) {
+			if rule == "required" {
+				*required = append(*required, name)
+			} else if strings.HasPrefix(rule, "enum=") && schema["$ref"] == nil {
+				enumSetsLock.RLock()
+				set := enumSets[rule[len("enum="):]]
+				enumSetsLock.RUnlock()
+				if set != nil {
+					values := []interface{}{}
+					for _, item := range set.GetData() {
+						values = append(values, item.Value)
+					}
+					schema["enum"] = values
+				}
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+// openAPIPage 文档页面，设置 Assets 时使用 Swagger UI，否则使用内置的简单页面
+var openAPIPage = template.Must(template.New("openapi").Parse(openAPIPageTemplate))
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/web/swaggerui"
+)
+
+func TestRegisterOpenAPI_SwaggerUI(t *testing.T) {
+	engine := gin.New()
+	RegisterOpenAPI(engine, OpenAPIOptions{Title: "demo", UIFiles: swaggerui.Assets()})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	page := get("/docs")
+	body := page.Body.String()
+	if page.Code != http.StatusOK || !strings.Contains(body, `src="/docs/assets/swagger-ui-bundle.js"`) || !strings.Contains(body, "validatorUrl: null") {
+		t.Fatalf("unexpected page %d %s", page.Code, body)
+	}
+	// 页面只引用本地资源
+	if strings.Contains(body, `src="http`) || strings.Contains(body, `href="http`) {
+		t.Fatalf("page should not reference external resources: %s", body)
+	}
+	for _, name := range []string{"swagger-ui-bundle.js", "swagger-ui.css", "favicon-32x32.png"} {
+		if w := get("/docs/assets/" + name); w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s: got %d", name, w.Code)
+		}
+	}
+	if w := get("/openapi.json"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"openapi"`) {
+		t.Fatalf("unexpected document %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegisterOpenAPI_UIDir(t *testing.T) {
+	dir := t.TempDir()
+	engine := gin.New()
+	RegisterOpenAPI(engine, OpenAPIOptions{UIPath: "/swagger", UIDir: dir})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/swagger/assets/swagger-ui-bundle.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("assets should be served from UIDir, got %d", w.Code)
+	}
+}
+
+func TestRegisterOpenAPI_Builtin(t *testing.T) {
+	engine := gin.New()
+	RegisterOpenAPI(engine, OpenAPIOptions{Title: "demo"})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "swagger-ui-bundle.js") || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected page %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/assets/swagger-ui-bundle.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("assets should not be served without UIFiles, got %d", w.Code)
+	}
+}
@@ -0,0 +1,145 @@
+package web
+
+// openAPIPageTemplate 不引用外部资源，Assets 为 swagger-ui-dist 的路径
+const openAPIPageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{if .Assets}}<link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+<link rel="icon" type="image/png" href="{{.Assets}}/favicon-32x32.png" sizes="32x32">
+{{else}}<style>
+body{font-family:-apple-system,"Segoe UI",Helvetica,Arial,sans-serif;margin:0;background:#fafafa;color:#333}
+header{background:#1b1b1b;color:#fff;padding:14px 24px}
+header h1{margin:0;font-size:20px}
+header input{margin-left:16px;width:320px;padding:4px}
+main{max-width:1100px;margin:0 auto;padding:16px}
+details{background:#fff;border:1px solid #ddd;border-radius:4px;margin:8px 0}
+summary{cursor:pointer;padding:8px 12px;font-family:monospace;font-size:14px}
+.method{display:inline-block;min-width:64px;text-align:center;color:#fff;border-radius:3px;padding:2px 6px;margin-right:8px;font-weight:bold}
+.get{background:#61affe}.post{background:#49cc90}.put{background:#fca130}.patch{background:#50e3c2}.delete{background:#f93e3e}.head,.options{background:#9012fe}
+.body{padding:8px 16px;border-top:1px solid #eee}
+table{border-collapse:collapse;width:100%;font-size:13px}
+td,th{border-bottom:1px solid #eee;text-align:left;padding:4px}
+pre{background:#f4f4f4;padding:8px;overflow:auto;font-size:12px}
+textarea{width:100%;height:120px;font-family:monospace}
+.deprecated{text-decoration:line-through}
+.tag{font-size:18px;margin:20px 0 4px}
+</style>
+{{end}}</head>
+<body>
+{{if .Assets}}<div id="swagger-ui"></div>
+<script src="{{.Assets}}/swagger-ui-bundle.js"></script>
+<script>
+window.ui = SwaggerUIBundle({url: {{.URL}}, dom_id: "#swagger-ui", deepLinking: true, validatorUrl: null});
+</script>
+{{else}}<header><h1 id="title">{{.Title}}</h1><label>Bearer <input id="token" placeholder="JWT"></label></header>
+<main id="main"></main>
+<script>
+(function () {
+  var spec;
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    for (var key in attrs || {}) {
+      if (key === "text") { node.textContent = attrs[key]; } else { node.setAttribute(key, attrs[key]); }
+    }
+    (children || []).forEach(function (child) { if (child) { node.appendChild(child); } });
+    return node;
+  }
+  function resolve(schema) {
+    var seen = 0;
+    while (schema && schema.$ref && seen++ < 32) {
+      schema = spec.components.schemas[schema.$ref.replace("#/components/schemas/", "")];
+    }
+    return schema || {};
+  }
+  function sample(schema, depth) {
+    schema = resolve(schema);
+    if (depth > 6) { return null; }
+    if (schema.allOf) {
+      var merged = {};
+      schema.allOf.forEach(function (item) {
+        var value = sample(item, depth + 1);
+        if (value && typeof value === "object") { for (var key in value) { merged[key] = value[key]; } }
+      });
+      return merged;
+    }
+    if (schema.enum) { return schema.enum[0]; }
+    switch (schema.type) {
+    case "object":
+      var result = {};
+      for (var name in schema.properties || {}) { result[name] = sample(schema.properties[name], depth + 1); }
+      if (schema.additionalProperties) { result.key = sample(schema.additionalProperties, depth + 1); }
+      return result;
+    case "array": return [sample(schema.items, depth + 1)];
+    case "integer": case "number": return 0;
+    case "boolean": return false;
+    case "string": return schema.format === "date-time" ? new Date().toISOString() : "string";
+    }
+    return null;
+  }
+  function json(value) { return JSON.stringify(value, null, 2); }
+  function operation(path, method, op) {
+    var inputs = {};
+    var rows = (op.parameters || []).map(function (param) {
+      var input = el("input", {placeholder: (param.schema || {}).type || ""});
+      inputs[param.in + ":" + param.name] = input;
+      return el("tr", {}, [el("td", {text: param.name + (param.required ? " *" : "")}), el("td", {text: param.in}), el("td", {text: param.description || ""}), el("td", {}, [input])]);
+    });
+    var children = [];
+    if (op.description) { children.push(el("p", {text: op.description})); }
+    if (rows.length) { children.push(el("table", {}, [el("tr", {}, [el("th", {text: "参数"}), el("th", {text: "位置"}), el("th", {text: "说明"}), el("th", {text: "值"})])].concat(rows))); }
+    var body;
+    if (op.requestBody) {
+      body = el("textarea");
+      body.value = json(sample(op.requestBody.content["application/json"].schema, 0));
+      children.push(el("h4", {text: "请求内容"}), body);
+    }
+    var codes = Object.keys(op.responses || {}).sort();
+    codes.forEach(function (code) {
+      var response = op.responses[code];
+      children.push(el("h4", {text: code + " " + response.description}));
+      if (response.content) { children.push(el("pre", {text: json(sample(response.content["application/json"].schema, 0))})); }
+    });
+    var output = el("pre", {text: ""});
+    var button = el("button", {text: "发送请求"});
+    button.onclick = function () {
+      var url = path, query = [], headers = {"Content-Type": "application/json"};
+      for (var key in inputs) {
+        var value = inputs[key].value, parts = key.split(":");
+        if (value === "") { continue; }
+        if (parts[0] === "path") { url = url.replace("{" + parts[1] + "}", encodeURIComponent(value)); }
+        if (parts[0] === "query") { query.push(encodeURIComponent(parts[1]) + "=" + encodeURIComponent(value)); }
+        if (parts[0] === "header") { headers[parts[1]] = value; }
+      }
+      var token = document.getElementById("token").value;
+      if (token) { headers.Authorization = "Bearer " + token; }
+      var base = spec.servers && spec.servers.length ? spec.servers[0].url.replace(/\/$/, "") : "";
+      fetch(base + url + (query.length ? "?" + query.join("&") : ""), {method: method.toUpperCase(), headers: headers, body: body ? body.value : undefined})
+        .then(function (res) { return res.text().then(function (text) { output.textContent = res.status + "\n" + text; }); })
+        .catch(function (err) { output.textContent = String(err); });
+    };
+    children.push(button, output);
+    var summary = el("summary", {}, [el("span", {"class": "method " + method, text: method.toUpperCase()}), el("span", {"class": op.deprecated ? "deprecated" : "", text: path + (op.summary ? "  " + op.summary : "")})]);
+    return el("details", {}, [summary, el("div", {"class": "body"}, children)]);
+  }
+  fetch({{.URL}}).then(function (res) { return res.json(); }).then(function (data) {
+    spec = data;
+    document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+    var main = document.getElementById("main"), groups = {};
+    Object.keys(spec.paths).sort().forEach(function (path) {
+      Object.keys(spec.paths[path]).forEach(function (method) {
+        var op = spec.paths[path][method], tag = (op.tags || [""])[0];
+        (groups[tag] = groups[tag] || []).push(operation(path, method, op));
+      });
+    });
+    Object.keys(groups).sort().forEach(function (tag) {
+      if (tag) { main.appendChild(el("div", {"class": "tag", text: tag})); }
+      groups[tag].forEach(function (node) { main.appendChild(node); });
+    });
+  });
+})();
+</script>
+{{end}}</body>
+</html>
+`
@@ -0,0 +1,539 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/rds"
+)
+
+const rateLimitsSettingKey = "rate_limits"
+
+const (
+	RateLimitFixedWindow = "fixed_window"
+	RateLimitSlidingLog  = "sliding_log"
+	RateLimitTokenBucket = "token_bucket"
+)
+
+// RateLimitRule 对应配置文件中的 [rate_limits.<name>]：
+//
+//	[rate_limits.public]
+//	algorithm = "sliding_log"
+//	limit = 100
+//	window_second = 60
+//	key = "ip"
+//	trusted_proxies = ["10.0.0.0/8"]
+type RateLimitRule struct {
+	// Algorithm fixed_window（默认）、sliding_log 或 token_bucket
+	Algorithm string `mapstructure:"algorithm"`
+	// Limit 每个窗口允许的请求数，令牌桶为每个窗口补充的令牌数
+	Limit        int `mapstructure:"limit"`
+	WindowSecond int `mapstructure:"window_second"`
+	// Burst 令牌桶容量，默认等于 Limit
+	Burst int `mapstructure:"burst"`
+	// Key ip（默认）、user、api_key 或 header:<名称>，api_key 及 header 需要设置 RateLimitOptions.Identify
+	Key string `mapstructure:"key"`
+	// TrustedProxies 可信代理的 IP 或 CIDR，只有连接来自这些地址时才使用 X-Forwarded-For
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+func (r RateLimitRule) window() time.Duration {
+	return time.Duration(r.WindowSecond) * time.Second
+}
+
+func (r RateLimitRule) capacity() int {
+	if r.Algorithm == RateLimitTokenBucket && r.Burst > 0 {
+		return r.Burst
+	}
+	return r.Limit
+}
+
+func (r RateLimitRule) validate() error {
+	switch r.Algorithm {
+	case RateLimitFixedWindow, RateLimitSlidingLog, RateLimitTokenBucket:
+	default:
+		return fmt.Errorf("unknow rate limit algorithm \"%s\"", r.Algorithm)
+	}
+	if r.Limit <= 0 || r.WindowSecond <= 0 {
+		return fmt.Errorf("rate limit needs limit and window_second")
+	}
+	return nil
+}
+
+// RateLimitResult Reset 为额度完全恢复或窗口重置的时间，RetryAfter 只在拒绝时设置
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimiter 计数并判断 key 是否超过限制
+type RateLimiter interface {
+	Take(key string, rule RateLimitRule) (*RateLimitResult, error)
+}
+
+// tokenBucketResult 根据剩余令牌计算结果，Redis 与内存实现共用
+func tokenBucketResult(rule RateLimitRule, allowed bool, tokens float64) *RateLimitResult {
+	capacity := rule.capacity()
+	perToken := float64(rule.window()) / float64(rule.Limit)
+	result := &RateLimitResult{
+		Allowed:   allowed,
+		Limit:     capacity,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(capacity) - tokens) * perToken),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) * perToken)
+	}
+	return result
+}
+
+func countResult(rule RateLimitRule, allowed bool, count int, reset time.Duration) *RateLimitResult {
+	result := &RateLimitResult{Allowed: allowed, Limit: rule.Limit, Remaining: rule.Limit - count, Reset: reset}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	if !allowed {
+		result.RetryAfter = reset
+	}
+	return result
+}
+
+const fixedWindowScript = `
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`
+
+const slidingLogScript = `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`
+
+// 浮点数返回时会被截断，令牌数以字符串返回
+const tokenBucketScript = `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate))
+return {allowed, tostring(tokens)}
+`
+
+// RedisRateLimiter 使用 Lua 脚本原子计数，多个实例共享限制，Client 为空时使用 rds.Default
+type RedisRateLimiter struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (l *RedisRateLimiter) client() (*redis.Client, error) {
+	if l.Client != nil {
+		return l.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis rate limiter needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (l *RedisRateLimiter) key(key string) string {
+	if l.Prefix == "" {
+		return "codex:ratelimit:" + key
+	}
+	return l.Prefix + key
+}
+
+func (l *RedisRateLimiter) Take(key string, rule RateLimitRule) (*RateLimitResult, error) {
+	client, err := l.client()
+	if err != nil {
+		return nil, err
+	}
+	window := int64(rule.window() / time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	var result interface{}
+	switch rule.Algorithm {
+	case RateLimitSlidingLog:
+		result, err = client.Eval(slidingLogScript, []string{l.key(key)}, now, window, rule.Limit, uuid.NewV4().String()).Result()
+	case RateLimitTokenBucket:
+		rate := strconv.FormatFloat(float64(rule.Limit)/float64(window), 'f', -1, 64)
+		result, err = client.Eval(tokenBucketScript, []string{l.key(key)}, rule.capacity(), rate, now).Result()
+	default:
+		result, err = client.Eval(fixedWindowScript, []string{l.key(key)}, window).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	items, ok := result.([]interface{})
+	if !ok || len(items) < 2 {
+		return nil, fmt.Errorf("redis rate limiter unexpected result")
+	}
+
+	switch rule.Algorithm {
+	case RateLimitSlidingLog:
+		allowed, _ := items[0].(int64)
+		count, _ := items[1].(int64)
+		var reset int64
+		if len(items) > 2 {
+			reset, _ = items[2].(int64)
+		}
+		return countResult(rule, allowed == 1, int(count), time.Duration(reset)*time.Millisecond), nil
+	case RateLimitTokenBucket:
+		allowed, _ := items[0].(int64)
+		text, _ := items[1].(string)
+		tokens, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis rate limiter unexpected result")
+		}
+		return tokenBucketResult(rule, allowed == 1, tokens), nil
+	default:
+		count, _ := items[0].(int64)
+		ttl, _ := items[1].(int64)
+		return countResult(rule, int(count) <= rule.Limit, int(count), time.Duration(ttl)*time.Millisecond), nil
+	}
+}
+
+type memoryRateEntry struct {
+	count   int
+	expires time.Time
+	log     []time.Time
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryRateLimiter 只在当前进程内计数，用于单实例部署
+type MemoryRateLimiter struct {
+	lock    sync.Mutex
+	entries map[string]*memoryRateEntry
+	swept   time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{entries: map[string]*memoryRateEntry{}, swept: time.Now()}
+}
+
+func (l *MemoryRateLimiter) Take(key string, rule RateLimitRule) (*RateLimitResult, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	window := rule.window()
+	l.sweep(now)
+
+	entry := l.entries[key]
+	if entry == nil {
+		entry = &memoryRateEntry{tokens: float64(rule.capacity()), updated: now}
+		l.entries[key] = entry
+	}
+
+	switch rule.Algorithm {
+	case RateLimitSlidingLog:
+		log := entry.log[:0]
+		for _, item := range entry.log {
+			if now.Sub(item) < window {
+				log = append(log, item)
+			}
+		}
+		allowed := len(log) < rule.Limit
+		if allowed {
+			log = append(log, now)
+		}
+		entry.log = log
+		entry.expires = now.Add(window)
+		return countResult(rule, allowed, len(log), log[0].Add(window).Sub(now)), nil
+	case RateLimitTokenBucket:
+		capacity := float64(rule.capacity())
+		rate := float64(rule.Limit) / float64(window)
+		entry.tokens = math.Min(capacity, entry.tokens+float64(now.Sub(entry.updated))*rate)
+		entry.updated = now
+		allowed := entry.tokens >= 1
+		if allowed {
+			entry.tokens--
+		}
+		entry.expires = now.Add(time.Duration(capacity / rate))
+		return tokenBucketResult(rule, allowed, entry.tokens), nil
+	default:
+		if !now.Before(entry.expires) {
+			entry.count = 0
+			entry.expires = now.Add(window)
+		}
+		entry.count++
+		return countResult(rule, entry.count <= rule.Limit, entry.count, entry.expires.Sub(now)), nil
+	}
+}
+
+// sweep 每分钟删除一次过期的计数
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for key, entry := range l.entries {
+		if now.After(entry.expires) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+var defaultRateLimiter RateLimiter
+var defaultRateLimiterLock = sync.Mutex{}
+
+// DefaultRateLimiter 首次调用时 rds.Default 不为空则使用 Redis，否则使用内存
+func DefaultRateLimiter() RateLimiter {
+	defaultRateLimiterLock.Lock()
+	defer defaultRateLimiterLock.Unlock()
+	if defaultRateLimiter == nil {
+		if rds.Default != nil {
+			defaultRateLimiter = &RedisRateLimiter{}
+		} else {
+			defaultRateLimiter = NewMemoryRateLimiter()
+		}
+	}
+	return defaultRateLimiter
+}
+
+func SetDefaultRateLimiter(limiter RateLimiter) {
+	defaultRateLimiterLock.Lock()
+	defer defaultRateLimiterLock.Unlock()
+	defaultRateLimiter = limiter
+}
+
+// RateLimitByIP 按连接的 IP 限制，不使用 X-Forwarded-For
+func RateLimitByIP(c *gin.Context) string {
+	return "ip:" + remoteIP(c.Request)
+}
+
+// RateLimitByIPEX 连接来自 trustedProxies（IP 或 CIDR）时，使用 X-Forwarded-For 中从右往左第一个不可信的地址
+func RateLimitByIPEX(trustedProxies []string) (func(c *gin.Context) string, error) {
+	if len(trustedProxies) == 0 {
+		return RateLimitByIP, nil
+	}
+	networks := []*net.IPNet{}
+	for _, item := range trustedProxies {
+		cidr := item
+		if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else if ip != nil {
+			cidr += "/128"
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("unknow trusted proxy \"%s\"", item)
+		}
+		networks = append(networks, network)
+	}
+	trusted := func(address string) bool {
+		ip := net.ParseIP(address)
+		for _, network := range networks {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(c *gin.Context) string {
+		address := remoteIP(c.Request)
+		if !trusted(address) {
+			return "ip:" + address
+		}
+		forwarded := strings.Split(strings.Join(c.Request.Header["X-Forwarded-For"], ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			item := strings.TrimSpace(forwarded[i])
+			if item == "" {
+				continue
+			}
+			address = item
+			if !trusted(item) {
+				break
+			}
+		}
+		return "ip:" + address
+	}, nil
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// RateLimitByUser 按 JWT 令牌的 sub 限制，需要在 JWT 中间件之后使用，未登录时不限制
+func RateLimitByUser(c *gin.Context) string {
+	if claims := ClaimsOf(c); claims != nil && claims.Subject() != "" {
+		return "user:" + claims.Subject()
+	}
+	return ""
+}
+
+// RateLimitIdentify 将请求头的值解析为已知的身份，如 API Key 对应的应用 ID，无法识别时返回空字符串
+type RateLimitIdentify func(c *gin.Context, value string) string
+
+// RateLimitByHeader 按请求头对应的身份限制，身份以 SHA-256 摘要作为 key。请求头为空时不限制，
+// 无法识别时按连接的 IP 限制，避免任意值各自占用一个计数
+func RateLimitByHeader(header string, identify RateLimitIdentify) func(c *gin.Context) string {
+	return func(c *gin.Context) string {
+		value := c.GetHeader(header)
+		if value == "" {
+			return ""
+		}
+		identity := identify(c, value)
+		if identity == "" {
+			return RateLimitByIP(c)
+		}
+		sum := sha256.Sum256([]byte(identity))
+		return "header:" + header + ":" + hex.EncodeToString(sum[:])
+	}
+}
+
+func rateLimitKeyOf(rule RateLimitRule, identify RateLimitIdentify) (func(c *gin.Context) string, error) {
+	header := ""
+	switch {
+	case rule.Key == "" || rule.Key == "ip":
+		return RateLimitByIPEX(rule.TrustedProxies)
+	case rule.Key == "user":
+		return RateLimitByUser, nil
+	case rule.Key == "api_key":
+		header = "X-API-Key"
+	case strings.HasPrefix(rule.Key, "header:"):
+		header = rule.Key[len("header:"):]
+	default:
+		return nil, fmt.Errorf("unknow rate limit key \"%s\"", rule.Key)
+	}
+	if identify == nil {
+		return nil, fmt.Errorf("rate limit key \"%s\" needs Identify", rule.Key)
+	}
+	return RateLimitByHeader(header, identify), nil
+}
+
+// RateLimitOptions Name 用于区分不同分组的计数
+type RateLimitOptions struct {
+	Name string
+	Rule RateLimitRule
+	// Key 返回空字符串时不限制，默认按 Rule.Key 选择
+	Key func(c *gin.Context) string
+	// Identify Rule.Key 为 api_key 或 header:<名称> 时必须设置
+	Identify RateLimitIdentify
+	// Limiter 默认使用 DefaultRateLimiter()
+	Limiter RateLimiter
+}
+
+// RateLimit 限流中间件，设置 RateLimit-Limit、RateLimit-Remaining、RateLimit-Reset 及 RateLimit-Policy 响应头，
+// 超过限制时返回 429 及 cerror.TOO_MANY_REQUESTS。计数出错时放行并触发 SetErrorHook 注册的处理
+func RateLimit(option RateLimitOptions) gin.HandlerFunc {
+	rule := option.Rule
+	if rule.Algorithm == "" {
+		rule.Algorithm = RateLimitFixedWindow
+	}
+	if err := rule.validate(); err != nil {
+		panic(err)
+	}
+	keyOf := option.Key
+	if keyOf == nil {
+		var err error
+		if keyOf, err = rateLimitKeyOf(rule, option.Identify); err != nil {
+			panic(err)
+		}
+	}
+	policy := fmt.Sprintf("%d;w=%d", rule.capacity(), rule.WindowSecond)
+
+	return func(c *gin.Context) {
+		key := keyOf(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+		limiter := option.Limiter
+		if limiter == nil {
+			limiter = DefaultRateLimiter()
+		}
+		result, err := limiter.Take(option.Name+":"+key, rule)
+		if err != nil {
+			triggerErrorHandles(uuid.NewV4().String(), err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Header("RateLimit-Policy", policy)
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, NewJSONResult().Error(cerror.TOO_MANY_REQUESTS, "请求过于频繁"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func ceilSeconds(duration time.Duration) int {
+	if duration <= 0 {
+		return 0
+	}
+	return int((duration + time.Second - 1) / time.Second)
+}
+
+// RateLimitFromConfig 使用 [rate_limits.<name>] 中的规则
+func RateLimitFromConfig(name string) gin.HandlerFunc {
+	return RateLimitFromConfigEX(name, RateLimitOptions{})
+}
+
+// RateLimitFromConfigEX 使用 [rate_limits.<name>] 中的规则，option 中的 Name 及 Rule 会被覆盖
+func RateLimitFromConfigEX(name string, option RateLimitOptions) gin.HandlerFunc {
+	rule := RateLimitRule{}
+	if err := configs.Settings.UnmarshalKey(rateLimitsSettingKey+"."+name, &rule); err != nil {
+		panic(fmt.Errorf("rate limit [%s.%s] error:%s", rateLimitsSettingKey, name, err.Error()))
+	}
+	option.Name = name
+	option.Rule = rule
+	return RateLimit(option)
+}
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryRateLimiter_FixedWindow(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	rule := RateLimitRule{Algorithm: RateLimitFixedWindow, Limit: 2, WindowSecond: 60}
+	for i, allowed := range []bool{true, true, false} {
+		result, _ := limiter.Take("k", rule)
+		if result.Allowed != allowed || result.Remaining != 1-i && allowed {
+			t.Fatalf("%d: unexpected result %+v", i, result)
+		}
+		if !allowed && (result.Remaining != 0 || result.RetryAfter <= 0 || result.RetryAfter > time.Minute) {
+			t.Fatalf("unexpected rejected result %+v", result)
+		}
+	}
+	// 其他 key 单独计数
+	if result, _ := limiter.Take("other", rule); !result.Allowed {
+		t.Fatal("keys should be counted separately")
+	}
+	limiter.entries["k"].expires = time.Now().Add(-time.Second)
+	if result, _ := limiter.Take("k", rule); !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("window should be reset, got %+v", result)
+	}
+}
+
+func TestMemoryRateLimiter_SlidingLog(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	rule := RateLimitRule{Algorithm: RateLimitSlidingLog, Limit: 2, WindowSecond: 60}
+	limiter.Take("k", rule)
+	limiter.Take("k", rule)
+	if result, _ := limiter.Take("k", rule); result.Allowed || result.RetryAfter <= 0 {
+		t.Fatalf("third request should be rejected, got %+v", result)
+	}
+	// 只有最早的记录移出窗口
+	limiter.entries["k"].log[0] = time.Now().Add(-61 * time.Second)
+	result, _ := limiter.Take("k", rule)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("request should be allowed after the oldest expires, got %+v", result)
+	}
+	if result, _ := limiter.Take("k", rule); result.Allowed {
+		t.Fatalf("log should still be full, got %+v", result)
+	}
+}
+
+func TestMemoryRateLimiter_TokenBucket(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	rule := RateLimitRule{Algorithm: RateLimitTokenBucket, Limit: 1, WindowSecond: 10, Burst: 3}
+	for i := 0; i < 3; i++ {
+		if result, _ := limiter.Take("k", rule); !result.Allowed || result.Limit != 3 || result.Remaining != 2-i {
+			t.Fatalf("%d: burst should be allowed, got %+v", i, result)
+		}
+	}
+	result, _ := limiter.Take("k", rule)
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 10*time.Second {
+		t.Fatalf("empty bucket should be rejected, got %+v", result)
+	}
+	// 10 秒补充 1 个令牌，且不超过容量
+	limiter.entries["k"].updated = time.Now().Add(-10 * time.Second)
+	if result, _ := limiter.Take("k", rule); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("one token should be refilled, got %+v", result)
+	}
+	limiter.entries["k"].updated = time.Now().Add(-time.Hour)
+	if result, _ := limiter.Take("k", rule); !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("bucket should be capped at burst, got %+v", result)
+	}
+}
+
+func TestMemoryRateLimiter_Sweep(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	rule := RateLimitRule{Algorithm: RateLimitFixedWindow, Limit: 1, WindowSecond: 1}
+	limiter.Take("old", rule)
+	limiter.entries["old"].expires = time.Now().Add(-time.Second)
+	limiter.swept = time.Now().Add(-2 * time.Minute)
+	limiter.Take("new", rule)
+	if _, ok := limiter.entries["old"]; ok || len(limiter.entries) != 1 {
+		t.Fatalf("expired entries should be swept, got %v", limiter.entries)
+	}
+}
+
+func rateLimitContext(remoteAddr string, headers map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.RemoteAddr = remoteAddr
+	for key, value := range headers {
+		c.Request.Header.Set(key, value)
+	}
+	return c
+}
+
+func TestRateLimitByIP(t *testing.T) {
+	headers := map[string]string{"X-Forwarded-For": "1.1.1.1, 2.2.2.2"}
+	if key := RateLimitByIP(rateLimitContext("10.0.0.1:1234", headers)); key != "ip:10.0.0.1" {
+		t.Fatalf("X-Forwarded-For should be ignored, got %s", key)
+	}
+
+	keyOf, err := RateLimitByIPEX([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		remoteAddr string
+		forwarded  string
+		key        string
+	}{
+		{"10.0.0.1:1234", "1.1.1.1, 2.2.2.2", "ip:2.2.2.2"},
+		{"10.0.0.1:1234", "1.1.1.1, 192.168.1.1", "ip:1.1.1.1"},
+		{"10.0.0.1:1234", "", "ip:10.0.0.1"},
+		{"8.8.8.8:1234", "1.1.1.1", "ip:8.8.8.8"},
+	}
+	for _, item := range cases {
+		if key := keyOf(rateLimitContext(item.remoteAddr, map[string]string{"X-Forwarded-For": item.forwarded})); key != item.key {
+			t.Errorf("%s %s: got %s, want %s", item.remoteAddr, item.forwarded, key, item.key)
+		}
+	}
+	if _, err := RateLimitByIPEX([]string{"proxy"}); err == nil {
+		t.Fatal("invalid trusted proxy should return error")
+	}
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+	if _, err := rateLimitKeyOf(RateLimitRule{Key: "api_key"}, nil); err == nil {
+		t.Fatal("api_key should need Identify")
+	}
+	keyOf, err := rateLimitKeyOf(RateLimitRule{Key: "api_key"}, func(c *gin.Context, value string) string {
+		if value == "secret-key" {
+			return "app-1"
+		}
+		return ""
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := keyOf(rateLimitContext("10.0.0.1:1234", map[string]string{"X-API-Key": "secret-key"}))
+	if !strings.HasPrefix(key, "header:X-API-Key:") || strings.Contains(key, "secret") || strings.Contains(key, "app-1") {
+		t.Fatalf("identity should be hashed, got %s", key)
+	}
+	if key := keyOf(rateLimitContext("10.0.0.1:1234", map[string]string{"X-API-Key": "forged"})); key != "ip:10.0.0.1" {
+		t.Fatalf("unknown value should fall back to ip, got %s", key)
+	}
+	if key := keyOf(rateLimitContext("10.0.0.1:1234", nil)); key != "" {
+		t.Fatalf("empty header should not be limited, got %s", key)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	engine := gin.New()
+	engine.Use(RateLimit(RateLimitOptions{Name: "test", Rule: RateLimitRule{Limit: 1, WindowSecond: 60}, Limiter: NewMemoryRateLimiter()}))
+	engine.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	call := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", remoteAddr)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		return w
+	}
+	if w := call("1.1.1.1:1"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Policy") != "1;w=60" {
+		t.Fatalf("unexpected first response %d %v", w.Code, w.Header())
+	}
+	if w := call("1.1.1.1:2"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("unexpected second response %d %v", w.Code, w.Header())
+	}
+	if w := call("2.2.2.2:1"); w.Code != http.StatusOK {
+		t.Fatalf("other ip should be allowed, got %d", w.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/database"
+)
+
+const rbacRolesSettingKey = "rbac_roles"
+
+// RolePermission 数据库中的角色权限，每行一个权限
+type RolePermission struct {
+	Role       string `gorm:"column:role;primary_key"`
+	Permission string `gorm:"column:permission;primary_key"`
+}
+
+func (RolePermission) TableName() string {
+	return "rbac_role_permissions"
+}
+
+// RBAC 角色及权限，权限支持通配符，如 orders:* 匹配 orders:write，* 匹配所有权限。
+// 配置中的键会被 viper 转为小写，角色名统一按小写比较，权限区分大小写
+type RBAC struct {
+	lock  sync.RWMutex
+	roles map[string][]string
+}
+
+func NewRBAC() *RBAC {
+	return &RBAC{roles: map[string][]string{}}
+}
+
+// SetRole 设置角色的权限，覆盖原有权限
+func (r *RBAC) SetRole(role string, permissions ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.roles[strings.ToLower(role)] = permissions
+}
+
+func (r *RBAC) Permissions(roles ...string) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	permissions := []string{}
+	for _, role := range roles {
+		permissions = append(permissions, r.roles[strings.ToLower(role)]...)
+	}
+	return permissions
+}
+
+func matchPermission(pattern string, permission string) bool {
+	if pattern == "*" || pattern == permission {
+		return true
+	}
+	return strings.HasSuffix(pattern, ":*") && strings.HasPrefix(permission, pattern[:len(pattern)-1])
+}
+
+// Allowed 任意一个角色拥有 permission 时返回 true
+func (r *RBAC) Allowed(roles []string, permission string) bool {
+	for _, pattern := range r.Permissions(roles...) {
+		if matchPermission(pattern, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFromConfig 从配置加载：
+//
+//	[rbac_roles]
+//	admin = ["*"]
+//	operator = ["orders:read", "orders:write"]
+func (r *RBAC) LoadFromConfig() {
+	for role, permissions := range configs.Settings.GetStringMapStringSlice(rbacRolesSettingKey) {
+		r.SetRole(role, permissions...)
+	}
+}
+
+// LoadFromDB 从 rbac_role_permissions 表加载，替换已有的角色
+func (r *RBAC) LoadFromDB(repo *database.DatabaseRepo) error {
+	rows := []RolePermission{}
+	if err := repo.Find(&rows, 0, 0, "", ""); err != nil {
+		return err
+	}
+	roles := map[string][]string{}
+	for _, row := range rows {
+		role := strings.ToLower(row.Role)
+		roles[role] = append(roles[role], row.Permission)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.roles = roles
+	return nil
+}
+
+var defaultRBAC *RBAC
+var defaultRBACLock = sync.Mutex{}
+
+// DefaultRBAC 首次调用时从配置加载
+func DefaultRBAC() *RBAC {
+	defaultRBACLock.Lock()
+	defer defaultRBACLock.Unlock()
+	if defaultRBAC == nil {
+		defaultRBAC = NewRBAC()
+		defaultRBAC.LoadFromConfig()
+	}
+	return defaultRBAC
+}
+
+func SetDefaultRBAC(rbac *RBAC) {
+	defaultRBACLock.Lock()
+	defer defaultRBACLock.Unlock()
+	defaultRBAC = rbac
+}
+
+// Require 需要在 JWT 中间件之后使用，按令牌中的 roles 检查是否拥有全部权限
+func Require(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := ClaimsOf(c)
+		if claims == nil {
+			unauthorized(c, "未登录")
+			return
+		}
+		rbac := DefaultRBAC()
+		roles := claims.Roles()
+		for _, permission := range permissions {
+			if !rbac.Allowed(roles, permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, NewJSONResult().Error(cerror.FORBIDDEN, "没有权限"))
+				return
+			}
+		}
+		c.Next()
+	}
+}
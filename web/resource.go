@@ -0,0 +1,593 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/database"
+)
+
+const (
+	webResourcePageSizeSettingKey    = "web_resource_page_size"
+	webResourceMaxPageSizeSettingKey = "web_resource_max_page_size"
+)
+
+func init() {
+	configs.Settings.SetDefault(webResourcePageSizeSettingKey, 20)
+	configs.Settings.SetDefault(webResourceMaxPageSizeSettingKey, 100)
+}
+
+type ResourceAction string
+
+const (
+	ResourceList   ResourceAction = "list"
+	ResourceGet    ResourceAction = "get"
+	ResourceCreate ResourceAction = "create"
+	ResourceUpdate ResourceAction = "update"
+	ResourceDelete ResourceAction = "delete"
+)
+
+// ResourceOptions 字段均使用 json 名称
+type ResourceOptions struct {
+	// Repo 为空时使用 database.GetDefault()
+	Repo *database.DatabaseRepo
+	// Actions 注册的操作，为空时注册全部
+	Actions []ResourceAction
+	// Fields 可用于查询及排序的字段，为空时为 Hidden 以外的全部字段
+	Fields []string
+	// Writable 创建及修改时可写入的字段，为空时为主键及 Hidden 以外的全部字段
+	Writable []string
+	// Hidden 返回时删除的字段，如密码哈希，需要查询或写入时在 Fields、Writable 中明确列出
+	Hidden []string
+	// DefaultSort 没有 sort 参数时的排序，如 -id
+	DefaultSort string
+	// PageSize、MaxPageSize 为 0 时使用配置 web_resource_page_size、web_resource_max_page_size
+	PageSize    int
+	MaxPageSize int
+	// Scope 附加的查询条件，如只能访问当前用户的记录，对列表、读取、修改及删除生效
+	Scope func(c *gin.Context) (string, []interface{})
+	// Authorize 返回错误时中止请求，列表时 item 为 nil，创建时为绑定后的内容，其他操作为数据库中的记录
+	Authorize func(c *gin.Context, action ResourceAction, item interface{}) error
+	// BeforeSave 创建及修改保存之前调用，修改时只保存请求中的字段
+	BeforeSave func(c *gin.Context, action ResourceAction, item interface{}) error
+	// Mask 返回之前转换记录，如隐藏手机号的部分数字
+	Mask func(c *gin.Context, item interface{}) interface{}
+}
+
+type resourceField struct {
+	name    string
+	column  string
+	field   reflect.StructField
+	primary bool
+}
+
+type resource struct {
+	option     ResourceOptions
+	modelType  reflect.Type
+	fields     map[string]*resourceField
+	ordered    []*resourceField
+	primary    *resourceField
+	filterable map[string]bool
+	writable   map[string]bool
+	hidden     map[string]bool
+}
+
+// 查询参数 field__op=value 支持的条件，没有 op 时为 eq
+var resourceOperators = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+	"in":   "IN",
+	"null": "IS NULL",
+}
+
+// Resource 为 gorm 模型注册 REST 接口：
+//
+//	GET    path          列表，参数 page、page_size、sort=-created_at,name 及过滤条件 name=bob、age__gte=18、status__in=1,2、deleted_at__null=true
+//	GET    path/:id      读取
+//	POST   path          创建，使用 Bind 绑定及校验
+//	PUT    path/:id      修改请求中的字段，PATCH 相同
+//	DELETE path/:id      删除
+//
+// 返回 JSONResult，列表的 data 为 {"items", "total", "page", "page_size"}。
+// 过滤及排序只能使用 Fields 中的字段，值作为参数传入查询
+func Resource(group gin.IRouter, path string, model interface{}, option ResourceOptions) {
+	r := newResource(model, option)
+	actions := map[ResourceAction]bool{}
+	for _, action := range option.Actions {
+		actions[action] = true
+	}
+	enabled := func(action ResourceAction) bool {
+		return len(actions) == 0 || actions[action]
+	}
+
+	path = strings.TrimRight(path, "/")
+	itemPath := path + "/:id"
+	tags := []string{r.modelType.Name()}
+	request := reflect.New(r.docType(func(field *resourceField) bool { return r.writable[field.name] })).Interface()
+	response := reflect.New(r.responseType()).Interface()
+	notFound := []int{cerror.DB_NOT_FOUND}
+	if enabled(ResourceList) {
+		Route(group, http.MethodGet, path, APIDoc{Summary: "列表", Tags: tags, Response: r.pageType(), Params: r.listParams()}, Handle(r.list))
+	}
+	if enabled(ResourceGet) {
+		Route(group, http.MethodGet, itemPath, APIDoc{Summary: "读取", Tags: tags, Response: response, Errors: notFound}, Handle(r.get))
+	}
+	if enabled(ResourceCreate) {
+		Route(group, http.MethodPost, path, APIDoc{Summary: "创建", Tags: tags, Request: request, Response: response}, Handle(r.create))
+	}
+	if enabled(ResourceUpdate) {
+		Route(group, http.MethodPut, itemPath, APIDoc{Summary: "修改", Tags: tags, Request: request, Response: response, Errors: notFound}, Handle(r.update))
+		Route(group, http.MethodPatch, itemPath, APIDoc{Summary: "修改", Tags: tags, Request: request, Response: response, Errors: notFound}, Handle(r.update))
+	}
+	if enabled(ResourceDelete) {
+		Route(group, http.MethodDelete, itemPath, APIDoc{Summary: "删除", Tags: tags, Errors: notFound}, Handle(r.delete))
+	}
+}
+
+// docType 只包含 include 中字段的类型，用于生成文档
+func (r *resource) docType(include func(field *resourceField) bool) reflect.Type {
+	fields := []reflect.StructField{}
+	for _, field := range r.ordered {
+		if include(field) {
+			fields = append(fields, reflect.StructField{Name: field.field.Name, Type: field.field.Type, Tag: field.field.Tag})
+		}
+	}
+	return reflect.StructOf(fields)
+}
+
+// responseType 不包含 Hidden 字段的类型，没有 Hidden 时使用模型，文档中引用模型的名称
+func (r *resource) responseType() reflect.Type {
+	if len(r.hidden) == 0 {
+		return r.modelType
+	}
+	return r.docType(func(field *resourceField) bool { return !r.hidden[field.name] })
+}
+
+// pageType 列表 data 的类型，用于生成文档
+func (r *resource) pageType() interface{} {
+	return reflect.New(reflect.StructOf([]reflect.StructField{
+		{Name: "Items", Type: reflect.SliceOf(reflect.PtrTo(r.responseType())), Tag: `json:"items"`},
+		{Name: "Total", Type: reflect.TypeOf(0), Tag: `json:"total"`},
+		{Name: "Page", Type: reflect.TypeOf(0), Tag: `json:"page"`},
+		{Name: "PageSize", Type: reflect.TypeOf(0), Tag: `json:"page_size"`},
+	})).Interface()
+}
+
+func (r *resource) listParams() []APIParam {
+	params := []APIParam{
+		{Name: "page", In: "query", Type: "integer", Description: "页码，从 1 开始"},
+		{Name: "page_size", In: "query", Type: "integer", Description: fmt.Sprintf("每页数量，默认 %d，最大 %d", r.option.PageSize, r.option.MaxPageSize)},
+		{Name: "sort", In: "query", Description: "排序字段，逗号分隔，- 开头为倒序"},
+	}
+	names := []string{}
+	for name := range r.filterable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, APIParam{Name: name, In: "query", Description: "等于，也可以使用 " + name + "__ne、__gt、__gte、__lt、__lte、__like、__in、__null"})
+	}
+	return params
+}
+
+func newResource(model interface{}, option ResourceOptions) *resource {
+	modelType := reflect.TypeOf(model)
+	for modelType.Kind() == reflect.Ptr {
+		modelType = modelType.Elem()
+	}
+	if modelType.Kind() != reflect.Struct {
+		panic(fmt.Errorf("resource model must be a struct"))
+	}
+	r := &resource{
+		option:     option,
+		modelType:  modelType,
+		fields:     map[string]*resourceField{},
+		filterable: map[string]bool{},
+		writable:   map[string]bool{},
+		hidden:     map[string]bool{},
+	}
+
+	for _, field := range (&gorm.Scope{Value: reflect.New(modelType).Interface()}).GetModelStruct().StructFields {
+		if field.IsIgnored || !field.IsNormal {
+			continue
+		}
+		name := strings.Split(field.Struct.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		item := &resourceField{name: name, column: field.DBName, field: field.Struct, primary: field.IsPrimaryKey}
+		r.fields[name] = item
+		r.ordered = append(r.ordered, item)
+		if item.primary && r.primary == nil {
+			r.primary = item
+		}
+	}
+	if r.primary == nil {
+		panic(fmt.Errorf("resource model \"%s\" has no primary key", modelType.Name()))
+	}
+
+	names := func(items []string, all func(*resourceField) bool) map[string]bool {
+		values := map[string]bool{}
+		if len(items) == 0 && all != nil {
+			for name, field := range r.fields {
+				if all(field) {
+					values[name] = true
+				}
+			}
+		}
+		for _, name := range items {
+			if r.fields[name] == nil {
+				panic(fmt.Errorf("unknow resource field \"%s\"", name))
+			}
+			values[name] = true
+		}
+		return values
+	}
+	r.hidden = names(option.Hidden, nil)
+	r.filterable = names(option.Fields, func(field *resourceField) bool { return !r.hidden[field.name] })
+	r.writable = names(option.Writable, func(field *resourceField) bool { return !field.primary && !r.hidden[field.name] })
+
+	if r.option.PageSize <= 0 {
+		r.option.PageSize = configs.Settings.GetInt(webResourcePageSizeSettingKey)
+	}
+	if r.option.MaxPageSize <= 0 {
+		r.option.MaxPageSize = configs.Settings.GetInt(webResourceMaxPageSizeSettingKey)
+	}
+	return r
+}
+
+// value gorm 展开了嵌入的结构体，按名称读取提升的字段
+func (f *resourceField) value(item interface{}) reflect.Value {
+	return reflect.Indirect(reflect.ValueOf(item)).FieldByName(f.field.Name)
+}
+
+func (r *resource) repo() *database.DatabaseRepo {
+	if r.option.Repo == nil {
+		return database.GetDefault()
+	}
+	return r.option.Repo
+}
+
+func (r *resource) authorize(c *gin.Context, action ResourceAction, item interface{}) error {
+	if r.option.Authorize == nil {
+		return nil
+	}
+	return r.option.Authorize(c, action, item)
+}
+
+func (r *resource) beforeSave(c *gin.Context, action ResourceAction, item interface{}) error {
+	if r.option.BeforeSave == nil {
+		return nil
+	}
+	return r.option.BeforeSave(c, action, item)
+}
+
+// scope 合并条件及 Scope 返回的条件
+func (r *resource) scope(c *gin.Context, where []string, params []interface{}) (string, []interface{}) {
+	if r.option.Scope != nil {
+		if query, values := r.option.Scope(c); query != "" {
+			where = append(where, "("+query+")")
+			params = append(params, values...)
+		}
+	}
+	return strings.Join(where, " AND "), params
+}
+
+// filterValue 按字段类型转换查询参数，无法转换的类型使用原始字符串
+func filterValue(field reflect.StructField, text string) (interface{}, error) {
+	valueType := field.Type
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	if valueType.Kind() == reflect.Struct && valueType != timeType {
+		return text, nil
+	}
+	value := reflect.New(valueType).Elem()
+	if err := setValue(value, field, []string{text}); err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+func (r *resource) filter(lang string, key string, text string) (string, []interface{}, *FieldError) {
+	name, op := key, "eq"
+	if i := strings.LastIndex(key, "__"); i > 0 {
+		name, op = key[:i], key[i+2:]
+	}
+	operator, found := resourceOperators[op]
+	field := r.fields[name]
+	if !found || field == nil || !r.filterable[name] {
+		return "", nil, &FieldError{Field: key, Rule: "filter", Message: validateMessage(lang, "filter", key, "")}
+	}
+	typeError := &FieldError{Field: key, Rule: "type", Message: validateMessage(lang, "type", key, "")}
+
+	switch op {
+	case "null":
+		isNull, err := strconv.ParseBool(text)
+		if err != nil {
+			return "", nil, typeError
+		}
+		if !isNull {
+			operator = "IS NOT NULL"
+		}
+		return fmt.Sprintf("%s %s", field.column, operator), nil, nil
+	case "like":
+		return fmt.Sprintf("%s LIKE ?", field.column), []interface{}{text}, nil
+	case "in":
+		values := []interface{}{}
+		for _, item := range strings.Split(text, ",") {
+			value, err := filterValue(field.field, item)
+			if err != nil {
+				return "", nil, typeError
+			}
+			values = append(values, value)
+		}
+		return fmt.Sprintf("%s IN (?)", field.column), []interface{}{values}, nil
+	}
+	value, err := filterValue(field.field, text)
+	if err != nil {
+		return "", nil, typeError
+	}
+	return fmt.Sprintf("%s %s ?", field.column, operator), []interface{}{value}, nil
+}
+
+// order 转换 sort 参数，- 开头为倒序
+func (r *resource) order(lang string, text string) (string, ParameterErrors) {
+	orders := []string{}
+	errs := ParameterErrors{}
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		direction := "ASC"
+		if strings.HasPrefix(item, "-") {
+			direction, item = "DESC", item[1:]
+		}
+		field := r.fields[item]
+		if field == nil || !r.filterable[item] {
+			errs = append(errs, FieldError{Field: item, Rule: "sort", Message: validateMessage(lang, "sort", item, "")})
+			continue
+		}
+		orders = append(orders, field.column+" "+direction)
+	}
+	return strings.Join(orders, ", "), errs
+}
+
+func (r *resource) list(c *gin.Context) error {
+	if err := r.authorize(c, ResourceList, nil); err != nil {
+		return err
+	}
+	lang := requestLang(c)
+	query := c.Request.URL.Query()
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	page, size, sortText := 1, r.option.PageSize, r.option.DefaultSort
+	where, params := []string{}, []interface{}{}
+	errs := ParameterErrors{}
+	for _, key := range keys {
+		text := query.Get(key)
+		switch key {
+		case "page", "page_size":
+			value, err := strconv.Atoi(text)
+			if err != nil || value < 1 {
+				errs = append(errs, FieldError{Field: key, Rule: "type", Message: validateMessage(lang, "type", key, "")})
+			} else if key == "page" {
+				page = value
+			} else {
+				size = value
+			}
+		case "sort":
+			sortText = text
+		default:
+			condition, values, fieldErr := r.filter(lang, key, text)
+			if fieldErr != nil {
+				errs = append(errs, *fieldErr)
+				continue
+			}
+			where = append(where, condition)
+			params = append(params, values...)
+		}
+	}
+	order, orderErrs := r.order(lang, sortText)
+	errs = append(errs, orderErrs...)
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return errs
+	}
+	if size > r.option.MaxPageSize {
+		size = r.option.MaxPageSize
+	}
+
+	condition, params := r.scope(c, where, params)
+	list := reflect.New(reflect.SliceOf(reflect.PtrTo(r.modelType)))
+	total := 0
+	err := r.repo().FindEX(list.Interface(), database.SearchOption{
+		Where:    condition,
+		Params:   params,
+		Order:    order,
+		Offset:   (page - 1) * size,
+		Limit:    size,
+		TotalOut: &total,
+	})
+	if err != nil {
+		return err
+	}
+
+	items := []interface{}{}
+	for i := 0; i < list.Elem().Len(); i++ {
+		items = append(items, r.output(c, list.Elem().Index(i).Interface()))
+	}
+	c.JSON(http.StatusOK, NewJSONResult().Success().SetData(gin.H{
+		"items":     items,
+		"total":     total,
+		"page":      page,
+		"page_size": size,
+	}))
+	return nil
+}
+
+// load 按路径参数 id 读取记录，不存在时返回 cerror.DB_NOT_FOUND
+func (r *resource) load(c *gin.Context) (interface{}, error) {
+	item := reflect.New(r.modelType).Interface()
+	condition, params := r.scope(c, []string{r.primary.column + " = ?"}, []interface{}{c.Param("id")})
+	result := r.repo().First(item, condition, params...)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if result.IsRecordNotFound {
+		return nil, cerror.NewCodeError(cerror.DB_NOT_FOUND, fmt.Errorf("记录不存在"))
+	}
+	return item, nil
+}
+
+func (r *resource) get(c *gin.Context) error {
+	item, err := r.load(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, ResourceGet, item); err != nil {
+		return err
+	}
+	c.JSON(http.StatusOK, NewJSONResult().Success().SetData(r.output(c, item)))
+	return nil
+}
+
+func (r *resource) create(c *gin.Context) error {
+	item := reflect.New(r.modelType)
+	if err := Bind(c, item.Interface()); err != nil {
+		return err
+	}
+	// 不可写入的字段恢复为零值
+	for name, field := range r.fields {
+		if !r.writable[name] {
+			value := field.value(item.Interface())
+			value.Set(reflect.Zero(value.Type()))
+		}
+	}
+	if err := r.authorize(c, ResourceCreate, item.Interface()); err != nil {
+		return err
+	}
+	if err := r.beforeSave(c, ResourceCreate, item.Interface()); err != nil {
+		return err
+	}
+	if err := r.repo().Create(item.Interface()); err != nil {
+		return err
+	}
+	c.JSON(http.StatusOK, NewJSONResult().Success().SetData(r.output(c, item.Interface())))
+	return nil
+}
+
+func (r *resource) update(c *gin.Context) error {
+	item, err := r.load(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, ResourceUpdate, item); err != nil {
+		return err
+	}
+
+	lang := requestLang(c)
+	body := map[string]json.RawMessage{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil && err != io.EOF {
+		return ParameterErrors{{Rule: "format", Message: validateMessage(lang, "format", "", "")}}
+	}
+	values := map[string]json.RawMessage{}
+	for name, value := range body {
+		if r.writable[name] {
+			values[name] = value
+		}
+	}
+	buff, _ := json.Marshal(values)
+	if err := json.Unmarshal(buff, item); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return ParameterErrors{{Field: typeErr.Field, Rule: "type", Message: validateMessage(lang, "type", typeErr.Field, "")}}
+		}
+		return ParameterErrors{{Rule: "format", Message: validateMessage(lang, "format", "", "")}}
+	}
+	if err := validateStruct(lang, item); err != nil {
+		return err
+	}
+	if err := r.beforeSave(c, ResourceUpdate, item); err != nil {
+		return err
+	}
+
+	columns := map[string]interface{}{}
+	for name := range values {
+		field := r.fields[name]
+		columns[field.column] = field.value(item).Interface()
+	}
+	if len(columns) > 0 {
+		id := r.primary.value(item).Interface()
+		if err := r.repo().Updates(item, r.primary.column+" = ?", []interface{}{id}, columns); err != nil {
+			return err
+		}
+	}
+	c.JSON(http.StatusOK, NewJSONResult().Success().SetData(r.output(c, item)))
+	return nil
+}
+
+func (r *resource) delete(c *gin.Context) error {
+	item, err := r.load(c)
+	if err != nil {
+		return err
+	}
+	if err := r.authorize(c, ResourceDelete, item); err != nil {
+		return err
+	}
+	id := r.primary.value(item).Interface()
+	if err := r.repo().Delete(item, r.primary.column+" = ?", id); err != nil {
+		return err
+	}
+	c.JSON(http.StatusOK, NewJSONResult().Success())
+	return nil
+}
+
+// output 调用 Mask 后删除 Hidden 中的字段
+func (r *resource) output(c *gin.Context, item interface{}) interface{} {
+	if r.option.Mask != nil {
+		item = r.option.Mask(c, item)
+	}
+	if len(r.hidden) == 0 {
+		return item
+	}
+	buff, err := json.Marshal(item)
+	if err != nil {
+		return item
+	}
+	// 使用 json.Number 保留大整数的精度
+	values := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(buff))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return item
+	}
+	for name := range r.hidden {
+		delete(values, name)
+	}
+	return values
+}
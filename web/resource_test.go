@@ -0,0 +1,154 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/zhin/go-codex/database"
+)
+
+type resourceUser struct {
+	ID       int64  `gorm:"column:id;primary_key;auto_increment" json:"id"`
+	Name     string `gorm:"column:name" json:"name" binding:"required"`
+	Age      int    `gorm:"column:age" json:"age"`
+	Password string `gorm:"column:password" json:"password"`
+	Score    int64  `gorm:"column:score" json:"score"`
+}
+
+func TestResource_FilterAndSort(t *testing.T) {
+	r := newResource(&resourceUser{}, ResourceOptions{Hidden: []string{"password"}})
+	if r.filterable["password"] || r.writable["password"] || r.writable["id"] || !r.writable["name"] {
+		t.Fatalf("unexpected default fields filterable:%v writable:%v", r.filterable, r.writable)
+	}
+
+	cases := []struct {
+		key, value string
+		condition  string
+		params     []interface{}
+	}{
+		{"name", "bob", "name = ?", []interface{}{"bob"}},
+		{"age__gte", "18", "age >= ?", []interface{}{18}},
+		{"name__like", "b%", "name LIKE ?", []interface{}{"b%"}},
+		{"age__in", "1,2", "age IN (?)", []interface{}{[]interface{}{1, 2}}},
+		{"age__null", "false", "age IS NOT NULL", nil},
+	}
+	for _, item := range cases {
+		condition, params, err := r.filter("en", item.key, item.value)
+		if err != nil || condition != item.condition || !reflect.DeepEqual(params, item.params) {
+			t.Errorf("%s: got %s %v %v", item.key, condition, params, err)
+		}
+	}
+	for key, rule := range map[string]string{"password": "filter", "age__regexp": "filter", "unknow": "filter", "age": "type"} {
+		if _, _, err := r.filter("en", key, "x"); err == nil || err.Rule != rule {
+			t.Errorf("%s: expected %s error, got %v", key, rule, err)
+		}
+	}
+
+	if order, errs := r.order("en", "-age, name"); len(errs) > 0 || order != "age DESC, name ASC" {
+		t.Fatalf("unexpected order %s %v", order, errs)
+	}
+	if _, errs := r.order("en", "-password,name"); len(errs) != 1 || errs[0].Field != "password" || errs[0].Rule != "sort" {
+		t.Fatalf("hidden field should not be sortable, got %v", errs)
+	}
+
+	// 明确列出时可以使用 Hidden 中的字段
+	r = newResource(&resourceUser{}, ResourceOptions{Fields: []string{"name"}, Writable: []string{"name", "password"}, Hidden: []string{"password"}})
+	if r.filterable["age"] || !r.writable["password"] {
+		t.Fatalf("unexpected explicit fields filterable:%v writable:%v", r.filterable, r.writable)
+	}
+}
+
+func TestResource_API(t *testing.T) {
+	dbKey := "resource_" + t.Name()
+	database.SetDBSet(dbKey, database.DBSetOption{
+		DBType:             "sqlite3",
+		DBConnectionString: filepath.Join(t.TempDir(), "resource.db"),
+		MaxOpenConns:       1,
+		MaxIdleConns:       1,
+	})
+	if err := database.AutoMigrate(dbKey, &resourceUser{}); err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	Resource(engine, "/users", &resourceUser{}, ResourceOptions{Repo: database.Choice(dbKey), Hidden: []string{"password"}, DefaultSort: "id"})
+
+	call := func(method string, path string, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		result := map[string]interface{}{}
+		decoder := json.NewDecoder(w.Body)
+		decoder.UseNumber()
+		decoder.Decode(&result)
+		return w.Code, result
+	}
+
+	for _, body := range []string{
+		`{"name":"alice","age":30,"password":"secret","score":9007199254740993}`,
+		`{"name":"bob","age":20,"id":100}`,
+		`{"name":"carol","age":40}`,
+	} {
+		if code, result := call(http.MethodPost, "/users", body); code != http.StatusOK {
+			t.Fatalf("create got %d %v", code, result)
+		}
+	}
+
+	code, result := call(http.MethodGet, "/users?age__gte=25&sort=-age", "")
+	data, _ := result["data"].(map[string]interface{})
+	if code != http.StatusOK || data == nil || data["total"] != json.Number("2") {
+		t.Fatalf("unexpected list %d %v", code, result)
+	}
+	items := data["items"].([]interface{})
+	first := items[0].(map[string]interface{})
+	if first["name"] != "carol" || first["password"] != nil {
+		t.Fatalf("unexpected first item %v", first)
+	}
+
+	_, result = call(http.MethodGet, "/users/1", "")
+	item := result["data"].(map[string]interface{})
+	if item["score"] != json.Number("9007199254740993") || item["password"] != nil {
+		t.Fatalf("unexpected item %v", item)
+	}
+	// 不可写入的字段被忽略
+	if code, result := call(http.MethodGet, "/users/100", ""); code != http.StatusNotFound || result[codeField] != json.Number("122") {
+		t.Fatalf("primary key should not be writable, got %d %v", code, result)
+	}
+	if code, result := call(http.MethodGet, "/users?password=secret", ""); code != http.StatusBadRequest {
+		t.Fatalf("hidden field should not be filterable, got %d %v", code, result)
+	}
+
+	if code, result := call(http.MethodDelete, "/users/100", ""); code != http.StatusNotFound || result[codeField] != json.Number("122") {
+		t.Fatalf("delete missing got %d %v", code, result)
+	}
+
+	call(http.MethodPatch, "/users/1", `{"age":31,"password":"changed"}`)
+	user := &resourceUser{}
+	database.Choice(dbKey).First(user, "id = ?", 1)
+	if user.Age != 31 || user.Password != "" {
+		t.Fatalf("unexpected updated user %+v", user)
+	}
+}
+
+func TestResource_OpenAPIHidden(t *testing.T) {
+	engine := gin.New()
+	Resource(engine, "/users", &resourceUser{}, ResourceOptions{Repo: database.Choice("resource_openapi"), Hidden: []string{"password"}})
+	buff, err := json.Marshal(OpenAPI(engine, OpenAPIOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buff, []byte(`"password"`)) {
+		t.Fatalf("hidden field should not be documented: %s", buff)
+	}
+	if !bytes.Contains(buff, []byte(`"score"`)) {
+		t.Fatalf("visible field should be documented: %s", buff)
+	}
+}
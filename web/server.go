@@ -0,0 +1,279 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zhin/go-codex/configs"
+)
+
+const (
+	webListenSettingKey                = "web_listen"
+	webTLSCertSettingKey               = "web_tls_cert"
+	webTLSKeySettingKey                = "web_tls_key"
+	webReadTimeoutSecondSettingKey     = "web_read_timeout_second"
+	webWriteTimeoutSecondSettingKey    = "web_write_timeout_second"
+	webIdleTimeoutSecondSettingKey     = "web_idle_timeout_second"
+	webMaxHeaderBytesSettingKey        = "web_max_header_bytes"
+	webShutdownTimeoutSecondSettingKey = "web_shutdown_timeout_second"
+)
+
+// RequestIDHeader 请求 ID，请求中已有时沿用，否则生成新的
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDContextKey struct{}
+
+func init() {
+	configs.Settings.SetDefault(webListenSettingKey, ":8080")
+	configs.Settings.SetDefault(webReadTimeoutSecondSettingKey, 30)
+	// 大于 pprof 默认 30 秒的采样时间，更长的采样 pprof 会直接返回错误
+	configs.Settings.SetDefault(webWriteTimeoutSecondSettingKey, 60)
+	configs.Settings.SetDefault(webIdleTimeoutSecondSettingKey, 120)
+	configs.Settings.SetDefault(webMaxHeaderBytesSettingKey, 1<<20)
+	configs.Settings.SetDefault(webShutdownTimeoutSecondSettingKey, 30)
+}
+
+// RunOptions 为空的字段使用配置中的值
+type RunOptions struct {
+	// Engine 默认 Default
+	Engine *gin.Engine
+	Addr   string
+	// CertFile、KeyFile 都不为空时使用 HTTPS
+	CertFile        string
+	KeyFile         string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	MaxHeaderBytes  int
+	ShutdownTimeout time.Duration
+	// Signals 触发关闭的信号，默认 SIGINT、SIGTERM
+	Signals []os.Signal
+	// DisableMiddleware 不安装 recovery、访问日志及请求 ID
+	DisableMiddleware bool
+	// Logger 访问日志，默认使用 log 包
+	Logger *log.Logger
+}
+
+type shutdownHook struct {
+	name string
+	hook func(ctx context.Context) error
+}
+
+var shutdownHooks = []shutdownHook{}
+var shutdownHooksLock = sync.Mutex{}
+
+// OnShutdown 注册关闭时执行的操作，如关闭数据库连接、redis 客户端，在请求处理完成后按注册顺序执行
+func OnShutdown(name string, hook func(ctx context.Context) error) {
+	shutdownHooksLock.Lock()
+	defer shutdownHooksLock.Unlock()
+	shutdownHooks = append(shutdownHooks, shutdownHook{name: name, hook: hook})
+}
+
+func runShutdownHooks(ctx context.Context) error {
+	shutdownHooksLock.Lock()
+	hooks := append([]shutdownHook{}, shutdownHooks...)
+	shutdownHooksLock.Unlock()
+
+	var first error
+	for _, item := range hooks {
+		if err := item.hook(ctx); err != nil {
+			log.Println(fmt.Sprintf("web shutdown hook \"%s\" error:%s", item.name, err.Error()))
+			if first == nil {
+				first = fmt.Errorf("shutdown hook \"%s\" error:%s", item.name, err.Error())
+			}
+		}
+	}
+	return first
+}
+
+// Run 启动服务，收到信号后停止接收新连接，等待处理中的请求最多 ShutdownTimeout，然后执行 OnShutdown 注册的操作
+func Run(option RunOptions) error {
+	if option.Engine == nil {
+		option.Engine = Default
+	}
+	if option.Addr == "" {
+		option.Addr = configs.Settings.GetString(webListenSettingKey)
+	}
+	if option.CertFile == "" && option.KeyFile == "" {
+		option.CertFile = configs.Settings.GetString(webTLSCertSettingKey)
+		option.KeyFile = configs.Settings.GetString(webTLSKeySettingKey)
+	}
+	if option.ReadTimeout == 0 {
+		option.ReadTimeout = time.Duration(configs.Settings.GetInt(webReadTimeoutSecondSettingKey)) * time.Second
+	}
+	if option.WriteTimeout == 0 {
+		option.WriteTimeout = time.Duration(configs.Settings.GetInt(webWriteTimeoutSecondSettingKey)) * time.Second
+	}
+	if option.IdleTimeout == 0 {
+		option.IdleTimeout = time.Duration(configs.Settings.GetInt(webIdleTimeoutSecondSettingKey)) * time.Second
+	}
+	if option.MaxHeaderBytes == 0 {
+		option.MaxHeaderBytes = configs.Settings.GetInt(webMaxHeaderBytesSettingKey)
+	}
+	if option.ShutdownTimeout == 0 {
+		option.ShutdownTimeout = time.Duration(configs.Settings.GetInt(webShutdownTimeoutSecondSettingKey)) * time.Second
+	}
+	if len(option.Signals) == 0 {
+		option.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	var handler http.Handler = option.Engine
+	if !option.DisableMiddleware {
+		handler = ServerMiddleware(handler, option.Logger)
+	}
+	server := &http.Server{
+		Addr:           option.Addr,
+		Handler:        handler,
+		ReadTimeout:    option.ReadTimeout,
+		WriteTimeout:   option.WriteTimeout,
+		IdleTimeout:    option.IdleTimeout,
+		MaxHeaderBytes: option.MaxHeaderBytes,
+	}
+
+	listener, err := net.Listen("tcp", option.Addr)
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if option.CertFile != "" && option.KeyFile != "" {
+			log.Println(fmt.Sprintf("web listen on https://%s", listener.Addr().String()))
+			serveErr <- server.ServeTLS(listener, option.CertFile, option.KeyFile)
+		} else {
+			log.Println(fmt.Sprintf("web listen on http://%s", listener.Addr().String()))
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, option.Signals...)
+	defer signal.Stop(signals)
+
+	select {
+	case err = <-serveErr:
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+	case sig := <-signals:
+		log.Println(fmt.Sprintf("web receive %s, shutting down", sig.String()))
+		ctx, cancel := context.WithTimeout(context.Background(), option.ShutdownTimeout)
+		if err = server.Shutdown(ctx); err != nil {
+			// 超时后强制关闭剩余连接
+			server.Close()
+			err = fmt.Errorf("web shutdown error:%s", err.Error())
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), option.ShutdownTimeout)
+	defer cancel()
+	if hookErr := runShutdownHooks(ctx); err == nil {
+		err = hookErr
+	}
+	return err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, fmt.Errorf("response writer does not support hijack")
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// CloseNotify gin 的 c.Stream 需要 http.CloseNotifier
+func (w *statusRecorder) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// ServerMiddleware 在 gin 之外处理请求 ID、panic 恢复及访问日志，Run 时自动安装，
+// 对 Run 之前注册的路由同样生效
+func ServerMiddleware(next http.Handler, logger *log.Logger) http.Handler {
+	printf := log.Printf
+	if logger != nil {
+		printf = logger.Printf
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.NewV4().String()
+			r.Header.Set(RequestIDHeader, requestID)
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID))
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				printf("[web] %s panic:%v\n%s", requestID, err, debug.Stack())
+				result := NewJSONResult().Error(fmt.Errorf("panic:%v", err))
+				if recorder.status == 0 {
+					recorder.Header().Set("Content-Type", "application/json; charset=utf-8")
+					recorder.WriteHeader(http.StatusInternalServerError)
+					buff, _ := result.MarshalJSON()
+					recorder.Write(buff)
+				} else {
+					recorder.status = http.StatusInternalServerError
+				}
+			}
+			printf("[web] %s %d %s %s %s %d %s", requestID, recorder.status, r.Method, r.URL.RequestURI(),
+				time.Since(start).String(), recorder.size, r.RemoteAddr)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// RequestID 返回当前请求的 ID
+func RequestID(c *gin.Context) string {
+	if requestID, ok := c.Request.Context().Value(requestIDContextKey{}).(string); ok {
+		return requestID
+	}
+	return c.GetHeader(RequestIDHeader)
+}
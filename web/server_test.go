@@ -0,0 +1,101 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerMiddleware(t *testing.T) {
+	engine := gin.New()
+	engine.GET("/id", func(c *gin.Context) {
+		c.String(http.StatusOK, RequestID(c))
+	})
+	engine.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	buff := &bytes.Buffer{}
+	handler := ServerMiddleware(engine, log.New(buff, "", 0))
+
+	req := httptest.NewRequest(http.MethodGet, "/id", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Body.String() != "req-1" || w.Header().Get(RequestIDHeader) != "req-1" {
+		t.Fatalf("unexpected request id %s %v", w.Body.String(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get(RequestIDHeader) == "" {
+		t.Fatalf("panic should be recovered, got %d", w.Code)
+	}
+	if !strings.Contains(buff.String(), "req-1 200 GET /id") || !strings.Contains(buff.String(), " 500 GET /panic") {
+		t.Fatalf("unexpected access log %s", buff.String())
+	}
+	if !strings.Contains(buff.String(), "panic:boom") || !strings.Contains(buff.String(), "server_test.go") {
+		t.Fatalf("panic log should contain the stack, got %s", buff.String())
+	}
+}
+
+func TestServerMiddleware_Stream(t *testing.T) {
+	engine := gin.New()
+	engine.GET("/stream", func(c *gin.Context) {
+		count := 0
+		c.Stream(func(w io.Writer) bool {
+			count++
+			fmt.Fprintf(w, "%d\n", count)
+			return count < 3
+		})
+	})
+	server := httptest.NewServer(ServerMiddleware(engine, log.New(ioutil.Discard, "", 0)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "1\n2\n3\n" {
+		t.Fatalf("unexpected stream %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestOnShutdown(t *testing.T) {
+	shutdownHooksLock.Lock()
+	saved := shutdownHooks
+	shutdownHooks = nil
+	shutdownHooksLock.Unlock()
+	defer func() {
+		shutdownHooksLock.Lock()
+		shutdownHooks = saved
+		shutdownHooksLock.Unlock()
+	}()
+
+	order := []string{}
+	OnShutdown("db", func(ctx context.Context) error {
+		order = append(order, "db")
+		return fmt.Errorf("close error")
+	})
+	OnShutdown("redis", func(ctx context.Context) error {
+		order = append(order, "redis")
+		return nil
+	})
+	err := runShutdownHooks(context.Background())
+	if strings.Join(order, ",") != "db,redis" {
+		t.Fatalf("hooks should run in order, got %v", order)
+	}
+	if err == nil || !strings.Contains(err.Error(), "\"db\"") {
+		t.Fatalf("unexpected hook error %v", err)
+	}
+}
@@ -0,0 +1,629 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zhin/go-codex/cerror"
+	"github.com/zhin/go-codex/configs"
+	"github.com/zhin/go-codex/database"
+	"github.com/zhin/go-codex/rds"
+	"github.com/zhin/go-codex/utils"
+)
+
+const (
+	sessionStoreSettingKey                 = "session_store"
+	sessionCookieKeysSettingKey            = "session_cookie_keys"
+	sessionCookieNameSettingKey            = "session_cookie_name"
+	sessionCookieSecureSettingKey          = "session_cookie_secure"
+	sessionIdleTimeoutSecondSettingKey     = "session_idle_timeout_second"
+	sessionAbsoluteTimeoutSecondSettingKey = "session_absolute_timeout_second"
+)
+
+const sessionContextKey = "codex.session"
+
+func init() {
+	configs.Settings.SetDefault(sessionCookieNameSettingKey, "codex_session")
+	configs.Settings.SetDefault(sessionIdleTimeoutSecondSettingKey, 30*60)
+	configs.Settings.SetDefault(sessionAbsoluteTimeoutSecondSettingKey, 12*3600)
+}
+
+// SessionStore 保存会话内容，Load 的参数为 cookie 的值，找不到时返回 nil，Save 返回写入 cookie 的值
+type SessionStore interface {
+	Load(cookie string) ([]byte, error)
+	Save(id string, data []byte, ttl time.Duration) (string, error)
+	Delete(id string) error
+}
+
+// CookieSessionStore 会话内容使用 EC-1 加密后保存在 cookie 中，不需要服务端存储，
+// 但 Delete 无法让已经发出的 cookie 失效，内容大小受 cookie 限制
+type CookieSessionStore struct {
+	Keyring *utils.EC1Keyring
+}
+
+// NewCookieSessionStore 第一个密钥用于加密，其他密钥只用于解密轮换前的 cookie
+func NewCookieSessionStore(keys ...[]byte) (*CookieSessionStore, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cookie session store needs keys")
+	}
+	keyring := utils.NewEC1Keyring()
+	// 有效期由会话自身的超时控制，同一个 cookie 会重复使用
+	keyring.MaxSkew = 0
+	keyring.Nonces = nil
+	// key_id 使用密钥的摘要，添加新密钥后旧 cookie 仍能找到对应的密钥
+	for _, key := range keys {
+		sum := sha256.Sum256(key)
+		if err := keyring.AddKey(hex.EncodeToString(sum[:4]), key); err != nil {
+			return nil, err
+		}
+	}
+	return &CookieSessionStore{Keyring: keyring}, nil
+}
+
+func (s *CookieSessionStore) Load(cookie string) ([]byte, error) {
+	buff, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil {
+		return nil, nil
+	}
+	envelope, err := s.Keyring.Open(buff)
+	if err != nil {
+		// 篡改或密钥已删除的 cookie 视为没有会话
+		return nil, nil
+	}
+	return envelope.Plaintext, nil
+}
+
+func (s *CookieSessionStore) Save(id string, data []byte, ttl time.Duration) (string, error) {
+	buff, err := s.Keyring.Seal(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buff), nil
+}
+
+func (s *CookieSessionStore) Delete(id string) error {
+	return nil
+}
+
+type memorySessionItem struct {
+	data   []byte
+	expire time.Time
+}
+
+type memorySessionStore struct {
+	lock  sync.Mutex
+	items map[string]memorySessionItem
+	swept time.Time
+}
+
+// NewMemorySessionStore 单实例使用，重启后会话失效
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{items: map[string]memorySessionItem{}}
+}
+
+func (s *memorySessionStore) Load(cookie string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if item, found := s.items[cookie]; found && time.Now().Before(item.expire) {
+		return item.data, nil
+	}
+	return nil, nil
+}
+
+func (s *memorySessionStore) Save(id string, data []byte, ttl time.Duration) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// 每分钟删除一次过期的会话
+	now := time.Now()
+	if now.Sub(s.swept) >= time.Minute {
+		s.swept = now
+		for key, item := range s.items {
+			if now.After(item.expire) {
+				delete(s.items, key)
+			}
+		}
+	}
+	s.items[id] = memorySessionItem{data: data, expire: now.Add(ttl)}
+	return id, nil
+}
+
+func (s *memorySessionStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.items, id)
+	return nil
+}
+
+// RedisSessionStore Client 为空时使用 rds.Default
+type RedisSessionStore struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (s *RedisSessionStore) client() (*redis.Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis session store needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (s *RedisSessionStore) key(id string) string {
+	if s.Prefix == "" {
+		return "codex:session:" + id
+	}
+	return s.Prefix + id
+}
+
+func (s *RedisSessionStore) Load(cookie string) ([]byte, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	buff, err := client.Get(s.key(cookie)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return buff, err
+}
+
+func (s *RedisSessionStore) Save(id string, data []byte, ttl time.Duration) (string, error) {
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+	return id, client.Set(s.key(id), data, ttl).Err()
+}
+
+func (s *RedisSessionStore) Delete(id string) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	return client.Del(s.key(id)).Err()
+}
+
+// SessionRecord DatabaseSessionStore 使用的表
+type SessionRecord struct {
+	ID        string    `gorm:"column:id;primary_key"`
+	Data      string    `gorm:"column:data;type:text"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index"`
+}
+
+func (SessionRecord) TableName() string {
+	return "web_sessions"
+}
+
+// DatabaseSessionStore 保存到 web_sessions 表，过期的记录由 Cleanup 删除，Repo 为空时使用 database.GetDefault()
+type DatabaseSessionStore struct {
+	Repo *database.DatabaseRepo
+}
+
+func (s *DatabaseSessionStore) repo() *database.DatabaseRepo {
+	if s.Repo == nil {
+		return database.GetDefault()
+	}
+	return s.Repo
+}
+
+func (s *DatabaseSessionStore) Load(cookie string) ([]byte, error) {
+	record := &SessionRecord{}
+	result := s.repo().First(record, "id = ? AND expires_at > ?", cookie, time.Now().UTC())
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if result.IsRecordNotFound {
+		return nil, nil
+	}
+	return []byte(record.Data), nil
+}
+
+func (s *DatabaseSessionStore) Save(id string, data []byte, ttl time.Duration) (string, error) {
+	record := &SessionRecord{ID: id, Data: string(data), ExpiresAt: time.Now().Add(ttl).UTC()}
+	return id, s.repo().Save(record)
+}
+
+func (s *DatabaseSessionStore) Delete(id string) error {
+	return s.repo().Delete(&SessionRecord{}, "id = ?", id)
+}
+
+// Cleanup 删除过期的会话
+func (s *DatabaseSessionStore) Cleanup() error {
+	return s.repo().Delete(&SessionRecord{}, "expires_at <= ?", time.Now().UTC())
+}
+
+// NewSessionStore 按配置 session_store 创建：memory、redis、database 或 cookie，cookie 使用 session_cookie_keys 中 base64 编码的密钥。
+// 没有配置时有 rds.Default 使用 redis，否则使用内存
+func NewSessionStore() (SessionStore, error) {
+	switch store := configs.Settings.GetString(sessionStoreSettingKey); store {
+	case "":
+		if rds.Default != nil {
+			return &RedisSessionStore{}, nil
+		}
+		return NewMemorySessionStore(), nil
+	case "memory":
+		return NewMemorySessionStore(), nil
+	case "redis":
+		if rds.Default == nil {
+			return nil, fmt.Errorf("session store \"redis\" needs rds.Default")
+		}
+		return &RedisSessionStore{}, nil
+	case "database":
+		return &DatabaseSessionStore{}, nil
+	case "cookie":
+		keys := [][]byte{}
+		for _, value := range configs.Settings.GetStringSlice(sessionCookieKeysSettingKey) {
+			key, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("session cookie key error:%s", err.Error())
+			}
+			keys = append(keys, key)
+		}
+		return NewCookieSessionStore(keys...)
+	default:
+		return nil, fmt.Errorf("unknow session store \"%s\"", store)
+	}
+}
+
+type sessionData struct {
+	ID         string                 `json:"id"`
+	Values     map[string]interface{} `json:"values"`
+	Flashes    map[string][]string    `json:"flashes,omitempty"`
+	CSRF       string                 `json:"csrf,omitempty"`
+	CreatedAt  int64                  `json:"created_at"`
+	LastAccess int64                  `json:"last_access"`
+}
+
+// SessionContext 当前请求的会话，由 Session(c) 获取。
+// 值经过 JSON 编码保存，读取时数字为 float64
+type SessionContext struct {
+	lock      sync.Mutex
+	data      sessionData
+	option    *SessionOptions
+	store     SessionStore
+	writer    http.ResponseWriter
+	isNew     bool
+	dirty     bool
+	oldID     string
+	destroyed bool
+	committed bool
+}
+
+func newSessionData() sessionData {
+	now := time.Now().Unix()
+	return sessionData{ID: uuid.NewV4().String(), Values: map[string]interface{}{}, CreatedAt: now, LastAccess: now}
+}
+
+func (s *SessionContext) ID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data.ID
+}
+
+// IsNew 本次请求之前没有会话
+func (s *SessionContext) IsNew() bool {
+	return s.isNew
+}
+
+func (s *SessionContext) Get(key string) interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data.Values[key]
+}
+
+func (s *SessionContext) GetString(key string) string {
+	if val, ok := s.Get(key).(string); ok {
+		return val
+	}
+	return ""
+}
+
+func (s *SessionContext) Set(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Values[key] = value
+	s.dirty = true
+}
+
+func (s *SessionContext) Delete(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.data.Values, key)
+	s.dirty = true
+}
+
+// Clear 清空所有值，会话 ID 不变
+func (s *SessionContext) Clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Values = map[string]interface{}{}
+	s.data.Flashes = nil
+	s.dirty = true
+}
+
+// AddFlash 添加一条消息，在下一次 Flashes 读取后删除
+func (s *SessionContext) AddFlash(kind string, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.Flashes == nil {
+		s.data.Flashes = map[string][]string{}
+	}
+	s.data.Flashes[kind] = append(s.data.Flashes[kind], message)
+	s.dirty = true
+}
+
+// Flashes 读取并删除消息
+func (s *SessionContext) Flashes(kind string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	messages := s.data.Flashes[kind]
+	if len(messages) > 0 {
+		delete(s.data.Flashes, kind)
+		s.dirty = true
+	}
+	return messages
+}
+
+// Rotate 更换会话 ID 及 CSRF 令牌并保留内容，登录、提升权限后调用以防止会话固定攻击
+func (s *SessionContext) Rotate() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.data.ID
+	}
+	s.data.ID = uuid.NewV4().String()
+	s.data.CSRF = ""
+	s.data.CreatedAt = time.Now().Unix()
+	s.dirty = true
+}
+
+// Destroy 删除会话，退出登录时调用
+func (s *SessionContext) Destroy() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.data.ID
+	}
+	s.data = newSessionData()
+	s.destroyed = true
+	s.dirty = false
+}
+
+// CSRFToken 返回会话的 CSRF 令牌，没有时生成
+func (s *SessionContext) CSRFToken() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.data.CSRF == "" {
+		random := make([]byte, 32)
+		rand.Read(random)
+		s.data.CSRF = base64.RawURLEncoding.EncodeToString(random)
+		s.dirty = true
+	}
+	return s.data.CSRF
+}
+
+func (s *SessionContext) verifyCSRF(token string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.data.CSRF != "" && hmac.Equal([]byte(s.data.CSRF), []byte(token))
+}
+
+// commit 在写入响应头之前保存会话并设置 cookie，只执行一次
+func (s *SessionContext) commit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.committed {
+		return
+	}
+	s.committed = true
+	option := s.option
+
+	if s.oldID != "" {
+		if err := s.store.Delete(s.oldID); err != nil {
+			triggerErrorHandles(uuid.NewV4().String(), err)
+		}
+	}
+	expire := func() {
+		http.SetCookie(s.writer, &http.Cookie{Name: option.CookieName, Path: option.Path, Domain: option.Domain, MaxAge: -1, Secure: option.Secure, HttpOnly: true})
+	}
+	// Destroy 之后又写入了内容时以新的会话 ID 保存
+	if s.destroyed && !s.dirty {
+		expire()
+		return
+	}
+
+	// 空闲超时按最后访问时间计算，每分钟最多更新一次
+	now := time.Now().Unix()
+	if now-s.data.LastAccess >= 60 {
+		s.data.LastAccess = now
+		s.dirty = true
+	}
+	if !s.dirty {
+		return
+	}
+	s.data.LastAccess = now
+	data, err := json.Marshal(&s.data)
+	if err != nil {
+		triggerErrorHandles(uuid.NewV4().String(), err)
+		return
+	}
+	ttl := option.IdleTimeout
+	if remain := time.Unix(s.data.CreatedAt, 0).Add(option.AbsoluteTimeout).Sub(time.Now()); remain < ttl {
+		ttl = remain
+	}
+	// 已经超过最长有效期，ttl 为 0 时 redis 等存储会永久保存
+	if ttl < time.Second {
+		if !s.isNew && !s.destroyed {
+			if err := s.store.Delete(s.data.ID); err != nil {
+				triggerErrorHandles(uuid.NewV4().String(), err)
+			}
+		}
+		expire()
+		return
+	}
+	value, err := s.store.Save(s.data.ID, data, ttl)
+	if err != nil {
+		triggerErrorHandles(uuid.NewV4().String(), err)
+		return
+	}
+	http.SetCookie(s.writer, &http.Cookie{
+		Name:     option.CookieName,
+		Value:    value,
+		Path:     option.Path,
+		Domain:   option.Domain,
+		Expires:  time.Now().Add(ttl),
+		MaxAge:   int(ttl / time.Second),
+		Secure:   option.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+type sessionResponseWriter struct {
+	gin.ResponseWriter
+	session *SessionContext
+}
+
+func (w *sessionResponseWriter) WriteHeaderNow() {
+	w.session.commit()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sessionResponseWriter) Write(data []byte) (int, error) {
+	w.session.commit()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *sessionResponseWriter) WriteString(s string) (int, error) {
+	w.session.commit()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *sessionResponseWriter) Flush() {
+	w.session.commit()
+	w.ResponseWriter.Flush()
+}
+
+// SessionOptions 为空的字段使用配置中的值
+type SessionOptions struct {
+	// Store 默认使用 NewSessionStore，创建失败时 panic
+	Store      SessionStore
+	CookieName string
+	// Path 默认 /
+	Path   string
+	Domain string
+	Secure bool
+	// IdleTimeout 最后一次访问后的有效期
+	IdleTimeout time.Duration
+	// AbsoluteTimeout 创建或 Rotate 之后的最长有效期
+	AbsoluteTimeout time.Duration
+	// CSRF 校验 POST、PUT、PATCH、DELETE 请求的 CSRFHeader 头或 CSRFField 表单字段
+	CSRF       bool
+	CSRFHeader string
+	CSRFField  string
+}
+
+// Sessions 会话中间件，handler 中使用 Session(c) 读写会话
+func Sessions(option SessionOptions) gin.HandlerFunc {
+	if option.CookieName == "" {
+		option.CookieName = configs.Settings.GetString(sessionCookieNameSettingKey)
+	}
+	if option.Path == "" {
+		option.Path = "/"
+	}
+	if !option.Secure {
+		option.Secure = configs.Settings.GetBool(sessionCookieSecureSettingKey)
+	}
+	if option.IdleTimeout <= 0 {
+		option.IdleTimeout = time.Duration(configs.Settings.GetInt(sessionIdleTimeoutSecondSettingKey)) * time.Second
+	}
+	if option.AbsoluteTimeout <= 0 {
+		option.AbsoluteTimeout = time.Duration(configs.Settings.GetInt(sessionAbsoluteTimeoutSecondSettingKey)) * time.Second
+	}
+	if option.CSRFHeader == "" {
+		option.CSRFHeader = "X-CSRF-Token"
+	}
+	if option.CSRFField == "" {
+		option.CSRFField = "_csrf"
+	}
+	if option.Store == nil {
+		store, err := NewSessionStore()
+		if err != nil {
+			panic(err)
+		}
+		option.Store = store
+	}
+
+	return func(c *gin.Context) {
+		session := &SessionContext{option: &option, store: option.Store, writer: c.Writer}
+		if cookie, err := c.Cookie(option.CookieName); err == nil && cookie != "" {
+			buff, err := option.Store.Load(cookie)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+				return
+			}
+			if buff != nil && json.Unmarshal(buff, &session.data) == nil {
+				now := time.Now()
+				if now.Sub(time.Unix(session.data.LastAccess, 0)) > option.IdleTimeout ||
+					now.Sub(time.Unix(session.data.CreatedAt, 0)) > option.AbsoluteTimeout {
+					session.oldID = session.data.ID
+					session.data = sessionData{}
+				}
+			} else {
+				session.data = sessionData{}
+			}
+		}
+		if session.data.ID == "" {
+			session.data = newSessionData()
+			session.isNew = true
+		}
+		if session.data.Values == nil {
+			session.data.Values = map[string]interface{}{}
+		}
+		c.Set(sessionContextKey, session)
+
+		if option.CSRF {
+			switch c.Request.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				token := c.GetHeader(option.CSRFHeader)
+				if token == "" {
+					token = c.PostForm(option.CSRFField)
+				}
+				if !session.verifyCSRF(token) {
+					c.AbortWithStatusJSON(http.StatusForbidden, NewJSONResult().Error(cerror.FORBIDDEN, "CSRF 校验失败"))
+					return
+				}
+			}
+		}
+
+		writer := c.Writer
+		c.Writer = &sessionResponseWriter{ResponseWriter: writer, session: session}
+		c.Next()
+		session.commit()
+		c.Writer = writer
+	}
+}
+
+// Session 返回当前请求的会话，没有使用 Sessions 中间件时返回 nil
+func Session(c *gin.Context) *SessionContext {
+	if val, found := c.Get(sessionContextKey); found {
+		if session, ok := val.(*SessionContext); ok {
+			return session
+		}
+	}
+	return nil
+}
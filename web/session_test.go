@@ -0,0 +1,228 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/zhin/go-codex/configs"
+)
+
+// testSessionStore cookie 的值即会话 ID
+type testSessionStore struct {
+	lock  sync.Mutex
+	items map[string][]byte
+	ttl   time.Duration
+}
+
+func (s *testSessionStore) Load(cookie string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.items[cookie], nil
+}
+
+func (s *testSessionStore) Save(id string, data []byte, ttl time.Duration) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.items[id] = data
+	s.ttl = ttl
+	return id, nil
+}
+
+func (s *testSessionStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.items, id)
+	return nil
+}
+
+type sessionTestClient struct {
+	engine *gin.Engine
+	cookie string
+}
+
+func (c *sessionTestClient) do(method string, path string, form url.Values, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	if c.cookie != "" {
+		req.AddCookie(&http.Cookie{Name: "codex_session", Value: c.cookie})
+	}
+	w := httptest.NewRecorder()
+	c.engine.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "codex_session" {
+			c.cookie = cookie.Value
+			if cookie.MaxAge < 0 {
+				c.cookie = ""
+			}
+		}
+	}
+	return w
+}
+
+func newSessionTestEngine(store SessionStore) *gin.Engine {
+	engine := gin.New()
+	engine.Use(Sessions(SessionOptions{Store: store, CookieName: "codex_session", CSRF: true, IdleTimeout: time.Hour, AbsoluteTimeout: time.Hour}))
+	engine.GET("/csrf", func(c *gin.Context) {
+		c.String(http.StatusOK, Session(c).CSRFToken())
+	})
+	engine.GET("/user", func(c *gin.Context) {
+		c.String(http.StatusOK, Session(c).GetString("user"))
+	})
+	engine.POST("/login", func(c *gin.Context) {
+		session := Session(c)
+		session.Rotate()
+		session.Set("user", c.PostForm("user"))
+		c.String(http.StatusOK, session.ID())
+	})
+	engine.POST("/logout", func(c *gin.Context) {
+		Session(c).Destroy()
+		if message := c.PostForm("flash"); message != "" {
+			Session(c).AddFlash("info", message)
+		}
+		c.Status(http.StatusOK)
+	})
+	engine.GET("/flash", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Join(Session(c).Flashes("info"), ","))
+	})
+	engine.GET("/touch", func(c *gin.Context) {
+		Session(c).Set("touched", true)
+	})
+	return engine
+}
+
+func TestSessions_CSRFAndRotate(t *testing.T) {
+	store := &testSessionStore{items: map[string][]byte{}}
+	client := &sessionTestClient{engine: newSessionTestEngine(store)}
+
+	token := client.do(http.MethodGet, "/csrf", nil, nil).Body.String()
+	oldID := client.cookie
+	if token == "" || oldID == "" {
+		t.Fatal("session should be saved with csrf token")
+	}
+	if w := client.do(http.MethodPost, "/login", url.Values{"user": {"alice"}}, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("post without csrf token got %d", w.Code)
+	}
+	if w := client.do(http.MethodPost, "/login", url.Values{"user": {"alice"}}, map[string]string{"X-CSRF-Token": "wrong"}); w.Code != http.StatusForbidden {
+		t.Fatalf("post with wrong csrf token got %d", w.Code)
+	}
+
+	w := client.do(http.MethodPost, "/login", url.Values{"user": {"alice"}, "_csrf": {token}}, nil)
+	if w.Code != http.StatusOK || w.Body.String() == oldID || client.cookie != w.Body.String() {
+		t.Fatalf("login should rotate session id, got %d %s", w.Code, w.Body.String())
+	}
+	if _, found := store.items[oldID]; found {
+		t.Fatal("old session should be deleted after rotate")
+	}
+	if user := client.do(http.MethodGet, "/user", nil, nil).Body.String(); user != "alice" {
+		t.Fatalf("session value should be kept, got %s", user)
+	}
+	// Rotate 后旧的 CSRF 令牌失效
+	if w := client.do(http.MethodPost, "/logout", url.Values{}, map[string]string{"X-CSRF-Token": token}); w.Code != http.StatusForbidden {
+		t.Fatalf("old csrf token should be rejected, got %d", w.Code)
+	}
+
+	token = client.do(http.MethodGet, "/csrf", nil, nil).Body.String()
+	if w := client.do(http.MethodPost, "/logout", url.Values{}, map[string]string{"X-CSRF-Token": token}); w.Code != http.StatusOK || client.cookie != "" {
+		t.Fatalf("logout got %d cookie:%s", w.Code, client.cookie)
+	}
+	if len(store.items) != 0 {
+		t.Fatalf("destroyed session should be deleted, got %d", len(store.items))
+	}
+}
+
+func TestCookieSessionStore(t *testing.T) {
+	store, err := NewCookieSessionStore([]byte("new key"), []byte("old key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStore, _ := NewCookieSessionStore([]byte("old key"))
+	client := &sessionTestClient{engine: newSessionTestEngine(oldStore)}
+	token := client.do(http.MethodGet, "/csrf", nil, nil).Body.String()
+	client.do(http.MethodPost, "/login", url.Values{"user": {"bob"}, "_csrf": {token}}, nil)
+
+	// 新的密钥列表仍然可以读取使用旧密钥加密的 cookie
+	client.engine = newSessionTestEngine(store)
+	if user := client.do(http.MethodGet, "/user", nil, nil).Body.String(); user != "bob" {
+		t.Fatalf("cookie of old key should be readable, got %s", user)
+	}
+	client.cookie = client.cookie[:len(client.cookie)-2] + "AA"
+	if user := client.do(http.MethodGet, "/user", nil, nil).Body.String(); user != "" {
+		t.Fatalf("tampered cookie should be ignored, got %s", user)
+	}
+}
+
+func TestSessions_DestroyThenWrite(t *testing.T) {
+	store := &testSessionStore{items: map[string][]byte{}}
+	client := &sessionTestClient{engine: newSessionTestEngine(store)}
+	token := client.do(http.MethodGet, "/csrf", nil, nil).Body.String()
+	oldID := client.cookie
+
+	// 退出后添加的消息以新的会话保存
+	w := client.do(http.MethodPost, "/logout", url.Values{"flash": {"bye"}}, map[string]string{"X-CSRF-Token": token})
+	if w.Code != http.StatusOK || client.cookie == "" || client.cookie == oldID {
+		t.Fatalf("session written after destroy should get a new id, got %d cookie:%s", w.Code, client.cookie)
+	}
+	if _, found := store.items[oldID]; found || len(store.items) != 1 {
+		t.Fatalf("old session should be deleted, got %d items", len(store.items))
+	}
+	if flash := client.do(http.MethodGet, "/flash", nil, nil).Body.String(); flash != "bye" {
+		t.Fatalf("flash should be kept after destroy, got %s", flash)
+	}
+}
+
+func TestSessions_AbsoluteTimeout(t *testing.T) {
+	store := &testSessionStore{items: map[string][]byte{}}
+	client := &sessionTestClient{engine: newSessionTestEngine(store)}
+	client.do(http.MethodGet, "/touch", nil, nil)
+	id := client.cookie
+	if store.ttl <= 0 || store.ttl > time.Hour {
+		t.Fatalf("unexpected ttl %s", store.ttl)
+	}
+
+	// 最长有效期即将用完时不再保存，避免以 0 ttl 永久保存
+	data := sessionData{}
+	json.Unmarshal(store.items[id], &data)
+	data.CreatedAt = time.Now().Add(-time.Hour).Unix() + 1
+	store.items[id], _ = json.Marshal(&data)
+	store.ttl = -1
+	client.do(http.MethodGet, "/touch", nil, nil)
+	if store.ttl != -1 || client.cookie != "" {
+		t.Fatalf("expired session should not be saved, ttl:%s cookie:%s", store.ttl, client.cookie)
+	}
+	if _, found := store.items[id]; found {
+		t.Fatal("expired session should be deleted")
+	}
+}
+
+func TestNewSessionStore(t *testing.T) {
+	if store, err := NewSessionStore(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := store.(*memorySessionStore); !ok {
+		t.Fatalf("session store should fall back to memory without redis, got %T", store)
+	}
+	configs.Settings.Set(sessionStoreSettingKey, "redis")
+	defer configs.Settings.Set(sessionStoreSettingKey, "")
+	if _, err := NewSessionStore(); err == nil {
+		t.Fatal("redis session store without rds.Default should fail")
+	}
+
+	client := &sessionTestClient{engine: newSessionTestEngine(NewMemorySessionStore())}
+	token := client.do(http.MethodGet, "/csrf", nil, nil).Body.String()
+	client.do(http.MethodPost, "/login", url.Values{"user": {"carol"}, "_csrf": {token}}, nil)
+	if user := client.do(http.MethodGet, "/user", nil, nil).Body.String(); user != "carol" {
+		t.Fatalf("memory session store should keep values, got %s", user)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build go1.16
+// +build go1.16
+
+// Package swaggerui 嵌入 swagger-ui-dist，设置 web.OpenAPIOptions.UIFiles 后由 RegisterOpenAPI 提供
+package swaggerui
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed swagger-ui-bundle.js swagger-ui.css favicon-16x16.png favicon-32x32.png
+var files embed.FS
+
+// Assets 嵌入的 swagger-ui-dist
+func Assets() http.FileSystem {
+	return http.FS(files)
+}
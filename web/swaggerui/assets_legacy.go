@@ -0,0 +1,12 @@
+//go:build !go1.16
+// +build !go1.16
+
+// Package swaggerui 嵌入 swagger-ui-dist，设置 web.OpenAPIOptions.UIFiles 后由 RegisterOpenAPI 提供
+package swaggerui
+
+import "net/http"
+
+// Assets go1.16 以下不支持嵌入，返回 nil，RegisterOpenAPI 使用内置的简单页面
+func Assets() http.FileSystem {
+	return nil
+}
@@ -0,0 +1,643 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zhin/go-codex/rds"
+)
+
+const (
+	WebhookPending = "pending"
+	WebhookDone    = "done"
+	WebhookFailed  = "failed"
+)
+
+var (
+	ErrWebhookSignature = fmt.Errorf("webhook signature error")
+	ErrWebhookStale     = fmt.Errorf("webhook timestamp stale")
+)
+
+// WebhookEvent 收到的原始事件，保存后可以重放
+type WebhookEvent struct {
+	ID         string      `json:"id"`
+	Path       string      `json:"path"`
+	Header     http.Header `json:"header"`
+	Payload    []byte      `json:"payload"`
+	Timestamp  time.Time   `json:"timestamp"`
+	ReceivedAt time.Time   `json:"received_at"`
+	Attempts   int         `json:"attempts"`
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Decode 将 JSON 内容解析到 v
+func (e *WebhookEvent) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+type WebhookHandler func(event *WebhookEvent) error
+
+// WebhookVerifier 校验签名，返回签名中的时间戳，没有时间戳时返回零值
+type WebhookVerifier interface {
+	Verify(header http.Header, payload []byte) (time.Time, error)
+}
+
+func webhookSign(secret string, data []byte, encoding string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	if encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookMatch(secrets []string, data []byte, encoding string, signatures []string) bool {
+	for _, secret := range secrets {
+		expected := webhookSign(secret, data, encoding)
+		for _, signature := range signatures {
+			if hmac.Equal([]byte(expected), []byte(signature)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HMACSignature 签名头为 Prefix + HMAC-SHA256(payload)，如 GitHub 的 X-Hub-Signature-256: sha256=...
+type HMACSignature struct {
+	// Header 默认 X-Signature
+	Header string
+	// Secrets 任意一个匹配即通过，轮换密钥时同时配置新旧密钥
+	Secrets []string
+	Prefix  string
+	// Encoding hex（默认）或 base64
+	Encoding string
+}
+
+func (s *HMACSignature) Verify(header http.Header, payload []byte) (time.Time, error) {
+	name := s.Header
+	if name == "" {
+		name = "X-Signature"
+	}
+	signature := header.Get(name)
+	if signature == "" || !strings.HasPrefix(signature, s.Prefix) {
+		return time.Time{}, ErrWebhookSignature
+	}
+	if !webhookMatch(s.Secrets, payload, s.Encoding, []string{strings.TrimPrefix(signature, s.Prefix)}) {
+		return time.Time{}, ErrWebhookSignature
+	}
+	return time.Time{}, nil
+}
+
+// TimestampSignature 带时间戳的签名。
+// TimestampHeader 为空时签名头格式为 t=时间戳,v1=签名（Stripe），否则时间戳单独一个头，签名头为 v0=签名（Slack）
+type TimestampSignature struct {
+	// Header 默认 X-Signature
+	Header          string
+	TimestampHeader string
+	Secrets         []string
+	// Version 签名的键，默认 v1
+	Version string
+	// Format 生成待签名内容，默认 时间戳 + "." + payload
+	Format func(timestamp string, payload []byte) []byte
+	// Encoding hex（默认）或 base64
+	Encoding string
+}
+
+func (s *TimestampSignature) Verify(header http.Header, payload []byte) (time.Time, error) {
+	name := s.Header
+	if name == "" {
+		name = "X-Signature"
+	}
+	version := s.Version
+	if version == "" {
+		version = "v1"
+	}
+	value := header.Get(name)
+	if value == "" {
+		return time.Time{}, ErrWebhookSignature
+	}
+
+	timestamp := ""
+	signatures := []string{}
+	if s.TimestampHeader != "" {
+		timestamp = header.Get(s.TimestampHeader)
+		signatures = append(signatures, strings.TrimPrefix(value, version+"="))
+	} else {
+		for _, item := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if kv[0] == "t" {
+				timestamp = kv[1]
+			} else if kv[0] == version {
+				signatures = append(signatures, kv[1])
+			}
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return time.Time{}, ErrWebhookSignature
+	}
+
+	var data []byte
+	if s.Format != nil {
+		data = s.Format(timestamp, payload)
+	} else {
+		data = append([]byte(timestamp+"."), payload...)
+	}
+	if !webhookMatch(s.Secrets, data, s.Encoding, signatures) {
+		return time.Time{}, ErrWebhookSignature
+	}
+	// 毫秒时间戳
+	if seconds > 1e12 {
+		return time.Unix(0, seconds*int64(time.Millisecond)), nil
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// WebhookDedupe 按事件 ID 去重。Claim 取得处理权时返回空字符串，
+// 否则返回已有记录的状态 WebhookPending（其他请求正在处理）或 WebhookDone
+type WebhookDedupe interface {
+	Claim(key string, ttl time.Duration) (string, error)
+	// Complete 处理成功后标记为 WebhookDone 并保留 ttl
+	Complete(key string, ttl time.Duration) error
+	Release(key string) error
+}
+
+type memoryWebhookClaim struct {
+	status string
+	expire time.Time
+}
+
+type memoryWebhookDedupe struct {
+	lock   sync.Mutex
+	keys   map[string]memoryWebhookClaim
+	pruned time.Time
+}
+
+func NewMemoryWebhookDedupe() WebhookDedupe {
+	return &memoryWebhookDedupe{keys: map[string]memoryWebhookClaim{}}
+}
+
+func (d *memoryWebhookDedupe) Claim(key string, ttl time.Duration) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(d.pruned) > time.Minute {
+		for k, claim := range d.keys {
+			if now.After(claim.expire) {
+				delete(d.keys, k)
+			}
+		}
+		d.pruned = now
+	}
+	if claim, found := d.keys[key]; found && now.Before(claim.expire) {
+		return claim.status, nil
+	}
+	d.keys[key] = memoryWebhookClaim{status: WebhookPending, expire: now.Add(ttl)}
+	return "", nil
+}
+
+func (d *memoryWebhookDedupe) Complete(key string, ttl time.Duration) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.keys[key] = memoryWebhookClaim{status: WebhookDone, expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (d *memoryWebhookDedupe) Release(key string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.keys, key)
+	return nil
+}
+
+// RedisWebhookDedupe 多个实例共享去重记录，Client 为空时使用 rds.Default
+type RedisWebhookDedupe struct {
+	Client *redis.Client
+	Prefix string
+}
+
+func (d *RedisWebhookDedupe) client() (*redis.Client, error) {
+	if d.Client != nil {
+		return d.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis webhook dedupe needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (d *RedisWebhookDedupe) key(key string) string {
+	if d.Prefix == "" {
+		return "codex:webhook:event:" + key
+	}
+	return d.Prefix + key
+}
+
+func (d *RedisWebhookDedupe) Claim(key string, ttl time.Duration) (string, error) {
+	client, err := d.client()
+	if err != nil {
+		return "", err
+	}
+	ok, err := client.SetNX(d.key(key), WebhookPending, ttl).Result()
+	if err != nil || ok {
+		return "", err
+	}
+	status, err := client.Get(d.key(key)).Result()
+	if err == redis.Nil {
+		// 记录刚好过期，按处理中返回，对方稍后重试
+		return WebhookPending, nil
+	}
+	return status, err
+}
+
+func (d *RedisWebhookDedupe) Complete(key string, ttl time.Duration) error {
+	client, err := d.client()
+	if err != nil {
+		return err
+	}
+	return client.Set(d.key(key), WebhookDone, ttl).Err()
+}
+
+func (d *RedisWebhookDedupe) Release(key string) error {
+	client, err := d.client()
+	if err != nil {
+		return err
+	}
+	return client.Del(d.key(key)).Err()
+}
+
+type WebhookOptions struct {
+	// Router 默认 Default
+	Router gin.IRoutes
+	// Verifier 必须设置，确实不需要校验签名时设置 Insecure
+	Verifier WebhookVerifier
+	Insecure bool
+	// Tolerance 签名时间戳允许的偏差，默认 5 分钟，小于 0 时不检查
+	Tolerance time.Duration
+	// EventID 默认依次读取 X-Event-Id、X-Webhook-Id、X-GitHub-Delivery 头及 JSON 中的 id 字段，
+	// 取不到时不去重
+	EventID func(header http.Header, payload []byte) string
+	// Dedupe 为 nil 时有 rds.Default 使用 redis，否则使用内存
+	Dedupe WebhookDedupe
+	// DedupeTTL 处理成功后保留的时间，默认 24 小时
+	DedupeTTL time.Duration
+	// PendingTTL 处理中的记录保留的时间，期间重复投递返回 409，默认 10 分钟
+	PendingTTL time.Duration
+	// Store 保存原始内容用于重放，为 nil 时不保存
+	Store WebhookStore
+	// Async 收到后立即返回，后台处理并按 Retries 重试
+	Async   bool
+	Retries int
+	// RetryDelay 第一次重试的间隔，之后每次翻倍，默认 1 秒
+	RetryDelay time.Duration
+	// MaxBodySize 默认 1MB
+	MaxBodySize int64
+}
+
+// WebhookReceiver 由 Webhook 注册，可以用 Replay 重放保存的事件
+type WebhookReceiver struct {
+	Path    string
+	option  WebhookOptions
+	handler WebhookHandler
+}
+
+// Webhook 注册 POST path，校验签名、时间戳，按事件 ID 去重后调用 handler
+func Webhook(path string, option WebhookOptions, handler WebhookHandler) *WebhookReceiver {
+	if option.Verifier == nil && !option.Insecure {
+		panic(fmt.Errorf("webhook \"%s\" needs Verifier, set Insecure to skip signature check", path))
+	}
+	if option.Tolerance == 0 {
+		option.Tolerance = 5 * time.Minute
+	}
+	if option.EventID == nil {
+		option.EventID = defaultWebhookEventID
+	}
+	if option.Dedupe == nil {
+		if rds.Default != nil {
+			option.Dedupe = &RedisWebhookDedupe{}
+		} else {
+			option.Dedupe = NewMemoryWebhookDedupe()
+		}
+	}
+	if option.DedupeTTL <= 0 {
+		option.DedupeTTL = 24 * time.Hour
+	}
+	if option.PendingTTL <= 0 {
+		option.PendingTTL = 10 * time.Minute
+	}
+	if option.RetryDelay <= 0 {
+		option.RetryDelay = time.Second
+	}
+	if option.MaxBodySize <= 0 {
+		option.MaxBodySize = 1 << 20
+	}
+	router := option.Router
+	if router == nil {
+		router = Default
+	}
+
+	receiver := &WebhookReceiver{Path: path, option: option, handler: handler}
+	router.POST(path, receiver.handle)
+	return receiver
+}
+
+func defaultWebhookEventID(header http.Header, payload []byte) string {
+	for _, name := range []string{"X-Event-Id", "X-Webhook-Id", "X-GitHub-Delivery"} {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	val := struct {
+		ID interface{} `json:"id"`
+	}{}
+	if json.Unmarshal(payload, &val) == nil && val.ID != nil {
+		return fmt.Sprint(val.ID)
+	}
+	return ""
+}
+
+func (w *WebhookReceiver) dedupeKey(id string) string {
+	return w.Path + ":" + id
+}
+
+func (w *WebhookReceiver) handle(c *gin.Context) {
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, w.option.MaxBodySize))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, NewJSONResult().ParameterError(err.Error()))
+		return
+	}
+
+	event := &WebhookEvent{
+		Path:       w.Path,
+		Header:     c.Request.Header,
+		Payload:    payload,
+		ReceivedAt: time.Now(),
+		Status:     WebhookPending,
+	}
+	if w.option.Verifier != nil {
+		if event.Timestamp, err = w.option.Verifier.Verify(c.Request.Header, payload); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, NewJSONResult().ParameterError(err.Error()))
+			return
+		}
+		if !event.Timestamp.IsZero() && w.option.Tolerance > 0 {
+			skew := time.Since(event.Timestamp)
+			if skew < 0 {
+				skew = -skew
+			}
+			if skew > w.option.Tolerance {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, NewJSONResult().ParameterError(ErrWebhookStale.Error()))
+				return
+			}
+		}
+	}
+
+	event.ID = w.option.EventID(c.Request.Header, payload)
+	claimed := false
+	if event.ID != "" {
+		status, err := w.option.Dedupe.Claim(w.dedupeKey(event.ID), w.option.PendingTTL)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+			return
+		}
+		if status == WebhookPending {
+			// 第一次投递还在处理，结果未知，返回 409 让对方稍后重试
+			c.AbortWithStatusJSON(http.StatusConflict, NewJSONResult().ParameterError("webhook event is processing"))
+			return
+		}
+		if status != "" {
+			// 已经处理成功的重复投递直接返回成功，避免对方继续重试
+			c.JSON(http.StatusOK, NewJSONResult().Success("DUPLICATE"))
+			return
+		}
+		claimed = true
+	} else {
+		event.ID = uuid.NewV4().String()
+	}
+
+	if w.option.Store != nil {
+		if err := w.option.Store.Save(event); err != nil {
+			w.release(event, claimed)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+			return
+		}
+	}
+
+	if w.option.Async {
+		go func() {
+			if err := w.process(event, w.option.Retries); err != nil {
+				w.release(event, claimed)
+				triggerErrorHandles(uuid.NewV4().String(), err)
+				return
+			}
+			w.complete(event, claimed)
+		}()
+		c.JSON(http.StatusAccepted, NewJSONResult().Success())
+		return
+	}
+
+	if err := w.process(event, 0); err != nil {
+		// 释放去重记录，对方重试时可以再次处理
+		w.release(event, claimed)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewJSONResult().Error(err))
+		return
+	}
+	w.complete(event, claimed)
+	c.JSON(http.StatusOK, NewJSONResult().Success())
+}
+
+func (w *WebhookReceiver) complete(event *WebhookEvent, claimed bool) {
+	if claimed {
+		if err := w.option.Dedupe.Complete(w.dedupeKey(event.ID), w.option.DedupeTTL); err != nil {
+			triggerErrorHandles(uuid.NewV4().String(), err)
+		}
+	}
+}
+
+func (w *WebhookReceiver) release(event *WebhookEvent, claimed bool) {
+	if claimed {
+		w.option.Dedupe.Release(w.dedupeKey(event.ID))
+	}
+}
+
+// process 调用 handler，失败时按 RetryDelay 翻倍重试 retries 次，并更新保存的状态
+func (w *WebhookReceiver) process(event *WebhookEvent, retries int) error {
+	delay := w.option.RetryDelay
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		event.Attempts++
+		if err = w.invoke(event); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		event.Status = WebhookFailed
+		event.Error = err.Error()
+	} else {
+		event.Status = WebhookDone
+		event.Error = ""
+	}
+	if w.option.Store != nil {
+		if serr := w.option.Store.Save(event); serr != nil && err == nil {
+			return serr
+		}
+	}
+	return err
+}
+
+func (w *WebhookReceiver) invoke(event *WebhookEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("webhook handler panic:%v", r)
+		}
+	}()
+	return w.handler(event)
+}
+
+// Replay 从 Store 读取保存的事件重新处理，不做签名校验及去重
+func (w *WebhookReceiver) Replay(id string) error {
+	if w.option.Store == nil {
+		return fmt.Errorf("webhook \"%s\" has no store", w.Path)
+	}
+	event, err := w.option.Store.Load(w.Path, id)
+	if err != nil {
+		return err
+	}
+	if event == nil {
+		return fmt.Errorf("unknow webhook event \"%s\"", id)
+	}
+	return w.process(event, 0)
+}
+
+// WebhookStore 保存原始事件，Load 找不到时返回 nil, nil
+type WebhookStore interface {
+	Save(event *WebhookEvent) error
+	Load(path string, id string) (*WebhookEvent, error)
+}
+
+func webhookStoreKey(path string, id string) string {
+	sum := sha256.Sum256([]byte(path + "\n" + id))
+	return hex.EncodeToString(sum[:])
+}
+
+// DiskWebhookStore 每个事件保存为 Dir 下的一个 JSON 文件
+type DiskWebhookStore struct {
+	Dir string
+}
+
+func (s *DiskWebhookStore) filename(path string, id string) string {
+	return filepath.Join(s.Dir, webhookStoreKey(path, id)+".json")
+}
+
+func (s *DiskWebhookStore) Save(event *WebhookEvent) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	buff, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免读到写了一半的内容
+	filename := s.filename(event.Path, event.ID)
+	tmp := filename + "." + uuid.NewV4().String() + ".tmp"
+	if err := ioutil.WriteFile(tmp, buff, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func (s *DiskWebhookStore) Load(path string, id string) (*WebhookEvent, error) {
+	buff, err := ioutil.ReadFile(s.filename(path, id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	event := &WebhookEvent{}
+	if err := json.Unmarshal(buff, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// RedisWebhookStore Client 为空时使用 rds.Default
+type RedisWebhookStore struct {
+	Client *redis.Client
+	Prefix string
+	// Expiration 为 0 时不过期
+	Expiration time.Duration
+}
+
+func (s *RedisWebhookStore) client() (*redis.Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	if rds.Default == nil {
+		return nil, fmt.Errorf("redis webhook store needs rds.Default")
+	}
+	return rds.Default, nil
+}
+
+func (s *RedisWebhookStore) key(path string, id string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "codex:webhook:payload:"
+	}
+	return prefix + webhookStoreKey(path, id)
+}
+
+func (s *RedisWebhookStore) Save(event *WebhookEvent) error {
+	client, err := s.client()
+	if err != nil {
+		return err
+	}
+	buff, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return client.Set(s.key(event.Path, event.ID), buff, s.Expiration).Err()
+}
+
+func (s *RedisWebhookStore) Load(path string, id string) (*WebhookEvent, error) {
+	client, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	buff, err := client.Get(s.key(path, id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	event := &WebhookEvent{}
+	if err := json.Unmarshal(buff, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
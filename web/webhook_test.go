@@ -0,0 +1,144 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postWebhook(engine *gin.Engine, path string, payload string, header map[string]string) int {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(payload)))
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestWebhook_Signature(t *testing.T) {
+	engine := gin.New()
+	calls := 0
+	Webhook("/hmac", WebhookOptions{Router: engine, Verifier: &HMACSignature{Secrets: []string{"old", "new"}, Prefix: "sha256="}}, func(event *WebhookEvent) error {
+		calls++
+		return nil
+	})
+	Webhook("/stripe", WebhookOptions{Router: engine, Verifier: &TimestampSignature{Secrets: []string{"secret"}}}, func(event *WebhookEvent) error {
+		calls++
+		return nil
+	})
+
+	payload := `{"id":"evt_1"}`
+	if code := postWebhook(engine, "/hmac", payload, map[string]string{"X-Signature": "sha256=" + webhookSign("new", []byte(payload), "")}); code != http.StatusOK {
+		t.Fatalf("valid signature got %d", code)
+	}
+	if code := postWebhook(engine, "/hmac", payload, map[string]string{"X-Signature": "sha256=" + webhookSign("other", []byte(payload), "")}); code != http.StatusUnauthorized {
+		t.Fatalf("invalid signature got %d", code)
+	}
+
+	sign := func(timestamp time.Time, payload string) string {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		return fmt.Sprintf("t=%s,v1=%s", ts, webhookSign("secret", []byte(ts+"."+payload), ""))
+	}
+	if code := postWebhook(engine, "/stripe", payload, map[string]string{"X-Signature": sign(time.Now(), payload)}); code != http.StatusOK {
+		t.Fatalf("valid timestamp signature got %d", code)
+	}
+	// 签名正确但时间戳过期
+	stale := `{"id":"evt_2"}`
+	if code := postWebhook(engine, "/stripe", stale, map[string]string{"X-Signature": sign(time.Now().Add(-time.Hour), stale)}); code != http.StatusUnauthorized {
+		t.Fatalf("stale timestamp got %d", code)
+	}
+	if calls != 2 {
+		t.Fatalf("handler should be called twice, got %d", calls)
+	}
+}
+
+func TestWebhook_DedupeAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	engine := gin.New()
+	calls := 0
+	fail := true
+	receiver := Webhook("/events", WebhookOptions{Router: engine, Insecure: true, Store: &DiskWebhookStore{Dir: dir}}, func(event *WebhookEvent) error {
+		calls++
+		if fail {
+			return fmt.Errorf("handler error")
+		}
+		return nil
+	})
+
+	header := map[string]string{"X-Event-Id": "evt_1"}
+	if code := postWebhook(engine, "/events", "{}", header); code != http.StatusInternalServerError {
+		t.Fatalf("failed handler got %d", code)
+	}
+	// 处理失败时释放去重记录，重试可以再次处理
+	fail = false
+	if code := postWebhook(engine, "/events", "{}", header); code != http.StatusOK {
+		t.Fatalf("retry got %d", code)
+	}
+	if code := postWebhook(engine, "/events", "{}", header); code != http.StatusOK || calls != 2 {
+		t.Fatalf("duplicate should not call handler, got %d calls:%d", code, calls)
+	}
+
+	if err := receiver.Replay("evt_1"); err != nil || calls != 3 {
+		t.Fatalf("replay error %v calls:%d", err, calls)
+	}
+	event, err := (&DiskWebhookStore{Dir: dir}).Load("/events", "evt_1")
+	if err != nil || event.Status != WebhookDone || event.Attempts != 2 {
+		t.Fatalf("unexpected stored event %+v %v", event, err)
+	}
+	if err := receiver.Replay("missing"); err == nil {
+		t.Fatal("unknow event should fail")
+	}
+}
+
+func TestWebhook_Pending(t *testing.T) {
+	engine := gin.New()
+	started, finish := make(chan struct{}), make(chan struct{})
+	Webhook("/events", WebhookOptions{Router: engine, Insecure: true}, func(event *WebhookEvent) error {
+		close(started)
+		<-finish
+		return nil
+	})
+
+	header := map[string]string{"X-Event-Id": "evt_1"}
+	done := make(chan int)
+	go func() {
+		done <- postWebhook(engine, "/events", "{}", header)
+	}()
+	<-started
+	// 第一次投递处理中，重复投递返回 409 让对方重试
+	if code := postWebhook(engine, "/events", "{}", header); code != http.StatusConflict {
+		t.Fatalf("pending duplicate got %d", code)
+	}
+	close(finish)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("first delivery got %d", code)
+	}
+	if code := postWebhook(engine, "/events", "{}", header); code != http.StatusOK {
+		t.Fatalf("done duplicate got %d", code)
+	}
+}
+
+func TestWebhook_RequireVerifier(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("webhook without verifier should panic")
+		}
+	}()
+	Webhook("/events", WebhookOptions{Router: gin.New()}, func(event *WebhookEvent) error {
+		return nil
+	})
+}
@@ -0,0 +1,546 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/zhin/go-codex/database"
+	"github.com/zhin/go-codex/exthttp"
+)
+
+// 发送的请求头，接收方可以使用 web.TimestampSignature{Header: SignatureHeader} 校验签名，
+// web.Webhook 默认按 X-Webhook-Id 去重
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventIDHeader   = "X-Webhook-Id"
+	EventTypeHeader = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const (
+	DeliveryPending  = "pending"
+	DeliverySuccess  = "success"
+	DeliveryFailed   = "failed"
+	DeliveryDisabled = "disabled"
+)
+
+// Endpoint 订阅的地址，Dispatch 时保存到 webhook_endpoints，重试时从表中读取密钥
+type Endpoint struct {
+	ID     string `gorm:"column:id;primary_key"`
+	URL    string `gorm:"column:url"`
+	Secret string `gorm:"column:secret"`
+	// Failures 连续失败的请求次数，成功后清零
+	Failures   int        `gorm:"column:failures"`
+	Disabled   bool       `gorm:"column:disabled"`
+	DisabledAt *time.Time `gorm:"column:disabled_at"`
+	LastError  string     `gorm:"column:last_error;type:text"`
+	UpdatedAt  time.Time  `gorm:"column:updated_at"`
+}
+
+func (Endpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+// Delivery 一个事件发往一个地址的投递
+type Delivery struct {
+	ID         string `gorm:"column:id;primary_key"`
+	EventID    string `gorm:"column:event_id;index"`
+	EventType  string `gorm:"column:event_type"`
+	EndpointID string `gorm:"column:endpoint_id;index"`
+	URL        string `gorm:"column:url"`
+	Payload    string `gorm:"column:payload;type:text"`
+	Status     string `gorm:"column:status;index"`
+	// Attempts 已经发送的次数
+	Attempts      int       `gorm:"column:attempts"`
+	NextAttemptAt time.Time `gorm:"column:next_attempt_at;index"`
+	CreatedAt     time.Time `gorm:"column:created_at"`
+	UpdatedAt     time.Time `gorm:"column:updated_at"`
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Attempt 每次发送的记录
+type Attempt struct {
+	ID         int    `gorm:"column:id;primary_key;auto_increment"`
+	DeliveryID string `gorm:"column:delivery_id;index"`
+	Attempt    int    `gorm:"column:attempt"`
+	StatusCode int    `gorm:"column:status_code"`
+	Response   string `gorm:"column:response;type:text"`
+	Error      string `gorm:"column:error;type:text"`
+	// Duration 毫秒
+	Duration  int64     `gorm:"column:duration"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (Attempt) TableName() string {
+	return "webhook_attempts"
+}
+
+// Event 要发送的事件，Payload 为 []byte 时原样发送，否则编码为 JSON
+type Event struct {
+	// ID 为空时自动生成，接收方据此去重
+	ID      string
+	Type    string
+	Payload interface{}
+}
+
+type Options struct {
+	// Repo 默认 database.GetDefault()
+	Repo *database.DatabaseRepo
+	// Client 默认超时 30 秒的新客户端
+	Client *exthttp.HttpClient
+	// Schedule 第 n 次失败后等待 Schedule[n-1] 再重试，用完后投递失败，
+	// 默认 30s 起每次乘 4 共 6 次，约 11 小时
+	Schedule []time.Duration
+	// DisableAfter 地址连续失败的请求次数达到后停用，默认 20，小于 0 时不停用
+	DisableAfter int
+	// PollInterval Start 后检查到期投递的间隔，默认 10 秒
+	PollInterval time.Duration
+	// Workers 同时发送的投递数，默认 8
+	Workers int
+	// OnError Start 的后台任务出错时调用，默认输出到日志
+	OnError func(err error)
+	// MaxResponseSize 记录的响应内容长度，默认 1024
+	MaxResponseSize int
+}
+
+// Dispatcher 签名并投递事件，投递状态保存在数据库中，重启后继续重试
+type Dispatcher struct {
+	option Options
+
+	lock sync.Mutex
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewDispatcher(option Options) *Dispatcher {
+	if option.Repo == nil {
+		option.Repo = database.GetDefault()
+	}
+	if option.Client == nil {
+		option.Client = exthttp.NewHttpClient(exthttp.ClientOption{TimeoutSecond: 30})
+	}
+	if option.Schedule == nil {
+		delay := 30 * time.Second
+		for i := 0; i < 6; i++ {
+			option.Schedule = append(option.Schedule, delay)
+			delay *= 4
+		}
+	}
+	if option.DisableAfter == 0 {
+		option.DisableAfter = 20
+	}
+	if option.PollInterval <= 0 {
+		option.PollInterval = 10 * time.Second
+	}
+	if option.MaxResponseSize <= 0 {
+		option.MaxResponseSize = 1024
+	}
+	if option.Workers <= 0 {
+		option.Workers = 8
+	}
+	if option.OnError == nil {
+		option.OnError = func(err error) {
+			log.Println(fmt.Sprintf("webhook dispatcher error:%s", err.Error()))
+		}
+	}
+	return &Dispatcher{option: option, wake: make(chan struct{}, 1)}
+}
+
+// AutoMigrate 创建投递相关的表
+func (d *Dispatcher) AutoMigrate() error {
+	if err := d.option.Repo.AutoMigrate(&Endpoint{}, &Delivery{}, &Attempt{}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sign 返回签名头的值 t=时间戳,v1=HMAC-SHA256(时间戳.payload)
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Dispatch 保存订阅地址并为每个启用的地址创建投递，投递由 Start 的后台任务或 RunDue 发送
+func (d *Dispatcher) Dispatch(event Event, endpoints []Endpoint) ([]*Delivery, error) {
+	var payload []byte
+	switch val := event.Payload.(type) {
+	case []byte:
+		payload = val
+	case json.RawMessage:
+		payload = val
+	default:
+		buff, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		payload = buff
+	}
+	if event.ID == "" {
+		event.ID = uuid.NewV4().String()
+	}
+
+	now := time.Now().UTC()
+	deliveries := []*Delivery{}
+	for _, endpoint := range endpoints {
+		saved, err := d.saveEndpoint(endpoint)
+		if err != nil {
+			return deliveries, err
+		}
+		if saved.Disabled {
+			continue
+		}
+		delivery := &Delivery{
+			ID:            uuid.NewV4().String(),
+			EventID:       event.ID,
+			EventType:     event.Type,
+			EndpointID:    saved.ID,
+			URL:           saved.URL,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		if err := d.option.Repo.Create(delivery); err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	d.notify()
+	return deliveries, nil
+}
+
+// saveEndpoint 更新地址和密钥，保留失败次数及停用状态
+func (d *Dispatcher) saveEndpoint(endpoint Endpoint) (*Endpoint, error) {
+	if endpoint.ID == "" {
+		endpoint.ID = endpoint.URL
+	}
+	saved := &Endpoint{}
+	result := d.option.Repo.First(saved, "id = ?", endpoint.ID)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	now := time.Now().UTC()
+	if result.IsRecordNotFound {
+		endpoint.Failures = 0
+		endpoint.UpdatedAt = now
+		if endpoint.Disabled {
+			endpoint.DisabledAt = &now
+		}
+		if err := d.option.Repo.Create(&endpoint); err != nil {
+			return nil, err
+		}
+		return &endpoint, nil
+	}
+	if saved.URL != endpoint.URL || saved.Secret != endpoint.Secret {
+		saved.URL = endpoint.URL
+		saved.Secret = endpoint.Secret
+		saved.UpdatedAt = now
+		if err := d.option.Repo.Save(saved); err != nil {
+			return nil, err
+		}
+	}
+	return saved, nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start 启动后台任务，按 PollInterval 及 Dispatch 时发送到期的投递
+func (d *Dispatcher) Start() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stop != nil {
+		return
+	}
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go d.loop(d.stop, d.done)
+}
+
+// Stop 停止后台任务，等待正在发送的投递完成
+func (d *Dispatcher) Stop() {
+	d.lock.Lock()
+	stop, done := d.stop, d.done
+	d.stop, d.done = nil, nil
+	d.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (d *Dispatcher) loop(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(d.option.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.RunDue(); err != nil {
+			d.option.OnError(err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// RunDue 以 Workers 个并发发送到期的投递，返回发送的数量及第一个错误，可以在定时任务中代替 Start 调用
+func (d *Dispatcher) RunDue() (int, error) {
+	deliveries := []*Delivery{}
+	err := d.option.Repo.FindEX(&deliveries, database.SearchOption{
+		Where:  "status = ? AND next_attempt_at <= ?",
+		Params: []interface{}{DeliveryPending, time.Now().UTC()},
+		Order:  "next_attempt_at",
+		Limit:  100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	count := 0
+	workers := make(chan struct{}, d.option.Workers)
+	for _, delivery := range deliveries {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(delivery *Delivery) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			sent, err := d.attempt(delivery)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if sent {
+				count++
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return count, firstErr
+}
+
+// claim 以 attempts 作为版本号占用投递并预先设置下次重试时间，多个实例同时运行或进程中断时不会重复或丢失
+func (d *Dispatcher) claim(delivery *Delivery, next time.Time) (bool, error) {
+	claimed := false
+	err := d.option.Repo.InvokeTransation(func(db *gorm.DB) error {
+		result := db.Model(&Delivery{}).
+			Where("id = ? AND status = ? AND attempts = ?", delivery.ID, DeliveryPending, delivery.Attempts).
+			Updates(map[string]interface{}{"attempts": delivery.Attempts + 1, "next_attempt_at": next, "updated_at": time.Now().UTC()})
+		claimed = result.RowsAffected == 1
+		return result.Error
+	})
+	return claimed, err
+}
+
+func (d *Dispatcher) attempt(delivery *Delivery) (bool, error) {
+	endpoint := &Endpoint{}
+	result := d.option.Repo.First(endpoint, "id = ?", delivery.EndpointID)
+	if result.Err != nil {
+		return false, result.Err
+	}
+	if result.IsRecordNotFound || endpoint.Disabled {
+		return false, d.setStatus(delivery, DeliveryDisabled)
+	}
+
+	now := time.Now().UTC()
+	next := now
+	if delivery.Attempts < len(d.option.Schedule) {
+		next = now.Add(d.option.Schedule[delivery.Attempts])
+	}
+	claimed, err := d.claim(delivery, next)
+	if err != nil || !claimed {
+		return false, err
+	}
+	delivery.Attempts++
+	delivery.NextAttemptAt = next
+
+	record := d.send(delivery, endpoint)
+	if err := d.option.Repo.Create(record); err != nil {
+		return true, err
+	}
+
+	if record.Error == "" {
+		if err := d.option.Repo.Updates(&Endpoint{}, "id = ?", []interface{}{endpoint.ID}, map[string]interface{}{"failures": 0, "last_error": ""}); err != nil {
+			return true, err
+		}
+		return true, d.setStatus(delivery, DeliverySuccess)
+	}
+
+	if err := d.recordFailure(endpoint, record.Error); err != nil {
+		return true, err
+	}
+	if delivery.Attempts > len(d.option.Schedule) {
+		return true, d.setStatus(delivery, DeliveryFailed)
+	}
+	return true, nil
+}
+
+func (d *Dispatcher) send(delivery *Delivery, endpoint *Endpoint) *Attempt {
+	payload := []byte(delivery.Payload)
+	timestamp := time.Now()
+	acceptStatus := []int{}
+	for status := 200; status < 300; status++ {
+		acceptStatus = append(acceptStatus, status)
+	}
+	options := &exthttp.RequestOptions{
+		Headers: map[string]string{
+			"Content-Type":  "application/json",
+			SignatureHeader: Sign(endpoint.Secret, timestamp.Unix(), payload),
+			EventIDHeader:   delivery.EventID,
+			EventTypeHeader: delivery.EventType,
+			DeliveryHeader:  delivery.ID,
+		},
+		AcceptStatus: acceptStatus,
+	}
+
+	record := &Attempt{DeliveryID: delivery.ID, Attempt: delivery.Attempts}
+	resp, err := d.option.Client.RawRequestEX("POST", endpoint.URL, nil, payload, options)
+	record.Duration = int64(time.Since(timestamp) / time.Millisecond)
+	record.CreatedAt = timestamp.UTC()
+	var body []byte
+	if err != nil {
+		record.Error = err.Error()
+		if respErr, ok := err.(*exthttp.HttpResponseError); ok {
+			record.StatusCode = respErr.Status
+			body = respErr.ResponseData
+			record.Error = fmt.Sprintf("status %d", respErr.Status)
+		}
+	} else {
+		record.StatusCode = resp.StatusCode
+		body = resp.Body
+	}
+	if len(body) > d.option.MaxResponseSize {
+		body = body[:d.option.MaxResponseSize]
+	}
+	record.Response = string(body)
+	return record
+}
+
+// recordFailure 增加地址的失败次数，按表中的次数判断是否达到 DisableAfter，并发失败时不会少算
+func (d *Dispatcher) recordFailure(endpoint *Endpoint, message string) error {
+	attrs := map[string]interface{}{"failures": gorm.Expr("failures + 1"), "last_error": message}
+	if err := d.option.Repo.Updates(&Endpoint{}, "id = ?", []interface{}{endpoint.ID}, attrs); err != nil {
+		return err
+	}
+	if d.option.DisableAfter <= 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	return d.option.Repo.Updates(&Endpoint{}, "id = ? AND disabled = ? AND failures >= ?",
+		[]interface{}{endpoint.ID, false, d.option.DisableAfter}, map[string]interface{}{"disabled": true, "disabled_at": &now})
+}
+
+func (d *Dispatcher) setStatus(delivery *Delivery, status string) error {
+	delivery.Status = status
+	return d.option.Repo.Updates(&Delivery{}, "id = ?", []interface{}{delivery.ID}, map[string]interface{}{"status": status, "updated_at": time.Now().UTC()})
+}
+
+// Redeliver 立即重新投递，重试计划已经用完时只发送一次
+func (d *Dispatcher) Redeliver(deliveryID string) error {
+	delivery := &Delivery{}
+	result := d.option.Repo.First(delivery, "id = ?", deliveryID)
+	if result.Err != nil {
+		return result.Err
+	}
+	if result.IsRecordNotFound {
+		return fmt.Errorf("unknow webhook delivery \"%s\"", deliveryID)
+	}
+	endpoint := &Endpoint{}
+	if result := d.option.Repo.First(endpoint, "id = ?", delivery.EndpointID); result.Err != nil {
+		return result.Err
+	} else if result.IsRecordNotFound || endpoint.Disabled {
+		return fmt.Errorf("webhook endpoint \"%s\" disabled", delivery.EndpointID)
+	}
+	return d.reset(delivery.ID)
+}
+
+// RedeliverFailed 重新投递地址 since 之后失败或因停用取消的投递，通常在 EnableEndpoint 后调用
+func (d *Dispatcher) RedeliverFailed(endpointID string, since time.Time) (int, error) {
+	deliveries := []*Delivery{}
+	err := d.option.Repo.FindEX(&deliveries, database.SearchOption{
+		Where:  "endpoint_id = ? AND status IN (?) AND created_at >= ?",
+		Params: []interface{}{endpointID, []string{DeliveryFailed, DeliveryDisabled}, since.UTC()},
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, delivery := range deliveries {
+		if err := d.reset(delivery.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) reset(deliveryID string) error {
+	err := d.option.Repo.Updates(&Delivery{}, "id = ?", []interface{}{deliveryID}, map[string]interface{}{
+		"status":          DeliveryPending,
+		"next_attempt_at": time.Now().UTC(),
+		"updated_at":      time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+// EnableEndpoint 重新启用地址并清零失败次数
+func (d *Dispatcher) EnableEndpoint(endpointID string) error {
+	return d.option.Repo.Updates(&Endpoint{}, "id = ?", []interface{}{endpointID}, map[string]interface{}{
+		"disabled":    false,
+		"disabled_at": nil,
+		"failures":    0,
+	})
+}
+
+// DisableEndpoint 停用地址，未完成的投递在到期时取消
+func (d *Dispatcher) DisableEndpoint(endpointID string) error {
+	now := time.Now().UTC()
+	return d.option.Repo.Updates(&Endpoint{}, "id = ?", []interface{}{endpointID}, map[string]interface{}{
+		"disabled":    true,
+		"disabled_at": &now,
+	})
+}
+
+// Deliveries 查询事件的投递
+func (d *Dispatcher) Deliveries(eventID string) ([]*Delivery, error) {
+	deliveries := []*Delivery{}
+	err := d.option.Repo.Find(&deliveries, 0, 0, "event_id = ?", "created_at", eventID)
+	return deliveries, err
+}
+
+// Attempts 查询投递的发送记录
+func (d *Dispatcher) Attempts(deliveryID string) ([]*Attempt, error) {
+	attempts := []*Attempt{}
+	err := d.option.Repo.Find(&attempts, 0, 0, "delivery_id = ?", "attempt", deliveryID)
+	return attempts, err
+}
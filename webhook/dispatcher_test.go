@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/zhin/go-codex/database"
+)
+
+func setupDispatcher(t *testing.T, option Options) *Dispatcher {
+	dbKey := "webhook_" + t.Name()
+	database.SetDBSet(dbKey, database.DBSetOption{
+		DBType:             "sqlite3",
+		DBConnectionString: filepath.Join(t.TempDir(), "webhook.db"),
+		MaxOpenConns:       1,
+		MaxIdleConns:       1,
+	})
+	option.Repo = database.Choice(dbKey)
+	dispatcher := NewDispatcher(option)
+	if err := dispatcher.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return dispatcher
+}
+
+// runUntil 反复执行到期投递直到投递状态不再是 pending
+func runUntil(t *testing.T, dispatcher *Dispatcher, deliveryID string) *Delivery {
+	for i := 0; i < 100; i++ {
+		if _, err := dispatcher.RunDue(); err != nil {
+			t.Fatal(err)
+		}
+		delivery := &Delivery{}
+		if result := dispatcher.option.Repo.First(delivery, "id = ?", deliveryID); result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if delivery.Status != DeliveryPending {
+			return delivery
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("delivery still pending")
+	return nil
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+		signature := r.Header.Get(SignatureHeader)
+		timestamp, _ := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		if signature != Sign("secret", timestamp, payload) || r.Header.Get(EventIDHeader) != "evt_1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// 第一次失败，之后成功
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("busy"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dispatcher := setupDispatcher(t, Options{Schedule: []time.Duration{10 * time.Millisecond}})
+	deliveries, err := dispatcher.Dispatch(Event{ID: "evt_1", Type: "order.paid", Payload: map[string]interface{}{"order": 1}},
+		[]Endpoint{{ID: "customer", URL: server.URL, Secret: "secret"}})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatal(deliveries, err)
+	}
+
+	delivery := runUntil(t, dispatcher, deliveries[0].ID)
+	if delivery.Status != DeliverySuccess || delivery.Attempts != 2 {
+		t.Fatalf("delivery %s attempts %d", delivery.Status, delivery.Attempts)
+	}
+	attempts, err := dispatcher.Attempts(delivery.ID)
+	if err != nil || len(attempts) != 2 {
+		t.Fatal(attempts, err)
+	}
+	if attempts[0].StatusCode != 500 || attempts[0].Response != "busy" || attempts[1].StatusCode != 204 || attempts[1].Error != "" {
+		t.Errorf("unexpected attempts %+v %+v", attempts[0], attempts[1])
+	}
+}
+
+func TestDispatcher_DisableAndRedeliver(t *testing.T) {
+	var healthy int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	dispatcher := setupDispatcher(t, Options{Schedule: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}, DisableAfter: 2})
+	endpoints := []Endpoint{{ID: "customer", URL: server.URL, Secret: "secret"}}
+	deliveries, err := dispatcher.Dispatch(Event{Type: "order.paid", Payload: []byte(`{}`)}, endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := runUntil(t, dispatcher, deliveries[0].ID)
+	if delivery.Status != DeliveryDisabled || delivery.Attempts != 2 {
+		t.Fatalf("delivery %s attempts %d", delivery.Status, delivery.Attempts)
+	}
+	if deliveries, _ := dispatcher.Dispatch(Event{Payload: []byte(`{}`)}, endpoints); len(deliveries) != 0 {
+		t.Error("disabled endpoint should not receive deliveries")
+	}
+	if err := dispatcher.Redeliver(delivery.ID); err == nil {
+		t.Error("redeliver to disabled endpoint should fail")
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	if err := dispatcher.EnableEndpoint("customer"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := dispatcher.RedeliverFailed("customer", time.Now().Add(-time.Hour)); err != nil || count != 1 {
+		t.Fatal(count, err)
+	}
+	if delivery := runUntil(t, dispatcher, delivery.ID); delivery.Status != DeliverySuccess || delivery.Attempts != 3 {
+		t.Fatalf("delivery %s attempts %d", delivery.Status, delivery.Attempts)
+	}
+}
+
+func TestDispatcher_Concurrent(t *testing.T) {
+	// 三个请求同时到达才返回，顺序发送时会超时
+	var arrived int32
+	all := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&arrived, 1) == 3 {
+			close(all)
+		}
+		select {
+		case <-all:
+		case <-time.After(2 * time.Second):
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dispatcher := setupDispatcher(t, Options{Workers: 3, DisableAfter: 3})
+	endpoints := []Endpoint{{ID: "customer", URL: server.URL, Secret: "secret"}}
+	for i := 0; i < 3; i++ {
+		if _, err := dispatcher.Dispatch(Event{Payload: []byte(`{}`)}, endpoints); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if count, err := dispatcher.RunDue(); err != nil || count != 3 {
+		t.Fatal(count, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("deliveries should be sent concurrently, took %s", elapsed)
+	}
+
+	// 同时失败的次数都计入，达到 DisableAfter 后停用
+	endpoint := &Endpoint{}
+	if result := dispatcher.option.Repo.First(endpoint, "id = ?", "customer"); result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if endpoint.Failures != 3 || !endpoint.Disabled {
+		t.Fatalf("unexpected endpoint failures %d disabled %v", endpoint.Failures, endpoint.Disabled)
+	}
+}
+
+func TestDispatcher_OnError(t *testing.T) {
+	var once sync.Once
+	errs := make(chan error, 1)
+	dispatcher := setupDispatcher(t, Options{PollInterval: 10 * time.Millisecond, OnError: func(err error) {
+		once.Do(func() { errs <- err })
+	}})
+	if err := dispatcher.option.Repo.Exec("DROP TABLE webhook_deliveries"); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Start()
+	defer dispatcher.Stop()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "webhook_deliveries") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("background error should be reported")
+	}
+}